@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/HomelessHunter/CTC/db"
+	dbModels "github.com/HomelessHunter/CTC/db/models"
+	"github.com/HomelessHunter/CTC/wrapper"
+	other "github.com/HomelessHunter/CTC/wrapper/models/other"
+	models "github.com/HomelessHunter/CTC/wrapper/models/telegram"
+)
+
+// popularPairs fill the pair suggestions after the user's own pairs
+var popularPairs = []string{"btcusdt", "ethusdt", "bnbusdt", "solusdt", "xrpusdt", "dogeusdt"}
+
+const maxSuggestions = 6
+
+// startConversation begins creating an alert step by step for a bare /alert,
+// a conversation already going on in the chat starts over
+func startConversation(tg *models.Client, store db.Store, userID int64, chatID int64, ctx context.Context) error {
+	conv, err := dbModels.NewConversation(dbModels.WithConvChatID(chatID), dbModels.WithConvUserID(userID))
+	if err != nil {
+		return fmt.Errorf("startConversation: %s", err)
+	}
+	err = store.SaveConversation(conv, ctx)
+	if err != nil {
+		return err
+	}
+	return wrapper.AskPair(tg, chatID, pairSuggestions(store, userID, ctx))
+}
+
+// pairSuggestions puts the pairs the user already watched first
+func pairSuggestions(store db.AlertStore, userID int64, ctx context.Context) []string {
+	var pairs []string
+	alerts, err := store.GetAlerts(userID, ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	history, err := store.GetHistory(userID, ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	for _, v := range append(alerts, history...) {
+		pairs = append(pairs, v.Pair)
+	}
+	pairs = append(pairs, popularPairs...)
+
+	seen := make(map[string]bool)
+	suggestions := make([]string, 0, maxSuggestions)
+	for _, v := range pairs {
+		if seen[v] || len(suggestions) == maxSuggestions {
+			continue
+		}
+		seen[v] = true
+		suggestions = append(suggestions, v)
+	}
+	return suggestions
+}
+
+// converse moves the chat's conversation on. action is the step a button answers
+// or "" for a typed answer to the current step. It reports whether the chat has a conversation,
+// so other messages can be handled as usual
+func converse(
+	tg *models.Client, client *http.Client, store db.Store,
+	userID int64, chatID int64, action string, input string, ctx context.Context,
+) (bool, error) {
+	conv, err := store.GetConversation(chatID, ctx)
+	if err == db.ErrNoConversation {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if conv.Expired(time.Now()) {
+		err = store.DeleteConversation(chatID, ctx)
+		if err != nil {
+			return true, err
+		}
+		return true, wrapper.SendConversationEnd(tg, chatID, fmt.Sprintf("It took more than %s, type /alert to start again", dbModels.ConversationTimeout))
+	}
+	if action == wrapper.ConvCancel {
+		err = store.DeleteConversation(chatID, ctx)
+		if err != nil {
+			return true, err
+		}
+		return true, wrapper.SendConversationEnd(tg, chatID, "Cancelled")
+	}
+
+	switch conv.Step {
+	case dbModels.StepPair:
+		if action != "" && action != wrapper.ConvPair {
+			return true, nil
+		}
+		pair, market, err := wrapper.ResolvePair(tg, client, chatID, input)
+		if err != nil {
+			// the user gets another try
+			fmt.Fprintln(os.Stderr, err)
+			return true, nil
+		}
+		conv.Pair, conv.Market = pair, market
+		conv.Next(dbModels.StepCondition)
+		err = store.SaveConversation(conv, ctx)
+		if err != nil {
+			return true, err
+		}
+		return true, wrapper.AskCondition(tg, chatID, pair)
+	case dbModels.StepCondition:
+		if action != "" && action != wrapper.ConvCondition {
+			return true, nil
+		}
+		condition, err := dbModels.ParseCondition(input)
+		if err != nil {
+			return true, wrapper.SendConversationErr(tg, chatID, err)
+		}
+		conv.Condition = condition
+		conv.Next(dbModels.StepPrice)
+		err = store.SaveConversation(conv, ctx)
+		if err != nil {
+			return true, err
+		}
+		return true, wrapper.AskPrice(tg, chatID, conv.Pair, condition)
+	case dbModels.StepPrice:
+		if action != "" {
+			return true, nil
+		}
+		price, err := wrapper.ParseTargetPrice(input)
+		if err != nil {
+			return true, wrapper.SendConversationErr(tg, chatID, err)
+		}
+		wsQuery, err := other.NewWsQuery(
+			other.WithWSUserId(userID),
+			other.WithWSChatId(chatID),
+			other.WithWSMarket(conv.Market),
+			other.WithWSPair(conv.Pair),
+			other.WithWSPrice(price),
+			other.WithWSCondition(conv.Condition),
+		)
+		if err != nil {
+			return true, fmt.Errorf("converse: %s", err)
+		}
+		err = store.DeleteConversation(chatID, ctx)
+		if err != nil {
+			return true, err
+		}
+		return true, alertHandler(tg, wsQuery, ctx, store)
+	}
+	return true, fmt.Errorf("converse: unknown step %s", conv.Step)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HomelessHunter/CTC/db"
+	dbModels "github.com/HomelessHunter/CTC/db/models"
+	"github.com/HomelessHunter/CTC/wrapper"
+	"github.com/HomelessHunter/CTC/wrapper/models/telegram/telegramtest"
+)
+
+func TestStartConversation(t *testing.T) {
+	srv := telegramtest.NewServer()
+	defer srv.Close()
+	store := db.NewMemoryStore()
+	alert, _ := dbModels.NewAlert(dbModels.WithMarket("huobi"), dbModels.WithPair("adausdt"), dbModels.WithTargetPrice(1))
+	store.AddAlert(1, alert, context.TODO())
+
+	err := startConversation(srv.TGClient(), store, 1, 10, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conv, err := store.GetConversation(10, context.TODO())
+	if err != nil || conv.Step != dbModels.StepPair || conv.UserID != 1 {
+		t.Fatalf("unexpected conversation %v (%v)", conv, err)
+	}
+	keyboard := srv.Sent()[0].ReplyMarkup.InlineKeyboard
+	if keyboard[0][0].CallbackData != "conv pair adausdt" {
+		t.Errorf("user's pairs should be suggested first, got %v", keyboard[0])
+	}
+	if last := keyboard[len(keyboard)-1]; last[0].CallbackData != "conv cancel" {
+		t.Errorf("cancel should come last, got %v", last)
+	}
+}
+
+func TestConverse(t *testing.T) {
+	srv := telegramtest.NewServer()
+	defer srv.Close()
+	tg := srv.TGClient()
+	store := db.NewMemoryStore()
+	ctx := context.TODO()
+
+	ok, err := converse(tg, srv.Client(), store, 1, 10, "", "hello", ctx)
+	if ok || err != nil {
+		t.Fatalf("chat without a conversation should be left alone, got %v %v", ok, err)
+	}
+
+	conv, _ := dbModels.NewConversation(dbModels.WithConvChatID(10), dbModels.WithConvUserID(1))
+	conv.Pair, conv.Market = "btcusdt", "binance"
+	conv.Next(dbModels.StepCondition)
+	store.SaveConversation(conv, ctx)
+
+	// a suggestion tapped after the pair was chosen is stale
+	converse(tg, srv.Client(), store, 1, 10, wrapper.ConvPair, "ethusdt", ctx)
+	if conv, _ = store.GetConversation(10, ctx); conv.Step != dbModels.StepCondition || conv.Pair != "btcusdt" {
+		t.Errorf("stale button moved the conversation: %v", conv)
+	}
+
+	ok, err = converse(tg, srv.Client(), store, 1, 10, wrapper.ConvCondition, dbModels.ConditionAbove, ctx)
+	if !ok || err != nil {
+		t.Fatal(err)
+	}
+	conv, _ = store.GetConversation(10, ctx)
+	if conv.Step != dbModels.StepPrice || conv.Condition != dbModels.ConditionAbove {
+		t.Errorf("unexpected conversation %v", conv)
+	}
+
+	converse(tg, srv.Client(), store, 1, 10, "", "seventy", ctx)
+	if conv, _ = store.GetConversation(10, ctx); conv.Step != dbModels.StepPrice {
+		t.Errorf("a bad price should be asked again, got %v", conv)
+	}
+	sent := srv.Sent()
+	if !strings.Contains(sent[len(sent)-1].Text, "positive number") {
+		t.Errorf("user should be told why, got %s", sent[len(sent)-1].Text)
+	}
+
+	converse(tg, srv.Client(), store, 1, 10, wrapper.ConvCancel, "", ctx)
+	if _, err = store.GetConversation(10, ctx); err != db.ErrNoConversation {
+		t.Errorf("cancelled conversation should be gone, got %v", err)
+	}
+}
+
+func TestConverseTimeout(t *testing.T) {
+	srv := telegramtest.NewServer()
+	defer srv.Close()
+	store := db.NewMemoryStore()
+
+	conv, _ := dbModels.NewConversation(dbModels.WithConvChatID(10), dbModels.WithConvUserID(1))
+	conv.UpdatedAt = time.Now().Add(-dbModels.ConversationTimeout - time.Minute)
+	store.SaveConversation(conv, context.TODO())
+
+	ok, err := converse(srv.TGClient(), srv.Client(), store, 1, 10, "", "btcusdt", context.TODO())
+	if !ok || err != nil {
+		t.Fatalf("unexpected result %v %v", ok, err)
+	}
+	if _, err = store.GetConversation(10, context.TODO()); err != db.ErrNoConversation {
+		t.Errorf("expired conversation should be gone, got %v", err)
+	}
+	if sent := srv.Sent(); len(sent) != 1 || !strings.Contains(sent[0].Text, "/alert") {
+		t.Errorf("user should be told to start again, got %v", sent)
+	}
+}
@@ -1,9 +1,8 @@
 package main
 
 import (
-	"bytes"
-	"compress/gzip"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -26,16 +25,22 @@ import (
 	other "github.com/HomelessHunter/CTC/wrapper/models/other"
 	models "github.com/HomelessHunter/CTC/wrapper/models/telegram"
 	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var errAlertExist = errors.New("alert already exists")
+
+// catalogRefresh is how often listed pairs are reloaded from the exchanges
+const catalogRefresh = time.Hour
+
 var (
-	uc           *other.UC
-	userChannels map[int64]*other.UserChannels
-	regexps      map[string]*regexp.Regexp
-	session      *other.Session
-	wg           sync.WaitGroup
+	regexps map[string]*regexp.Regexp
+	session *other.Session
+	// hubs hold one shared websocket per market
+	hubs map[string]*wrapper.Hub
+	wg   sync.WaitGroup
 )
 
 func main() {
@@ -45,6 +50,35 @@ func main() {
 		// log.Fatal("$PORT must be set")
 		port = "8080"
 	}
+	// TG_MODE is either "webhook" (default) or "polling"
+	mode := os.Getenv("TG_MODE")
+	if mode == "" {
+		mode = "webhook"
+	}
+	webhookURL := os.Getenv("WEBHOOK_URL")
+	if mode == "webhook" && webhookURL == "" {
+		log.Fatal("$WEBHOOK_URL must be set in webhook mode")
+	}
+	if mode != "webhook" && mode != "polling" {
+		log.Fatalf("unknown TG_MODE %q", mode)
+	}
+	// TG_SECRET is echoed by Telegram in every webhook call, a random one is used when it's not set
+	secret := os.Getenv("TG_SECRET")
+	if secret == "" {
+		token, err := newSecretToken()
+		if err != nil {
+			log.Fatal(err)
+		}
+		secret = token
+	}
+	pollTimeout := 30 * time.Second
+	if v := os.Getenv("TG_POLL_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("TG_POLL_TIMEOUT: %s", err)
+		}
+		pollTimeout = d
+	}
 
 	shutdownCtx, cancel := context.WithCancel(context.Background())
 	dialer := &websocket.Dialer{
@@ -63,21 +97,62 @@ func main() {
 			WriteBufferSize:       256,
 		},
 	}
-	mongoClient, err := mongo.Connect(shutdownCtx, options.Client().ApplyURI(os.Getenv("MONGO_URI")))
+	tg, err := models.NewClient(os.Getenv("TG"), models.WithClientHTTP(client))
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
+	}
+	// STORE=memory keeps users and alerts in memory only, they're lost on restart
+	var store db.Store
+	var mongoClient *mongo.Client
+	switch os.Getenv("STORE") {
+	case "memory":
+		fmt.Println("STORE=memory, alerts are lost on restart")
+		store = db.NewMemoryStore()
+	case "", "mongo":
+		uri := os.Getenv("MONGO_URI")
+		if uri == "" {
+			log.Fatal("$MONGO_URI must be set, or STORE=memory to keep alerts in memory")
+		}
+		mongoClient, err = mongo.Connect(shutdownCtx, options.Client().ApplyURI(uri))
+		if err != nil {
+			panic(err)
+		}
+		store = db.NewMongoStore(mongoClient)
+	default:
+		log.Fatalf("unknown STORE %q", os.Getenv("STORE"))
 	}
 
-	coll := db.GetUserCollection(mongoClient)
+	var outbox *wrapper.Outbox
+	outbox, err = wrapper.NewOutbox(tg, wrapper.WithOutboxOnFailure(func(msg *models.SendMsgObj, err error) {
+		if models.IsForbidden(err) {
+			suspendChat(outbox, store, msg.ChatId, shutdownCtx)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "outbox: %s\n", err)
+	}))
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	regexps = compileRegexp()
-	uc = other.NewUC()
 	session = other.NewSession()
+	hubs = make(map[string]*wrapper.Hub)
+	// window is the price history change alerts are measured on, shared by every market
+	window := wrapper.NewPriceWindow()
+	for _, exchange := range wrapper.Exchanges() {
+		hub, err := wrapper.NewHub(exchange, dialer, checkPrice(outbox, store, window, shutdownCtx), wrapper.WithHubOnDown(func(market string, err error) {
+			fmt.Fprintf(os.Stderr, "%s is down until a new alert reconnects it: %s\n", market, err)
+		}))
+		if err != nil {
+			log.Fatal(err)
+		}
+		hubs[exchange.Name()] = hub
+	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc(fmt.Sprintf("/%s", os.Getenv("TG")), updateFromTG(client, dialer, coll, shutdownCtx))
-	// For testing only
-	// mux.HandleFunc("/update", createUpdate(dialer, client, shutdownCtx, coll))
+	mux.HandleFunc(fmt.Sprintf("/%s", os.Getenv("TG")), updateFromTG(secret, newRecentUpdates(1000), func(update *models.Update) {
+		handleUpdate(tg, client, store, shutdownCtx, update)
+	}))
 
 	server := &http.Server{
 		Addr:        fmt.Sprintf(":%s", port),
@@ -87,21 +162,30 @@ func main() {
 		IdleTimeout: 30 * time.Second,
 	}
 
+	wg.Add(1)
 	go func() {
-		wg.Add(1)
 		defer wg.Done()
 		sigs := make(chan os.Signal, 1)
 		signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGTSTP)
 		<-sigs
 
 		if len(session.Alerts()) > 0 {
-			err := db.ShutdownSequence(coll, session.Alerts(), session.AlertsCount(), shutdownCtx)
+			err := store.ShutdownSequence(session.Alerts(), session.AlertsCount(), shutdownCtx)
 			if err != nil {
 				fmt.Printf("cannot initiate shutdowns sequence: %s\n", err)
 			}
 		}
 
-		mongoClient.Disconnect(shutdownCtx)
+		for _, hub := range hubs {
+			hub.Close()
+		}
+		// alerts that already fired still deserve a notification
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		outbox.Close(flushCtx)
+		flushCancel()
+		if mongoClient != nil {
+			mongoClient.Disconnect(shutdownCtx)
+		}
 		client.CloseIdleConnections()
 		cancel()
 		if err := server.Shutdown(shutdownCtx); err != nil {
@@ -109,16 +193,36 @@ func main() {
 		}
 	}()
 
-	err = startSqc(coll, dialer, client, shutdownCtx)
+	// the catalog only feeds "Did you mean" suggestions, a stale one is fine
+	go wrapper.DefaultCatalog.Run(shutdownCtx, client, catalogRefresh)
+
+	err = startSqc(store, shutdownCtx)
 	if err != nil {
 		log.Panic(err)
 	}
 
 	fmt.Println("Connected")
 
-	wrapper.SetWebhook(client)
-	if err := server.ListenAndServe(); err != nil {
-		fmt.Println(err)
+	switch mode {
+	case "polling":
+		err = tg.DeleteWebhook(shutdownCtx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		// the shared client gives up on headers long before a poll returns
+		pollTG, err := models.NewClient(os.Getenv("TG"), models.WithClientHTTP(&http.Client{Timeout: pollTimeout + 10*time.Second}))
+		if err != nil {
+			log.Fatal(err)
+		}
+		pollUpdates(pollTG, tg, client, store, shutdownCtx, pollTimeout)
+	default:
+		err = tg.SetWebhook(shutdownCtx, fmt.Sprintf("%s/%s", strings.TrimSuffix(webhookURL, "/"), os.Getenv("TG")), secret)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if err := server.ListenAndServe(); err != nil {
+			fmt.Println(err)
+		}
 	}
 
 	completed := make(chan int)
@@ -135,11 +239,12 @@ func main() {
 	}
 }
 
-func startSqc(
-	coll *mongo.Collection, dialer *websocket.Dialer,
-	client *http.Client, ctx context.Context,
-) error {
-	users, err := db.GetUsersWithPairs(coll, false, ctx)
+func startSqc(store db.Store, ctx context.Context) error {
+	err := store.Init(ctx)
+	if err != nil {
+		return err
+	}
+	users, err := store.GetUsersWithPairs(false, ctx)
 	if err != nil {
 		fmt.Printf("starSqc: %s", err)
 	}
@@ -147,538 +252,329 @@ func startSqc(
 		return nil
 	}
 	for _, v := range users {
-		dbModels.SortByMarket(v.Alerts)
-
-		userChan, err := other.NewUserChannels(
-			other.WithUCCancel(make(map[string]context.CancelFunc)),
-			other.WithUCShutdown(make(map[string]chan int)),
-			other.WithUCSubscribeCh(make(map[string]chan other.PairSignal)),
-			other.WithUCUnsubscribeCh(make(map[string]chan other.PairSignal)),
-		)
-		if err != nil {
-			fmt.Printf("userChan: %s", err)
-		}
-		session.InitMarketsByID(v.UsedID)
-
-		if dbModels.AlertExist(v.Alerts, wrapper.Binance) {
-			wsQuery := &other.WSQuery{UserId: v.UsedID, Market: wrapper.Binance}
-			userChan.SetShutdownCh(wsQuery.Market, make(chan int, 1))
-			userChan.SetSubscriberCh(wsQuery.Market, make(chan other.PairSignal, 1))
-			userChan.SetUnsubscriberCh(wsQuery.Market, make(chan other.PairSignal, 1))
-
-			uc.SetUC(v.UsedID, userChan)
-			alerts, err := connectToWS(coll, dialer, client, wsQuery, ctx)
+		session.SetChatID(v.UsedID, v.ChatID)
+		alerts := make([]dbModels.Alert, 0, len(v.Alerts))
+		for _, alert := range v.Alerts {
+			err := watchAlert(alert)
 			if err != nil {
-				if err == db.NoPairsErr {
-				} else {
-					userChan.DeleteShutdownCh(wsQuery.Market)
-					userChan.DeleteSubscribeCh(wsQuery.Market)
-					userChan.DeleteUnsubscribeCh(wsQuery.Market)
-					return err
-				}
-			}
-			if len(alerts) > 0 {
-				session.AddAlerts(wsQuery.UserId, alerts...)
+				fmt.Fprintf(os.Stderr, "startSqc: %s\n", err)
+				continue
 			}
+			alerts = append(alerts, alert)
 		}
-
-		if dbModels.AlertExist(v.Alerts, wrapper.Huobi) {
-			wsQuery := &other.WSQuery{UserId: v.UsedID, Market: wrapper.Huobi}
-			userChan.SetShutdownCh(wsQuery.Market, make(chan int, 1))
-			userChan.SetSubscriberCh(wsQuery.Market, make(chan other.PairSignal, 1))
-			userChan.SetUnsubscriberCh(wsQuery.Market, make(chan other.PairSignal, 1))
-
-			if _, ok := uc.GetUserChannels(v.UsedID); !ok {
-				uc.SetUC(v.UsedID, userChan)
-			}
-			alerts, err := connectToWS(coll, dialer, client, &other.WSQuery{UserId: v.UsedID, Market: wrapper.Huobi}, ctx)
-			if err != nil {
-				if err == db.NoPairsErr {
-				} else {
-					userChan.DeleteShutdownCh(wsQuery.Market)
-					userChan.DeleteSubscribeCh(wsQuery.Market)
-					userChan.DeleteUnsubscribeCh(wsQuery.Market)
-					return err
-				}
-			}
-			if len(alerts) > 0 {
-				session.AddAlerts(wsQuery.UserId, alerts...)
-			}
+		if len(alerts) == 0 {
+			continue
+		}
+		err = store.UpdateAlertsSqc(v.UsedID, alerts, true, ctx)
+		if err != nil {
+			return err
 		}
+		session.AddAlerts(v.UsedID, alerts...)
 	}
 	return nil
 }
 
-func alertHandler(
-	dialer *websocket.Dialer, client *http.Client,
-	wsQuery *other.WSQuery, ctx context.Context,
-	coll *mongo.Collection,
-) error {
-	var alert *dbModels.Alert
-	var err error
-
-	alerts, err := db.GetAlerts(coll, wsQuery.UserId, ctx)
-	if err != nil {
-		return err
+// watchAlert subscribes the alert's pair on the shared market hub
+func watchAlert(alert dbModels.Alert) error {
+	hub, ok := hubs[alert.Market]
+	if !ok {
+		return fmt.Errorf("no hub for market %s", alert.Market)
 	}
+	return hub.Acquire(alert.Pair)
+}
 
-	// Check if Pair already exists
-	if pairExist(wsQuery.Market, wsQuery.Pair, alerts) {
-		// Send user response
-		return errors.New("pair already exists")
+func unwatchAlert(alert dbModels.Alert) error {
+	hub, ok := hubs[alert.Market]
+	if !ok {
+		return fmt.Errorf("no hub for market %s", alert.Market)
 	}
+	return hub.Release(alert.Pair)
+}
 
-	// Check if user have connected previously
-	// If false cancel that connection and add a new token pair
-	userChannel, ok := uc.GetUserChannels(wsQuery.UserId)
-	if ok {
-		alert, err = dbModels.NewAlert(dbModels.WithPair(strings.ToLower(wsQuery.Pair)), dbModels.WithTargetPrice(wsQuery.Price), dbModels.WithMarket(wsQuery.Market))
-		if err != nil {
-			return fmt.Errorf("cannot create new alert: %s", err)
-		}
-
-		if session.MarketExist(wsQuery.UserId, wsQuery.Market) {
-			alert.Connected = true
-			err = db.AddAlert(coll, wsQuery.UserId, alert, ctx)
-			if err != nil {
-				return fmt.Errorf("cannot add alert: %s", err)
-			}
-			session.AddAlerts(wsQuery.UserId, *alert)
-			userChannel.SubscribeSignal(wsQuery.Market, other.PairSignal{Pair: wsQuery.Pair, Size: len(session.AlertsByID(wsQuery.UserId)) + 1})
-			return nil
-		}
-		userChannel.SetShutdownCh(wsQuery.Market, make(chan int, 1))
-		userChannel.SetSubscriberCh(wsQuery.Market, make(chan other.PairSignal, 1))
-		userChannel.SetUnsubscriberCh(wsQuery.Market, make(chan other.PairSignal, 1))
-		// userChannels[wsQuery.UserId] = userChannel
-		err = db.AddAlert(coll, wsQuery.UserId, alert, ctx)
-		if err != nil {
-			return fmt.Errorf("cannot add alert: %s", err)
-		}
-	} else {
-		alert, err = dbModels.NewAlert(dbModels.WithPair(strings.ToLower(wsQuery.Pair)), dbModels.WithTargetPrice(wsQuery.Price), dbModels.WithMarket(wsQuery.Market))
-		if err != nil {
-			return fmt.Errorf("cannot create new alert: %s", err)
-		}
-		err = db.AddAlert(coll, wsQuery.UserId, alert, ctx)
+// suspendChat stops watching the alerts of a user the bot can't write to anymore,
+// they're kept suspended until the user sends /start again
+func suspendChat(outbox *wrapper.Outbox, store db.AlertStore, chatID int64, ctx context.Context) {
+	outbox.Drop(chatID)
+	userID, ok := session.UserByChat(chatID)
+	if !ok {
+		return
+	}
+	// concurrent failures of the same chat race here, only one of them gets the alerts
+	alerts := session.TakeAlerts(userID)
+	if len(alerts) == 0 {
+		return
+	}
+	err := store.SuspendAlerts(userID, ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	for _, v := range alerts {
+		err = unwatchAlert(v)
 		if err != nil {
-			return fmt.Errorf("cannot add alert: %s", err)
+			fmt.Fprintln(os.Stderr, err)
 		}
+	}
+	fmt.Printf("suspended %d alerts of %d\n", len(alerts), userID)
+}
 
-		userChan, err := other.NewUserChannels(
-			other.WithUCCancel(make(map[string]context.CancelFunc)),
-			other.WithUCShutdown(make(map[string]chan int)),
-			other.WithUCSubscribeCh(make(map[string]chan other.PairSignal)),
-			other.WithUCUnsubscribeCh(make(map[string]chan other.PairSignal)),
-		)
+// resumeAlerts watches the suspended alerts of a user who came back
+func resumeAlerts(store db.AlertStore, userID int64, chatID int64, ctx context.Context) error {
+	suspended, err := store.ResumeAlerts(userID, ctx)
+	if err != nil {
+		return err
+	}
+	session.SetChatID(userID, chatID)
+	alerts := make([]dbModels.Alert, 0, len(suspended))
+	for _, alert := range suspended {
+		err := watchAlert(alert)
 		if err != nil {
-			fmt.Printf("userChan: %s", err)
+			fmt.Fprintf(os.Stderr, "resumeAlerts: %s\n", err)
+			continue
 		}
-
-		userChan.SetShutdownCh(wsQuery.Market, make(chan int, 1))
-		userChan.SetSubscriberCh(wsQuery.Market, make(chan other.PairSignal, 1))
-		userChan.SetUnsubscriberCh(wsQuery.Market, make(chan other.PairSignal, 1))
-
-		session.InitMarketsByID(wsQuery.UserId)
-
-		// userChannels[wsQuery.UserId] = userChan
-		// !!!!!!!!!!!!!!!!!!!!!!!!!!
-		uc.SetUC(wsQuery.UserId, userChan)
-
+		alerts = append(alerts, alert)
 	}
-	// fmt.Println(userChannels[wsQuery.UserId])
-
-	_, err = connectToWS(coll, dialer, client, wsQuery, ctx)
+	if len(alerts) == 0 {
+		return nil
+	}
+	err = store.UpdateAlertsSqc(userID, alerts, true, ctx)
 	if err != nil {
+		for _, v := range alerts {
+			unwatchAlert(v)
+		}
 		return err
 	}
-
+	for _, v := range alerts {
+		session.SetMarketByID(userID, v.Market, true)
+	}
+	session.AddAlerts(userID, alerts...)
 	return nil
 }
 
-func connectToWS(
-	coll *mongo.Collection,
-	dialer *websocket.Dialer, client *http.Client,
-	wsQuery *other.WSQuery, shutdownSrv context.Context,
-) ([]dbModels.Alert, error) {
-	// userChannel := userChannels[wsQuery.UserId]
-	// !!!!!!!!!!!!!!!!!!!!!!!!!!!!!
-	userChannel, _ := uc.GetUserChannels(wsQuery.UserId)
-	pairs, alerts, err := db.GetPairsByMarket(coll, wsQuery.UserId, wsQuery.Market, false, shutdownSrv)
+func alertHandler(
+	tg *models.Client, wsQuery *other.WSQuery,
+	ctx context.Context, store db.Store,
+) error {
+	user, err := store.GetUserByID(wsQuery.UserId, ctx)
 	if err != nil {
-		fmt.Printf("cannot get pairs by market %s for user with %d id: %s\n", wsQuery.Market, wsQuery.UserID(), err)
-		return nil, err
+		return err
 	}
-	// Connect to Market
-	conn, err := wrapper.TickerConnect(wsQuery.Market, pairs, dialer, client)
+	alerts, err := store.GetAlerts(wsQuery.UserId, ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	err = db.UpdateAlertsSqc(coll, wsQuery.UserId, alerts, true, shutdownSrv)
+	opts := []dbModels.MongoAlertOpts{
+		dbModels.WithPair(strings.ToLower(wsQuery.Pair)),
+		dbModels.WithMarket(wsQuery.Market),
+		dbModels.WithTolerance(user.GetTolerance()),
+		dbModels.WithCooldown(user.GetCooldown()),
+		dbModels.WithConnected(true),
+	}
+	switch wsQuery.Kind {
+	case dbModels.KindFunding:
+		opts = append(opts, dbModels.WithFundingRate(wsQuery.Price), dbModels.WithCondition(wsQuery.Condition))
+	case dbModels.KindChange:
+		// change alerts carry a direction instead of a condition
+		opts = append(opts, dbModels.WithChange(wsQuery.Price, wsQuery.Window, wsQuery.Condition))
+	default:
+		opts = append(opts, dbModels.WithTargetPrice(wsQuery.Price), dbModels.WithCondition(wsQuery.Condition))
+	}
+	alert, err := dbModels.NewAlert(opts...)
 	if err != nil {
-		conn.Close()
-		return nil, err
+		return fmt.Errorf("cannot create new alert: %s", err)
+	}
+	if wsQuery.Tolerance > 0 {
+		alert.Tolerance = wsQuery.Tolerance
+	}
+	if wsQuery.Cooldown > 0 {
+		alert.Cooldown = wsQuery.Cooldown
+	}
+	alert.Mode = dbModels.ModeOnce
+	if wsQuery.Mode != "" {
+		alert.Mode = wsQuery.Mode
 	}
 
-	ctx, cancel := context.WithCancel(shutdownSrv)
-	userChannel.SetCancel(wsQuery.Market, cancel)
-
-	// userChannels[wsQuery.UserId] = userChannel
-
-	// Close websocket connection
-	go func(ctx context.Context, shutdownSrv context.Context, conn *websocket.Conn, userChannel *other.UserChannels, wsQuery *other.WSQuery) {
-		defer fmt.Println("Websocket CLOSED")
-		<-ctx.Done()
-		session.SetMarketByID(wsQuery.UserId, wsQuery.Market, false)
-		err := conn.Close()
+	// several alerts per pair are fine, identical ones aren't
+	if alertExist(alert, alerts) {
+		err = wrapper.SendAlertExist(tg, wsQuery.ChatId, wrapper.DisplayPair(alert.Pair))
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Connection closed: %s\n", err)
-			return
+			fmt.Printf("alertHandler: %v", err)
 		}
-		// select {
-		// case <-shutdownSrv.Done():
-		// 	userChannel.Shutdown(wsQuery.Market)
-		// 	conn.Close()
-		// case <-ctx.Done():
-		// 	session.SetMarketByID(wsQuery.UserId, wsQuery.Market, false)
-		// 	err := conn.Close()
-		// 	if err != nil {
-		// 		fmt.Fprintf(os.Stderr, "Connection closed: %s\n", err)
-		// 		return
-		// 	}
-		// }
-	}(ctx, shutdownSrv, conn, userChannel, wsQuery)
+		return errAlertExist
+	}
 
-	session.AddAlerts(wsQuery.UserId, alerts...)
+	err = watchAlert(*alert)
+	if err != nil {
+		return fmt.Errorf("cannot watch alert: %s", err)
+	}
+	err = store.AddAlert(wsQuery.UserId, alert, ctx)
+	if err != nil {
+		unwatchAlert(*alert)
+		return fmt.Errorf("cannot add alert: %s", err)
+	}
+	session.SetChatID(wsQuery.UserId, wsQuery.ChatId)
 	session.SetMarketByID(wsQuery.UserId, wsQuery.Market, true)
+	session.AddAlerts(wsQuery.UserId, *alert)
 
-	go checkPrice(conn, dialer, client, wsQuery, coll, shutdownSrv)
-
-	fmt.Println("Connect Finished")
-	return alerts, nil
+	err = wrapper.SendAlertConfirmed(tg, wsQuery.ChatId)
+	if err != nil {
+		fmt.Printf("alertHandler: %v", err)
+	}
+	return nil
 }
 
-func checkPrice(
-	conn *websocket.Conn, dialer *websocket.Dialer,
-	client *http.Client, wsQuery *other.WSQuery,
-	coll *mongo.Collection, shutdownSrv context.Context,
-) {
-	wg.Add(1)
-	fmt.Println("CheckPrice started")
-	defer func() {
-		fmt.Println("CheckPrice is DONE")
-		wg.Done()
-	}()
-	switch wsQuery.Market {
-	case wrapper.Huobi:
-		go checkPriceHu(conn, dialer, client, wsQuery, coll, shutdownSrv)
-	case wrapper.Binance:
-		go checkPriceBi(conn, dialer, client, wsQuery, coll, shutdownSrv)
+// editAlert moves a watched alert to a new target. The stored alert is updated in place
+// and the session copy is swapped while its pair stays subscribed
+func editAlert(store db.AlertStore, userID int64, hex string, price float64, ctx context.Context) (dbModels.Alert, error) {
+	alerts := session.AlertsByID(userID)
+	index := -1
+	for i := range alerts {
+		if alerts[i].Hex == hex {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return dbModels.Alert{}, db.ErrNoAlert
+	}
+	edited := alerts[index]
+	edited.TargetPrice = price
+	for i := range alerts {
+		if i != index && edited.Same(&alerts[i]) {
+			return dbModels.Alert{}, errAlertExist
+		}
 	}
+
+	alertID, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return dbModels.Alert{}, fmt.Errorf("editAlert: %s", err)
+	}
+	err = store.SetTargetPrice(userID, alertID, price, ctx)
+	if err != nil {
+		return dbModels.Alert{}, err
+	}
+	return session.SetTargetPrice(userID, hex, price)
 }
 
-func checkPriceBi(
-	conn *websocket.Conn, dialer *websocket.Dialer,
-	client *http.Client, wsQuery *other.WSQuery,
-	coll *mongo.Collection, shutdownSrv context.Context,
-) {
-	wg.Add(1)
-	defer func() {
-		fmt.Println("checkPriceBi is DONE")
-		wg.Done()
-	}()
-	fmt.Println("CheckPriceBi started")
-	close, cancel := context.WithCancel(shutdownSrv)
-	// userChannel := userChannels[wsQuery.UserId]
-	// !!!!!!!!!!!!!!!!!!!!!!!!!!!!!!
-	userChannel, _ := uc.GetUserChannels(wsQuery.UserId)
-	alerts := session.AlertsByID(wsQuery.UserId)
-	if len(alerts) > 0 {
-		dbModels.SortByHEX(alerts)
+// editAndReply edits the alert and tells the user how it went, it reports whether the alert was moved
+func editAndReply(tg *models.Client, store db.AlertStore, userID int64, chatID int64, hex string, price float64, ctx context.Context) bool {
+	alert, err := editAlert(store, userID, hex, price, ctx)
+	if err == errAlertExist {
+		alert, _ = findSessionAlert(userID, hex)
+		err = wrapper.SendAlertExist(tg, chatID, wrapper.DisplayPair(alert.Pair))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return false
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+	err = wrapper.SendAlertEdited(tg, chatID, alert)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 	}
+	return true
+}
 
-	go func() {
-		wg.Add(1)
-		defer func() {
-			wg.Done()
-			fmt.Println("Binance SUB/UNSUB")
-		}()
-		for {
-			select {
-			case pair := <-userChannel.SubscribeCh(wsQuery.Market):
-				fmt.Println("SUBBIN")
-				alerts = session.AlertsByID(wsQuery.UserId)
-				dbModels.SortByHEX(alerts)
-				err := wrapper.SubscribeBi(conn, pair.Pair)
-				if err != nil {
-					fmt.Println(err)
-					return
-				}
-				err = wrapper.SendAlertConfirmed(client, wsQuery.ChatId)
-				if err != nil {
-					fmt.Printf("checkPriceBi: %v", err)
-				}
-			case pair := <-userChannel.UnsubscribeCh(wsQuery.Market):
-				fmt.Println("UNSUBIN")
-				alerts = session.AlertsByID(wsQuery.UserId)
-				if len(alerts) > 0 {
-					dbModels.SortByHEX(alerts)
-				}
-				err := wrapper.UnsubBi(conn, pair.Pair)
-				if err != nil {
-					fmt.Println(err)
-					return
-				}
-				if pair.Size <= 0 {
-					fmt.Println("Bin pairs empty")
-					userChannel.Shutdown(wsQuery.Market)
-					userChannel.Cancel(wsQuery.Market)
-					return
-				}
-			case <-shutdownSrv.Done():
-				return
-			case <-close.Done():
-				return
-			}
+func findSessionAlert(userID int64, hex string) (dbModels.Alert, bool) {
+	for _, v := range session.AlertsByID(userID) {
+		if v.Hex == hex {
+			return v, true
 		}
-	}()
+	}
+	return dbModels.Alert{}, false
+}
 
-	ticker := cryptoMarkets.NewTickerBi()
-	for {
-		err := conn.ReadJSON(ticker)
-		if err != nil {
-			cancel()
-			fmt.Println("ReadJson: ", err)
-			handleConnReadErr(dialer, client, wsQuery, coll, shutdownSrv)
-			return
+// listAlerts returns the stored alerts of a user, watched ones come from the session
+// so they carry their latest tick and last notification
+func listAlerts(store db.AlertStore, userID int64, ctx context.Context) ([]dbModels.Alert, error) {
+	alerts, err := store.GetAlerts(userID, ctx)
+	if err != nil {
+		return nil, err
+	}
+	watched := make(map[string]dbModels.Alert)
+	for _, v := range session.AlertsByID(userID) {
+		watched[v.Hex] = v
+	}
+	for i, v := range alerts {
+		if alert, ok := watched[v.Hex]; ok {
+			alerts[i] = alert
 		}
+	}
+	return alerts, nil
+}
 
-		symbol := ticker.GetSymbol()
-		if symbol != "" {
-			alert, _ := dbModels.NewAlert(dbModels.WithMarket(wrapper.Binance), dbModels.WithPair(symbol))
-			i, err := alert.Find(alerts)
-			if err != nil {
-				alerts = session.AlertsByID(wsQuery.UserId)
-				if len(alerts) > 0 {
-					dbModels.SortByHEX(alerts)
+// checkPrice fans a tick out to every alert watching its pair
+func checkPrice(outbox *wrapper.Outbox, store db.AlertStore, window *wrapper.PriceWindow, ctx context.Context) func(*cryptoMarkets.Tick) {
+	return func(tick *cryptoMarkets.Tick) {
+		now := time.Now().UTC()
+		// every watched pair keeps a history, so a new change alert has a baseline right away
+		window.Record(tick.Market, tick.Symbol, tick.LastPrice, now)
+		signals := session.Fire(tick.Market, tick.Symbol, func(alert *dbModels.Alert) bool {
+			value := tick.LastPrice
+			switch alert.GetKind() {
+			case dbModels.KindFunding:
+				value = tick.FundingRate
+			case dbModels.KindChange:
+				change, ok := changeOver(window, tick, alert.Window, now)
+				if !ok {
+					return false
 				}
-				fmt.Println(alerts)
-				fmt.Println("Find", err)
+				value = change
 			}
-			if len(alerts) == 0 {
-				cancel()
-				return
+			prev, seen := alert.LastPrice, alert.Seen
+			alert.LastPrice, alert.Seen = value, true
+			if !alert.Ready(now) || !alert.Triggered(prev, value, seen) {
+				return false
 			}
-			alert = &alerts[i]
-			lastPrice, err := ticker.GetLastPrice()
+			alert.Fire(now)
+			return true
+		})
+
+		for _, v := range signals {
+			err := wrapper.SendAlert(outbox, v.ChatID, v.Alert, v.Alert.LastPrice)
 			if err != nil {
-				fmt.Println("lastPrice", err)
+				fmt.Println("sendAlert", err)
 			}
-
-			if lastPrice <= alert.TargetPrice+(alert.TargetPrice*0.01) && lastPrice >= alert.TargetPrice-(alert.TargetPrice*0.01) {
-				// send notification
-				if alert.LastSignal.IsZero() || time.Now().UTC().UnixMilli() >= (alert.LastSignal.Add(15*time.Minute)).UnixMilli() {
-					err = wrapper.SendAlert(client, wsQuery.ChatId, symbol, lastPrice)
-					if err != nil {
-						fmt.Println("sendAlert", err)
-					}
-					alert.SetLastSignal(time.Now().In(time.UTC))
-				}
+			if v.Alert.GetMode() == dbModels.ModeOnce {
+				wg.Add(1)
+				go disarmAlert(store, v.UserID, v.Alert, ctx)
 			}
-			// fmt.Printf("Channel: %s, Price: %f\n", ticker.Stream, lastPrice)
 		}
 	}
 }
 
-func checkPriceHu(
-	conn *websocket.Conn, dialer *websocket.Dialer,
-	client *http.Client, wsQuery *other.WSQuery,
-	coll *mongo.Collection, shutdownSrv context.Context,
-) {
-	wg.Add(1)
-	defer func() {
-		fmt.Println("checkPriceHu is DONE")
-		wg.Done()
-	}()
-	// var close bool
-	close, cancel := context.WithCancel(shutdownSrv)
-	// userChannel := userChannels[wsQuery.UserId]
-	// !!!!!!!!!!!!!!!!!!!!!!!!!!!
-	userChannel, _ := uc.GetUserChannels(wsQuery.UserId)
-	alerts := session.AlertsByID(wsQuery.UserId)
-	if len(alerts) > 0 {
-		dbModels.SortByHEX(alerts)
+// changeOver is how much the pair of tick moved within span. Binance streams the 24h move,
+// other spans are measured on the recorded history
+func changeOver(window *wrapper.PriceWindow, tick *cryptoMarkets.Tick, span time.Duration, now time.Time) (float64, bool) {
+	if span == dbModels.MaxWindow && tick.Change24h != 0 {
+		return tick.Change24h, true
 	}
+	return window.Change(tick.Market, tick.Symbol, tick.LastPrice, span, now)
+}
 
-	go func() {
-		wg.Add(1)
-		defer func() {
-			wg.Done()
-			fmt.Println("Huobi SUB/UNSUB")
-		}()
-		for {
-			select {
-			case pair := <-userChannel.SubscribeCh(wsQuery.Market):
-				fmt.Println("HUOBISUB")
-				alerts = session.AlertsByID(wsQuery.UserId)
-				dbModels.SortByHEX(alerts)
-				err := wrapper.SubscribeHu(conn, pair.Pair)
-				if err != nil {
-					fmt.Println(err)
-					return
-				}
-				err = wrapper.SendAlertConfirmed(client, wsQuery.ChatId)
-				if err != nil {
-					fmt.Printf("checkPriceHu: %v", err)
-				}
-			case pair := <-userChannel.UnsubscribeCh(wsQuery.Market):
-				fmt.Println("HUOBIUNSUB")
-				alerts = session.AlertsByID(wsQuery.UserId)
-				if len(alerts) > 0 {
-					dbModels.SortByHEX(alerts)
-				}
-				err := wrapper.UnsubHu(conn, pair.Pair)
-				if err != nil {
-					fmt.Println(err)
-					return
-				}
-				fmt.Println("Pair.Size: ", pair.Size)
-				if pair.Size <= 0 {
-					fmt.Println("Huo pairs empty")
-					userChannel.Shutdown(wsQuery.Market)
-					userChannel.Cancel(wsQuery.Market)
-					return
-				}
-			case <-close.Done():
-				return
-			}
-		}
-	}()
-
-	var buf bytes.Buffer
-	_, data, err := conn.ReadMessage()
+// disarmAlert archives a fired one-shot alert and unsubscribes its pair
+func disarmAlert(store db.AlertStore, userID int64, alert dbModels.Alert, ctx context.Context) {
+	defer wg.Done()
+	err := store.ArchiveAlert(userID, &alert, ctx)
 	if err != nil {
-		cancel()
-		handleConnReadErr(dialer, client, wsQuery, coll, shutdownSrv)
+		fmt.Fprintln(os.Stderr, err)
 		return
 	}
-	buf.Write(data)
-	zr, err := gzip.NewReader(&buf)
+	_, err = session.RemoveAlert(userID, alert.Hex)
 	if err != nil {
-		cancel()
-		handleCheckPriceErr(wsQuery)
+		fmt.Fprintln(os.Stderr, err)
 		return
 	}
-	defer zr.Close()
-
-	ticker := cryptoMarkets.NewTickerHuobi()
-	for {
-		zr.Multistream(false)
-		msgType, dataRaw, err := conn.ReadMessage()
-		if err != nil {
-			fmt.Printf("ReadMSG %d, %v\nData: %s", msgType, err, string(dataRaw))
-			cancel()
-			handleConnReadErr(dialer, client, wsQuery, coll, shutdownSrv)
-			return
-		}
-
-		buf.Write(dataRaw)
-		data, err = io.ReadAll(zr)
-		if err != nil {
-			cancel()
-			handleCheckPriceErr(wsQuery)
-			return
-		}
-
-		err = wrapper.CheckPingHuobi(conn, data)
-		if err != nil {
-			err = json.Unmarshal(data, ticker)
-			if err != nil {
-				cancel()
-				fmt.Println("unmarshal", err)
-				handleCheckPriceErr(wsQuery)
-				return
-			}
-
-			symbol := ticker.GetSymbol()
-			if symbol != "" {
-				alert, _ := dbModels.NewAlert(dbModels.WithMarket(wrapper.Huobi), dbModels.WithPair(symbol))
-				i, err := alert.Find(alerts)
-				if err != nil {
-					alerts = session.AlertsByID(wsQuery.UserId)
-					if len(alerts) > 0 {
-						dbModels.SortByHEX(alerts)
-					}
-					fmt.Println("huobi", err)
-				}
-				if len(alerts) == 0 {
-					cancel()
-					return
-				}
-				alert = &alerts[i]
-				lastPrice := ticker.GetLastPrice()
-
-				if lastPrice <= alert.TargetPrice+(alert.TargetPrice*0.01) && lastPrice >= alert.TargetPrice-(alert.TargetPrice*0.01) {
-					// send notification
-					if alert.LastSignal.IsZero() || time.Now().UTC().UnixMilli() >= (alert.LastSignal.Add(15*time.Minute)).UnixMilli() {
-						err = wrapper.SendAlert(client, wsQuery.ChatId, symbol, lastPrice)
-						if err != nil {
-							fmt.Println(err)
-						}
-						alert.SetLastSignal(time.Now().In(time.UTC))
-					}
-				}
-				// fmt.Printf("Channel: %s, Price: %f\n", ticker.Channel, lastPrice)
-			}
-		}
-
-		err = zr.Reset(&buf)
-		if err != nil {
-			cancel()
-			fmt.Println(err)
-			handleCheckPriceErr(wsQuery)
-			return
-		}
-	}
-}
-
-func handleCheckPriceErr(wsQuery *other.WSQuery) {
-	// userChannel := userChannels[wsQuery.UserId]
-	// !!!!!!!!!!!!!!!!!!!!!!!!
-	userChannel, _ := uc.GetUserChannels(wsQuery.UserId)
-	userChannel.Cancel(wsQuery.Market)
-}
-
-func handleConnReadErr(
-	dialer *websocket.Dialer, client *http.Client,
-	wsQuery *other.WSQuery, coll *mongo.Collection,
-	shutdownSrv context.Context,
-) {
-	// userChannel := userChannels[wsQuery.UserId]
-	// !!!!!!!!!!!!!!!!!!!!!!
-	userChannel, _ := uc.GetUserChannels(wsQuery.UserId)
-	select {
-	case <-userChannel.ShutdownCh(wsQuery.Market):
-		// delete(userChannels, wsQuery.UserId)
-		// session.DeleteAlerts(wsQuery.UserId, len(session.AlertsByID(wsQuery.ChatId)))
-		session.SetMarketByID(wsQuery.UserId, wsQuery.Market, false)
-	default:
-		// Close go-routine to prevent leakage
-		userChannel.Cancel(wsQuery.Market)
-		// reconnect in case of 24h limit or other error
-		_, alerts := session.AlertsByMarket(wsQuery.UserId, wsQuery.Market)
-		db.UpdateAlertsSqc(coll, wsQuery.UserId, alerts, false, shutdownSrv)
-		session.SetMarketByID(wsQuery.UserId, wsQuery.Market, false)
-		_, err := connectToWS(coll, dialer, client, wsQuery, shutdownSrv)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			// delete(userChannels, wsQuery.UserId)
-		}
+	err = unwatchAlert(alert)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 	}
 }
 
-func disconnectAlert(coll *mongo.Collection, ctx context.Context, callback *models.CallbackQuery) error {
+func disconnectAlert(store db.AlertStore, ctx context.Context, callback *models.CallbackQuery) error {
 	wg.Add(1)
 	defer func() {
 		wg.Done()
@@ -687,73 +583,82 @@ func disconnectAlert(coll *mongo.Collection, ctx context.Context, callback *mode
 
 	userID := callback.From.Id
 
-	userChannel, ok := uc.GetUserChannels(userID)
-	var index int
-	// split callback data to get pair and market
-	pair, market := wrapper.SplitCallbackData(callback.Data)
-	fmt.Println(pair, market)
+	// callback data holds either an alert id or "all"
+	alertHex := callback.Data
 
-	// alerts := session.AlertsByID(userID)
-	alerts, alertsMarket := session.AlertsByMarket(userID, market)
+	alerts := session.AlertsByID(userID)
 	if len(alerts) == 0 {
 		return errors.New("disconnectAlert: alerts are empty")
 	}
-	lenghtAM := len(alertsMarket)
-	lenghtA := len(alerts)
 
-	// using Callback from TG to get pair for deletion
-	// remove pair
-	index, err := findDisconnectAlert(market, pair, alerts)
+	index, err := findDisconnectAlert(alertHex, alerts)
 	if err != nil {
 		return err
 	}
 
 	switch {
 	case index >= 0:
-		err := db.RemoveAlert(coll, userID, pair, ctx)
+		alertID, err := primitive.ObjectIDFromHex(alertHex)
+		if err != nil {
+			return fmt.Errorf("disconnectAlert: %s", err)
+		}
+		err = store.RemoveAlert(userID, alertID, ctx)
 		if err != nil {
 			return err
 		}
 
-		if ok && session.MarketExist(userID, market) {
-			// fmt.Println("START_UNSUB")
-			fmt.Println("disconnectAlert", alerts)
-			// fmt.Println("disconnectAlert", alerts[index])
-			err = session.DeleteAlert(userID, index)
-			if err != nil {
-				return err
-			}
-			userChannel.UnsubscribeSignal(market, other.PairSignal{Pair: pair, Size: lenghtAM - 1})
-			// fmt.Println("FINISH_UNSUB")
+		alert, err := session.RemoveAlert(userID, alertHex)
+		if err != nil {
+			return err
 		}
-		// fmt.Println("FINISH", session.AlertsByID(userID))
-		return nil
+		_, alertsMarket := session.AlertsByMarket(userID, alert.Market)
+		if len(alertsMarket) == 0 {
+			session.SetMarketByID(userID, alert.Market, false)
+		}
+		return unwatchAlert(alert)
 	case index == -1:
-		err = db.DeleteAlerts(coll, userID, alerts, ctx)
+		err = store.DeleteAlerts(userID, alerts, ctx)
 		if err != nil {
 			return err
 		}
-		if ok {
-			session.DeleteAlerts(userID, len(alerts))
-			session.SetMarketsByID(userID, false)
-			for _, v := range alerts {
-				userChannel.UnsubscribeSignal(v.Market, other.PairSignal{Pair: v.Pair, Size: lenghtA - 1})
+		session.DeleteAlerts(userID, len(alerts))
+		session.SetMarketsByID(userID, false)
+		for _, v := range alerts {
+			err = unwatchAlert(v)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
 			}
 		}
-		fmt.Println("AFTER UNSUB")
 		return nil
 	default:
-		return fmt.Errorf("there's no pair with the market %s for this userID %d", market, userID)
+		return fmt.Errorf("there's no alert %s for this userID %d", alertHex, userID)
 	}
 }
 
-func updateFromTG(client *http.Client, dialer *websocket.Dialer, coll *mongo.Collection, shutdownSrv context.Context) func(http.ResponseWriter, *http.Request) {
+// maxUpdateSize caps webhook bodies, real updates are a few kilobytes
+const maxUpdateSize = 1 << 20
+
+// updateFromTG accepts webhook calls that carry the secret registered with setWebhook.
+// Telegram gets its answer right away while handle runs in the background,
+// so a slow command can't make it retry and deliver the same update twice
+func updateFromTG(secret string, seen *recentUpdates, handle func(*models.Update)) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		data, err := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		token := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxUpdateSize))
 		if err != nil {
-			fmt.Println(err)
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
 		}
-		// fmt.Println(string(data))
 		result, err := models.NewUpdate()
 		if err != nil {
 			fmt.Println(err)
@@ -761,294 +666,362 @@ func updateFromTG(client *http.Client, dialer *websocket.Dialer, coll *mongo.Col
 		}
 		err = json.Unmarshal(data, result)
 		if err != nil {
-			fmt.Println(err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if seen.Seen(result.Id) {
 			return
 		}
-		fmt.Println(result)
-		msg := result.Msg
-		switch {
-		case len(msg.Entities) > 0:
-			// handle commands
-			command := msg.Text
 
-			switch wrapper.CommandRouter(command, regexps) {
-			case "start":
-				err := wrapper.StartRouter(result, client)
-				if err != nil {
-					fmt.Fprintln(os.Stderr, err)
-					return
-				}
-				// alerts := make([]dbModels.Alert, 0)
-				user, err := dbModels.NewMongoUser(
-					dbModels.WithUserID(result.FromUser().Id),
-					dbModels.WithChatID(result.FromChat().Id),
-				)
-				if err != nil {
-					fmt.Fprintln(os.Stderr, err)
-					return
-				}
-				user.Alerts = make([]dbModels.Alert, 0)
-				err = db.InsertNewUser(coll, user, shutdownSrv)
-				if err != nil {
-					fmt.Fprintln(os.Stderr, err)
-					return
-				}
-			case "help":
-				err = wrapper.HelpRouter(result, client)
-				if err != nil {
-					fmt.Println(err)
-					return
-				}
-			case "alert":
-				wsQuery, err := wrapper.AlertRouter(command, regexps, result, client)
-				if err != nil {
-					fmt.Fprintln(os.Stderr, err)
-					return
-				}
-				err = alertHandler(dialer, client, wsQuery, shutdownSrv, coll)
-				if err != nil {
-					fmt.Fprintln(os.Stderr, err)
-					return
-				}
-			case "disconnect":
-				pairs, err := db.GetAlerts(coll, result.FromUser().Id, shutdownSrv)
-				if err != nil {
-					fmt.Fprintln(os.Stderr, err)
-				}
-				err = wrapper.DisconnectRouter(result, pairs, client)
-				if err != nil {
-					fmt.Fprintln(os.Stderr, err)
-					return
-				}
-			case "price":
-				err = wrapper.PriceRouter(client, result, command, regexps)
-				if err != nil {
-					fmt.Println(err)
-					return
-				}
-			default:
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handle(result)
+		}()
+	}
+}
+
+// pollUpdates long polls getUpdates and hands every update to handleUpdate until ctx is done
+func pollUpdates(pollTG *models.Client, tg *models.Client, client *http.Client, store db.Store, ctx context.Context, timeout time.Duration) {
+	offset := 0
+	for {
+		updates, err := pollTG.GetUpdates(ctx, offset, timeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Fprintln(os.Stderr, err)
+			select {
+			case <-ctx.Done():
 				return
+			case <-time.After(5 * time.Second):
 			}
+			continue
+		}
+		for i := range updates {
+			// acknowledged once the next request carries a greater offset
+			offset = updates[i].Id + 1
+			handleUpdate(tg, client, store, ctx, &updates[i])
+		}
+	}
+}
 
-		case result.GetCallbackData() != "":
-			// handle callbacks
-			fmt.Println(result.GetCallbackData())
-			switch wrapper.CallbackHandler(client, result.GetCallbackData(), regexps) {
-			case "disconnect":
-				// fmt.Println("Callback")
-				callback, err := wrapper.DisconnectCallback(result, regexps, client)
-				if err != nil {
-					fmt.Fprintln(os.Stderr, err)
-					return
-				}
-				// fmt.Println(callback)
-				err = disconnectAlert(coll, shutdownSrv, callback)
-				if err != nil {
-					fmt.Println("disconnectAlert")
-					fmt.Fprintln(os.Stderr, err)
-					return
-				}
-				fmt.Println("AFTER DISCONNECT")
+// handleUpdate dispatches an update no matter if it came from the webhook or from polling
+func handleUpdate(tg *models.Client, client *http.Client, store db.Store, shutdownSrv context.Context, result *models.Update) {
+	var err error
+	fmt.Println(result)
+	msg := result.Msg
+	switch {
+	case len(msg.Entities) > 0:
+		// handle commands
+		command := msg.Text
 
-				answer, err := models.NewCallbackAnswer(
-					models.WithAnswerID(callback.Id),
-					models.WithAnswerText("Alert(s) disabled"),
-					models.WithAnswerCacheTime(1),
-				)
+		switch wrapper.CommandRouter(command, regexps) {
+		case "start":
+			err := wrapper.StartRouter(result, tg)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			_, err = store.GetUserByID(result.FromUser().Id, shutdownSrv)
+			if err == nil {
+				// a returning user may have blocked the bot before
+				err = resumeAlerts(store, result.FromUser().Id, result.FromChat().Id, shutdownSrv)
 				if err != nil {
-					fmt.Println("CreateNewAnswer")
 					fmt.Fprintln(os.Stderr, err)
-					return
 				}
-				err = wrapper.SendCallbackAnswer(client, answer)
-				if err != nil {
-					fmt.Println("SendCallbackAnswer", err)
-				}
-				fmt.Println(result.FromUser().Id)
-				pairs, err := db.GetAlerts(coll, callback.From.Id, shutdownSrv)
-				if err != nil {
-					fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			if err != db.ErrNoUser {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			user, err := dbModels.NewMongoUser(
+				dbModels.WithUserID(result.FromUser().Id),
+				dbModels.WithChatID(result.FromChat().Id),
+			)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			user.Alerts = make([]dbModels.Alert, 0)
+			err = store.InsertNewUser(user, shutdownSrv)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+		case "help":
+			err = wrapper.HelpRouter(result, tg)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+		case "alert":
+			wsQuery, err := wrapper.AlertRouter(command, regexps, result, tg, client)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			err = alertHandler(tg, wsQuery, shutdownSrv, store)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+		case "funding":
+			wsQuery, err := wrapper.FundingRouter(command, result, tg, client)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			err = alertHandler(tg, wsQuery, shutdownSrv, store)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+		case "change":
+			wsQuery, err := wrapper.ChangeRouter(command, result, tg, client)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			err = alertHandler(tg, wsQuery, shutdownSrv, store)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+		case "defaults":
+			tolerance, cooldown, err := wrapper.DefaultsRouter(command, result, tg)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			err = store.SetUserDefaults(result.FromUser().Id, tolerance, cooldown, shutdownSrv)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			user, err := store.GetUserByID(result.FromUser().Id, shutdownSrv)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			err = wrapper.SendDefaults(tg, result.FromChat().Id, user)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+		case "history":
+			history, err := store.GetHistory(result.FromUser().Id, shutdownSrv)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			err = wrapper.HistoryRouter(result, history, tg)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+		case "disconnect":
+			pairs, err := store.GetAlerts(result.FromUser().Id, shutdownSrv)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			err = wrapper.DisconnectRouter(result, pairs, tg)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+		case "list":
+			alerts, err := listAlerts(store, result.FromUser().Id, shutdownSrv)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			err = wrapper.ListRouter(result, alerts, tg, client)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+		case "edit":
+			hex, price, err := wrapper.EditRouter(command, result, session.AlertsByID(result.FromUser().Id), tg)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			if hex == "" {
+				return
+			}
+			if price == 0 {
+				alert, ok := findSessionAlert(result.FromUser().Id, hex)
+				if !ok {
+					fmt.Fprintf(os.Stderr, "edit: no alert %s for %d\n", hex, result.FromUser().Id)
+					return
 				}
-				// fmt.Println(pairs)
-				// us := userChannels[callback.From.Id]
-				err = wrapper.EditMarkup(client, callback, pairs)
+				err = wrapper.SendEditOptions(tg, result.FromChat().Id, alert)
 				if err != nil {
 					fmt.Fprintln(os.Stderr, err)
-					return
 				}
-			default:
 				return
 			}
+			editAndReply(tg, store, result.FromUser().Id, result.FromChat().Id, hex, price, shutdownSrv)
+		case "price":
+			err = wrapper.PriceRouter(tg, client, result, command, regexps)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+		case "conversation":
+			err = startConversation(tg, store, result.FromUser().Id, result.FromChat().Id, shutdownSrv)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+		case "cancel":
+			_, err = converse(tg, client, store, result.FromUser().Id, result.FromChat().Id, wrapper.ConvCancel, "", shutdownSrv)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+		default:
+			// entities also mark links and mentions, only commands deserve an answer
+			if !strings.HasPrefix(command, "/") {
+				return
+			}
+			err = wrapper.SendUsage(tg, result, command)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			return
 		}
-	}
-}
-
-// For testing only
-/*
-func createUpdate(dialer *websocket.Dialer, client *http.Client, shutdownSrv context.Context, coll *mongo.Collection) func(http.ResponseWriter, *http.Request) {
 
-	return func(rw http.ResponseWriter, r *http.Request) {
-		offset := 1
-		id := -1
-		for {
-			postBody, err := json.Marshal(map[string]int{
-				"offset":  -offset,
-				"timeout": 0,
-			})
+	case result.GetCallbackData() != "":
+		// handle callbacks
+		fmt.Println(result.GetCallbackData())
+		switch wrapper.CallbackHandler(tg, result.GetCallbackData(), regexps) {
+		case "disconnect":
+			// fmt.Println("Callback")
+			callback, err := wrapper.DisconnectCallback(result, regexps, tg)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
+				return
 			}
-
-			bodyReq := bytes.NewBuffer(postBody)
-			resp, err := http.Post(fmt.Sprintf("https://api.telegram.org/bot%s/getUpdates", os.Getenv("TG")), "application/json", bodyReq)
+			// fmt.Println(callback)
+			err = disconnectAlert(store, shutdownSrv, callback)
 			if err != nil {
+				fmt.Println("disconnectAlert")
 				fmt.Fprintln(os.Stderr, err)
+				return
 			}
+			fmt.Println("AFTER DISCONNECT")
 
-			body, err := io.ReadAll(resp.Body)
+			answer, err := models.NewCallbackAnswer(
+				models.WithAnswerID(callback.Id),
+				models.WithAnswerText("Alert(s) disabled"),
+				models.WithAnswerCacheTime(1),
+			)
 			if err != nil {
+				fmt.Println("CreateNewAnswer")
 				fmt.Fprintln(os.Stderr, err)
+				return
 			}
-
-			update := &models.TGUpdate{}
-			err = json.Unmarshal(body, update)
+			err = wrapper.SendCallbackAnswer(tg, answer)
+			if err != nil {
+				fmt.Println("SendCallbackAnswer", err)
+			}
+			fmt.Println(result.FromUser().Id)
+			pairs, err := store.GetAlerts(callback.From.Id, shutdownSrv)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			// fmt.Println(pairs)
+			// us := userChannels[callback.From.Id]
+			err = wrapper.EditMarkup(tg, callback, pairs)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+		case "retry":
+			answer, err := models.NewCallbackAnswer(models.WithAnswerID(result.CallbackQuery.Id), models.WithAnswerCacheTime(1))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			err = wrapper.SendCallbackAnswer(tg, answer)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			retry, err := wrapper.RetryCallback(result)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			handleUpdate(tg, client, store, shutdownSrv, retry)
+		case "conversation":
+			callback := &result.CallbackQuery
+			answer, err := models.NewCallbackAnswer(models.WithAnswerID(callback.Id), models.WithAnswerCacheTime(1))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			err = wrapper.SendCallbackAnswer(tg, answer)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			action, value, err := wrapper.ConversationCallback(result)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			_, err = converse(tg, client, store, callback.From.Id, callback.Msg.FromChatID(), action, value, shutdownSrv)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+		case "edit":
+			callback := &result.CallbackQuery
+			hex, price, err := wrapper.EditCallback(result)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			answer, err := models.NewCallbackAnswer(models.WithAnswerID(callback.Id), models.WithAnswerCacheTime(1))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			err = wrapper.SendCallbackAnswer(tg, answer)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
 			}
 
-			go func(update *models.TGUpdate) {
-				wg.Add(1)
-				defer wg.Done()
-				if len(update.Result) > 0 {
-					result := update.Result[len(update.Result)-1]
-
-					if result.Id == id {
-						return
-					}
-
-					id = result.Id
-
-					msg := result.Msg
-
-					switch {
-					case len(msg.Entities) > 0:
-						// handle commands
-						// fmt.Println(msg.Text)
-
-						// us := userChannels[msg.From.Id]
-						command := msg.Text
-
-						switch wrapper.CommandRouter(command, regexps) {
-						case "start":
-							err := wrapper.StartRouter(&result, client)
-							if err != nil {
-								fmt.Fprintln(os.Stderr, err)
-								return
-							}
-							user, err := dbModels.NewMongoUser(
-								dbModels.WithUserID(result.FromUser().Id),
-								dbModels.WithChatID(result.FromChat().Id),
-							)
-							if err != nil {
-								fmt.Fprintln(os.Stderr, err)
-								return
-							}
-							user.Alerts = make([]dbModels.Alert, 0)
-							err = db.InsertNewUser(coll, user, shutdownSrv)
-							if err != nil {
-								fmt.Fprintln(os.Stderr, err)
-								return
-							}
-						case "help":
-							err = wrapper.HelpRouter(&result, client)
-							if err != nil {
-								fmt.Println(err)
-								return
-							}
-						case "alert":
-							wsQuery, err := wrapper.AlertRouter(command, regexps, &result, client)
-							if err != nil {
-								fmt.Fprintln(os.Stderr, err)
-								return
-							}
-							err = alertHandler(dialer, client, wsQuery, shutdownSrv, coll)
-							if err != nil {
-								fmt.Fprintln(os.Stderr, err)
-								return
-							}
-						case "disconnect":
-							pairs, err := db.GetAlerts(coll, result.FromUser().Id, shutdownSrv)
-							if err != nil {
-								fmt.Fprintln(os.Stderr, err)
-							}
-							err = wrapper.DisconnectRouter(&result, pairs, client)
-							if err != nil {
-								fmt.Fprintln(os.Stderr, err)
-								return
-							}
-						case "price":
-							err = wrapper.PriceRouter(client, &result, command, regexps)
-							if err != nil {
-								fmt.Println(err)
-								return
-							}
-						default:
-							return
-						}
-
-					case result.GetCallbackData() != "":
-						// handle callbacks
-						fmt.Println(result.GetCallbackData())
-						switch wrapper.CallbackHandler(client, result.GetCallbackData(), regexps) {
-						case "disconnect":
-							// fmt.Println("Callback")
-							callback, err := wrapper.DisconnectCallback(&result, regexps, client)
-							if err != nil {
-								fmt.Fprintln(os.Stderr, err)
-								return
-							}
-							// fmt.Println(callback)
-							err = disconnectAlert(coll, shutdownSrv, callback)
-							if err != nil {
-								fmt.Println("disconnectAlert")
-								fmt.Fprintln(os.Stderr, err)
-								return
-							}
-							fmt.Println("AFTER DISCONNECT")
-
-							answer, err := models.NewCallbackAnswer(
-								models.WithAnswerID(callback.Id),
-								models.WithAnswerText("Alert(s) disabled"),
-								models.WithAnswerCacheTime(1),
-							)
-							if err != nil {
-								fmt.Println("CreateNewAnswer")
-								fmt.Fprintln(os.Stderr, err)
-								return
-							}
-							err = wrapper.SendCallbackAnswer(client, answer)
-							if err != nil {
-								fmt.Println("SendCallbackAnswer", err)
-							}
-							fmt.Println(result.FromUser().Id)
-							pairs, err := db.GetAlerts(coll, callback.From.Id, shutdownSrv)
-							if err != nil {
-								fmt.Fprintln(os.Stderr, err)
-							}
-							// fmt.Println(pairs)
-							// us := userChannels[callback.From.Id]
-							err = wrapper.EditMarkup(client, callback, pairs)
-							if err != nil {
-								fmt.Fprintln(os.Stderr, err)
-								return
-							}
-						default:
-							return
-						}
-					}
+			if price == 0 {
+				alert, ok := findSessionAlert(callback.From.Id, hex)
+				if !ok {
+					fmt.Fprintf(os.Stderr, "edit: no alert %s for %d\n", hex, callback.From.Id)
+					return
+				}
+				err = wrapper.SendEditOptions(tg, callback.Msg.FromChatID(), alert)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				}
+				return
+			}
+			if editAndReply(tg, store, callback.From.Id, callback.Msg.FromChatID(), hex, price, shutdownSrv) {
+				err = wrapper.DeleteCallbackMsg(tg, callback)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
 				}
-			}(update)
+			}
+		default:
+			return
+		}
+
+	case msg.Text != "":
+		// plain text answers the question of a conversation if there's one
+		_, err = converse(tg, client, store, result.FromUser().Id, result.FromChat().Id, "", msg.Text, shutdownSrv)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
 		}
 	}
 }
-*/
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HomelessHunter/CTC/db"
+	dbModels "github.com/HomelessHunter/CTC/db/models"
+	"github.com/HomelessHunter/CTC/wrapper"
+	cryptoMarkets "github.com/HomelessHunter/CTC/wrapper/models/cryptoMarkets"
+	other "github.com/HomelessHunter/CTC/wrapper/models/other"
+	models "github.com/HomelessHunter/CTC/wrapper/models/telegram"
+	"github.com/HomelessHunter/CTC/wrapper/models/telegram/telegramtest"
+)
+
+func TestUpdateFromTG(t *testing.T) {
+	handled := make(chan int, 4)
+	handler := updateFromTG("secret", newRecentUpdates(10), func(update *models.Update) {
+		handled <- update.Id
+	})
+
+	send := func(method, secret, body string) int {
+		req := httptest.NewRequest(method, "/hook", strings.NewReader(body))
+		if secret != "" {
+			req.Header.Set("X-Telegram-Bot-Api-Secret-Token", secret)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	if code := send(http.MethodGet, "secret", `{"update_id": 1}`); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET should be rejected, got %d", code)
+	}
+	if code := send(http.MethodPost, "", `{"update_id": 1}`); code != http.StatusForbidden {
+		t.Errorf("missing secret should be rejected, got %d", code)
+	}
+	if code := send(http.MethodPost, "wrong", `{"update_id": 1}`); code != http.StatusForbidden {
+		t.Errorf("wrong secret should be rejected, got %d", code)
+	}
+	big := `{"update_id": 1, "pad": "` + strings.Repeat("a", maxUpdateSize) + `"}`
+	if code := send(http.MethodPost, "secret", big); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body should be rejected, got %d", code)
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := send(http.MethodPost, "secret", `{"update_id": 7}`); code != http.StatusOK {
+			t.Errorf("update should be accepted, got %d", code)
+		}
+	}
+	wg.Wait()
+	select {
+	case id := <-handled:
+		if id != 7 {
+			t.Errorf("unexpected update %d", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("update wasn't handled")
+	}
+	if len(handled) != 0 {
+		t.Error("a redelivered update should be handled once")
+	}
+}
+
+func TestRecentUpdates(t *testing.T) {
+	seen := newRecentUpdates(2)
+	for _, id := range []int{1, 2, 3} {
+		if seen.Seen(id) {
+			t.Errorf("%d wasn't delivered yet", id)
+		}
+	}
+	if !seen.Seen(3) {
+		t.Error("3 was delivered")
+	}
+	// only the last two ids are kept
+	if seen.Seen(1) {
+		t.Error("1 should have been forgotten")
+	}
+}
+
+func TestSuspendChat(t *testing.T) {
+	srv := telegramtest.NewServer()
+	defer srv.Close()
+	outbox, _ := wrapper.NewOutbox(srv.TGClient())
+	defer outbox.Close(context.TODO())
+	store := db.NewMemoryStore()
+	session = other.NewSession()
+	hubs = make(map[string]*wrapper.Hub)
+
+	user, _ := dbModels.NewMongoUser(dbModels.WithUserID(1), dbModels.WithChatID(10))
+	store.InsertNewUser(user, context.TODO())
+	alert, _ := dbModels.NewAlert(dbModels.WithMarket("binance"), dbModels.WithPair("btcusdt"), dbModels.WithTargetPrice(70000), dbModels.WithConnected(true))
+	store.AddAlert(1, alert, context.TODO())
+	session.SetChatID(1, 10)
+	session.AddAlerts(1, *alert)
+
+	suspendChat(outbox, store, 10, context.TODO())
+	if len(session.AlertsByID(1)) != 0 {
+		t.Error("alerts of a blocked chat should leave the session")
+	}
+	users, _ := store.GetUsersWithPairs(false, context.TODO())
+	if len(users) != 0 {
+		t.Errorf("alerts should be suspended, got %v", users)
+	}
+	resumed, _ := store.ResumeAlerts(1, context.TODO())
+	if len(resumed) != 1 {
+		t.Errorf("the alert should be kept for a returning user, got %v", resumed)
+	}
+}
+
+func TestEditAlert(t *testing.T) {
+	store := db.NewMemoryStore()
+	session = other.NewSession()
+
+	user, _ := dbModels.NewMongoUser(dbModels.WithUserID(1), dbModels.WithChatID(1))
+	store.InsertNewUser(user, context.TODO())
+	first, _ := dbModels.NewAlert(dbModels.WithMarket("binance"), dbModels.WithPair("btcusdt"), dbModels.WithTargetPrice(70000))
+	second, _ := dbModels.NewAlert(dbModels.WithMarket("binance"), dbModels.WithPair("btcusdt"), dbModels.WithTargetPrice(60000))
+	for _, v := range []*dbModels.Alert{first, second} {
+		store.AddAlert(1, v, context.TODO())
+		session.AddAlerts(1, *v)
+	}
+
+	alert, err := editAlert(store, 1, first.Hex, 71000, context.TODO())
+	if err != nil || alert.TargetPrice != 71000 {
+		t.Fatalf("unexpected alert %v (%v)", alert, err)
+	}
+	stored, _ := store.GetAlerts(1, context.TODO())
+	if stored[0].TargetPrice != 71000 {
+		t.Errorf("stored alert wasn't updated: %v", stored[0])
+	}
+	if _, err = editAlert(store, 1, first.Hex, 60000, context.TODO()); err != errAlertExist {
+		t.Errorf("moving onto another alert should fail, got %v", err)
+	}
+	if _, err = editAlert(store, 1, "unknown", 60000, context.TODO()); err != db.ErrNoAlert {
+		t.Errorf("expected ErrNoAlert, got %v", err)
+	}
+}
+
+func TestAlertKindCommands(t *testing.T) {
+	regs := compileRegexp()
+	for command, want := range map[string]string{
+		"/funding btcusdt > 0.05%":                 "funding",
+		"/funding binance-futures:btcusdt <-0.01%": "funding",
+		"/funding btcusdt 0.05%":                   "",
+		"/change solusdt 5% 1h":                    "change",
+		"/change sol/usdt up 2.5% 15m once":        "change",
+		"/change solusdt 5%":                       "",
+	} {
+		if got := wrapper.CommandRouter(command, regs); got != want {
+			t.Errorf("%s: expected %q, got %q", command, want, got)
+		}
+	}
+}
+
+func TestChangeOver(t *testing.T) {
+	window := wrapper.NewPriceWindow()
+	now := time.Now()
+	window.Record(wrapper.Binance, "solusdt", 100, now.Add(-2*time.Hour))
+	tick := &cryptoMarkets.Tick{Market: wrapper.Binance, Symbol: "solusdt", LastPrice: 110, Change24h: 0.2}
+
+	if change, ok := changeOver(window, tick, 24*time.Hour, now); !ok || change != 0.2 {
+		t.Errorf("a day should use the streamed change, got %f %t", change, ok)
+	}
+	if change, ok := changeOver(window, tick, 3*time.Hour, now); !ok || change < 0.0999 || change > 0.1001 {
+		t.Errorf("shorter windows should use the history, got %f %t", change, ok)
+	}
+	if _, ok := changeOver(window, tick, time.Hour, now); ok {
+		t.Error("no sample within the window should report no change")
+	}
+}
+
+func TestEditRegexp(t *testing.T) {
+	regs := compileRegexp()
+	for _, command := range []string{"/edit btcusdt 71000", "/edit BTC/USDT 71000", "/edit binance:btc-usdt 71000", "/edit 65f1a2b3c4d5e6f708192a3b"} {
+		if got := wrapper.CommandRouter(command, regs); got != "edit" {
+			t.Errorf("%s: expected edit, got %q", command, got)
+		}
+	}
+}
+
+func TestCheckPriceChangeBaseline(t *testing.T) {
+	srv := telegramtest.NewServer()
+	defer srv.Close()
+	outbox, _ := wrapper.NewOutbox(srv.TGClient())
+	defer outbox.Close(context.TODO())
+	store := db.NewMemoryStore()
+	session = other.NewSession()
+	window := wrapper.NewPriceWindow()
+	onTick := checkPrice(outbox, store, window, context.TODO())
+
+	session.SetChatID(1, 10)
+	price, _ := dbModels.NewAlert(dbModels.WithMarket("binance"), dbModels.WithPair("solusdt"), dbModels.WithTargetPrice(500))
+	session.AddAlerts(1, *price)
+	onTick(&cryptoMarkets.Tick{Market: "binance", Symbol: "solusdt", LastPrice: 100})
+
+	// the pair was watched before the change alert existed, its history is the baseline
+	change, _ := dbModels.NewAlert(dbModels.WithMarket("binance"), dbModels.WithPair("solusdt"), dbModels.WithChange(0.05, time.Hour, dbModels.DirectionUp),
+		dbModels.WithMode(dbModels.ModeRecurring))
+	session.AddAlerts(1, *change)
+	onTick(&cryptoMarkets.Tick{Market: "binance", Symbol: "solusdt", LastPrice: 106})
+
+	for _, v := range session.AlertsByID(1) {
+		if v.Hex == change.Hex && v.LastSignal.IsZero() {
+			t.Errorf("a 6%% move should fire the change alert, last seen %f", v.LastPrice)
+		}
+	}
+}
@@ -1,53 +1,96 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"regexp"
+	"sync"
 
 	db "github.com/HomelessHunter/CTC/db/models"
 )
 
-func pairExist(market, pair string, alerts []db.Alert) bool {
-	if len(alerts) == 0 {
-		return false
-	}
-	alert, err := db.NewAlert(db.WithMarket(market), db.WithPair(pair))
-	if err != nil {
-		fmt.Println(err)
-		return false
-	}
-	_, err = alert.SortNFind(alerts)
-	if err != nil {
-		return false
+func alertExist(alert *db.Alert, alerts []db.Alert) bool {
+	for i := range alerts {
+		if alert.Same(&alerts[i]) {
+			return true
+		}
 	}
-	return true
+	return false
 }
 
 func compileRegexp() map[string]*regexp.Regexp {
 	return map[string]*regexp.Regexp{
 		"start":      regexp.MustCompile(`^\/(start)$`),
 		"help":       regexp.MustCompile(`^\/help$`),
-		"alert":      regexp.MustCompile(`^\/(a|A)(lert)\s[A-Za-z]+\s[0-9]+\.*[0-9]*$`),
-		"price":      regexp.MustCompile(`^\/(p|P)rice\s[a-zA-Z]+$`),
-		"disconnect": regexp.MustCompile(`^\/*(disconnect)\s*[A-Za-z]*\s*[A-Za-z]*$`),
+		"alert":      regexp.MustCompile(`^\/(a|A)(lert)\s+([A-Za-z-]+:)?[A-Za-z]+([\/_-][A-Za-z]+)?\s+(>|<|(?i:above|below|cross)\s)?\s*[0-9]+\.*[0-9]*(\s+((?i:tol|cooldown)=\S+|(?i:once|recurring)))*$`),
+		"funding":    regexp.MustCompile(`^\/funding\s+([A-Za-z-]+:)?[A-Za-z]+([\/_-][A-Za-z]+)?\s+(>|<|(?i:above|below|cross)\s)\s*-?[0-9]+\.*[0-9]*%?(\s+((?i:cooldown)=\S+|(?i:once|recurring)))*$`),
+		"change":     regexp.MustCompile(`^\/change\s+([A-Za-z-]+:)?[A-Za-z]+([\/_-][A-Za-z]+)?\s+((?i:up|down|either)\s+)?[0-9]+\.*[0-9]*%?\s+[0-9]+\.*[0-9]*[mhd](\s+((?i:cooldown)=\S+|(?i:once|recurring)))*$`),
+		"defaults":   regexp.MustCompile(`^\/defaults(\s+(?i:tol|cooldown)=\S+)*$`),
+		"history":    regexp.MustCompile(`^\/history$`),
+		"price":      regexp.MustCompile(`^\/(p|P)rice\s([a-zA-Z-]+:)?[a-zA-Z]+([\/_-][a-zA-Z]+)?$`),
+		"disconnect": regexp.MustCompile(`^\/*(disconnect)\s*[A-Za-z0-9]*$`),
+		"list":       regexp.MustCompile(`^\/(l|L)ist$`),
+		"edit":       regexp.MustCompile(`^\/*(e|E)dit\s+([A-Za-z-]+:)?[A-Za-z0-9]+([\/_-][A-Za-z0-9]+)?(\s+[0-9]+\.*[0-9]*)?$`),
+		"alertStart": regexp.MustCompile(`^\/(a|A)lert$`),
+		"cancel":     regexp.MustCompile(`^\/(c|C)ancel$`),
+		"conv":       regexp.MustCompile(`^conv\s+(cancel|pair\s+[a-z0-9:-]+|cond\s+[a-z]+)$`),
+		"retry":      regexp.MustCompile(`^\/((a|A)lert|(p|P)rice|funding|change)\s`),
 		"splitter":   regexp.MustCompile(`\s`),
 	}
 }
 
-func findDisconnectAlert(market, pair string, alerts []db.Alert) (int, error) {
+func findDisconnectAlert(alertHex string, alerts []db.Alert) (int, error) {
 	if len(alerts) == 0 {
 		return -2, errors.New("pairs shouldn't be empty")
 	}
 
-	if pair == "all" || market == "all" {
+	if alertHex == "all" {
 		return -1, nil
 	}
-	alert, err := db.NewAlert(db.WithMarket(market), db.WithPair(pair))
+	alert := &db.Alert{Hex: alertHex}
+	return alert.SortNFind(alerts)
+}
+
+// newSecretToken makes a token for setWebhook's secret_token, it only uses characters Telegram allows
+func newSecretToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
 	if err != nil {
-		return -2, err
+		return "", fmt.Errorf("newSecretToken: %s", err)
 	}
-	return alert.SortNFind(alerts)
+	return hex.EncodeToString(b), nil
+}
+
+// recentUpdates remembers the last update ids so a redelivered update is handled once
+type recentUpdates struct {
+	mu   sync.Mutex
+	seen map[int]bool
+	ring []int
+	next int
+}
+
+func newRecentUpdates(size int) *recentUpdates {
+	return &recentUpdates{seen: make(map[int]bool, size), ring: make([]int, 0, size)}
+}
+
+// Seen reports whether id was already delivered and remembers it otherwise
+func (r *recentUpdates) Seen(id int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.seen[id] {
+		return true
+	}
+	if len(r.ring) < cap(r.ring) {
+		r.ring = append(r.ring, id)
+	} else {
+		delete(r.seen, r.ring[r.next])
+		r.ring[r.next] = id
+		r.next = (r.next + 1) % len(r.ring)
+	}
+	r.seen[id] = true
+	return false
 }
 
 // func pairSearch(pairs []string, pair string) int {
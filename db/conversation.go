@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	models "github.com/HomelessHunter/CTC/db/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func GetConversationCollection(client *mongo.Client) *mongo.Collection {
+	return client.Database("crypto_bot").Collection("conversations", options.Collection())
+}
+
+// EnsureConversationIndexes lets MongoDB drop conversations nobody came back to,
+// the bot itself treats them as expired much earlier
+func EnsureConversationIndexes(coll *mongo.Collection, ctx context.Context) error {
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{primitive.E{Key: "updated_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(24 * 60 * 60),
+	})
+	if err != nil {
+		return fmt.Errorf("EnsureConversationIndexes: %s", err)
+	}
+	return nil
+}
+
+func GetConversation(coll *mongo.Collection, chatID int64, ctx context.Context) (*models.Conversation, error) {
+	var conv models.Conversation
+	err := coll.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: chatID}}).Decode(&conv)
+	if err != nil {
+		return nil, err
+	}
+	return &conv, nil
+}
+
+// SaveConversation replaces the chat's conversation, there's only one per chat
+func SaveConversation(coll *mongo.Collection, conv *models.Conversation, ctx context.Context) error {
+	_, err := coll.ReplaceOne(ctx, bson.D{primitive.E{Key: "_id", Value: conv.ChatID}}, conv, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("SaveConversation: %s", err)
+	}
+	return nil
+}
+
+func DeleteConversation(coll *mongo.Collection, chatID int64, ctx context.Context) error {
+	_, err := coll.DeleteOne(ctx, bson.D{primitive.E{Key: "_id", Value: chatID}})
+	if err != nil {
+		return fmt.Errorf("DeleteConversation: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	models "github.com/HomelessHunter/CTC/db/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore keeps everything in process memory, it's meant for tests and local runs without MongoDB
+type MemoryStore struct {
+	mu            sync.RWMutex
+	users         map[int64]models.MongoUser
+	alerts        []models.Alert
+	conversations map[int64]models.Conversation
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{users: make(map[int64]models.MongoUser), conversations: make(map[int64]models.Conversation)}
+}
+
+func (s *MemoryStore) Init(ctx context.Context) error {
+	return nil
+}
+
+func (s *MemoryStore) InsertNewUser(user *models.MongoUser, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[user.UsedID]; ok {
+		return errors.New("InsertNewUser: user already exists")
+	}
+	stored := *user
+	stored.Alerts = nil
+	stored.History = nil
+	s.users[user.UsedID] = stored
+	return nil
+}
+
+func (s *MemoryStore) GetUserByID(id int64, ctx context.Context) (*models.MongoUser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.users[id]
+	if !ok {
+		return nil, ErrNoUser
+	}
+	return &user, nil
+}
+
+func (s *MemoryStore) DeleteUserByID(id int64, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, id)
+	s.filter(func(alert *models.Alert) bool { return alert.UserID != id })
+	return nil
+}
+
+func (s *MemoryStore) SetUserDefaults(id int64, tolerance float64, cooldown time.Duration, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return ErrNoUser
+	}
+	if tolerance > 0 {
+		user.Tolerance = tolerance
+	}
+	if cooldown > 0 {
+		user.Cooldown = cooldown
+	}
+	user.Timestamp = time.Now().In(time.UTC)
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryStore) GetUsersWithPairs(connected bool, ctx context.Context) ([]models.MongoUser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	byUser := make(map[int64][]models.Alert)
+	ids := make([]int64, 0)
+	for _, v := range s.alerts {
+		if v.Archived || v.Suspended || v.Connected != connected {
+			continue
+		}
+		if _, ok := byUser[v.UserID]; !ok {
+			ids = append(ids, v.UserID)
+		}
+		byUser[v.UserID] = append(byUser[v.UserID], v)
+	}
+
+	users := make([]models.MongoUser, 0, len(ids))
+	for _, id := range ids {
+		user, ok := s.users[id]
+		if !ok {
+			continue
+		}
+		user.Alerts = byUser[id]
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (s *MemoryStore) AddAlert(id int64, alert *models.Alert, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	alert.UserID = id
+	if alert.Id.IsZero() {
+		alert.Id = primitive.NewObjectID()
+		alert.Hex = alert.Id.Hex()
+	}
+	for _, v := range s.alerts {
+		if v.Id == alert.Id {
+			return errors.New("AddAlert: alert already exists")
+		}
+	}
+	s.alerts = append(s.alerts, *alert)
+	return nil
+}
+
+func (s *MemoryStore) RemoveAlert(id int64, alertID primitive.ObjectID, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filter(func(alert *models.Alert) bool { return alert.UserID != id || alert.Id != alertID })
+	return nil
+}
+
+func (s *MemoryStore) DeleteAlerts(id int64, alerts []models.Alert, ctx context.Context) error {
+	ids := make(map[primitive.ObjectID]bool, len(alerts))
+	for _, v := range alerts {
+		ids[v.Id] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filter(func(alert *models.Alert) bool { return alert.UserID != id || !ids[alert.Id] })
+	return nil
+}
+
+func (s *MemoryStore) ArchiveAlert(id int64, alert *models.Alert, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.update(func(v *models.Alert) {
+		if v.UserID == id && v.Id == alert.Id {
+			v.Archived = true
+			v.Connected = false
+			v.TriggeredAt = alert.TriggeredAt
+			v.LastSignal = alert.LastSignal
+		}
+	})
+	return nil
+}
+
+func (s *MemoryStore) SetTargetPrice(id int64, alertID primitive.ObjectID, price float64, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := false
+	s.update(func(alert *models.Alert) {
+		if alert.UserID == id && alert.Id == alertID && !alert.Archived {
+			alert.TargetPrice = price
+			alert.LastSignal = time.Time{}
+			found = true
+		}
+	})
+	if !found {
+		return ErrNoAlert
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetAlerts(id int64, ctx context.Context) ([]models.Alert, error) {
+	return s.find(func(alert *models.Alert) bool { return alert.UserID == id && !alert.Archived }), nil
+}
+
+func (s *MemoryStore) GetHistory(id int64, ctx context.Context) ([]models.Alert, error) {
+	return s.find(func(alert *models.Alert) bool { return alert.UserID == id && alert.Archived }), nil
+}
+
+func (s *MemoryStore) UpdateAlertsSqc(id int64, alerts []models.Alert, connected bool, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setConnected(alerts, connected)
+	return nil
+}
+
+func (s *MemoryStore) ShutdownSequence(sessionAlerts map[int64]*[]models.Alert, count int, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range sessionAlerts {
+		s.setConnected(*v, false)
+	}
+	return nil
+}
+
+func (s *MemoryStore) SuspendAlerts(id int64, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.update(func(alert *models.Alert) {
+		if alert.UserID == id && !alert.Archived {
+			alert.Suspended = true
+			alert.Connected = false
+		}
+	})
+	return nil
+}
+
+func (s *MemoryStore) ResumeAlerts(id int64, ctx context.Context) ([]models.Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	alerts := make([]models.Alert, 0)
+	s.update(func(alert *models.Alert) {
+		if alert.UserID == id && alert.Suspended && !alert.Archived {
+			alert.Suspended = false
+			alerts = append(alerts, *alert)
+		}
+	})
+	return alerts, nil
+}
+
+func (s *MemoryStore) GetConversation(chatID int64, ctx context.Context) (*models.Conversation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	conv, ok := s.conversations[chatID]
+	if !ok {
+		return nil, ErrNoConversation
+	}
+	return &conv, nil
+}
+
+func (s *MemoryStore) SaveConversation(conv *models.Conversation, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conversations[conv.ChatID] = *conv
+	return nil
+}
+
+func (s *MemoryStore) DeleteConversation(chatID int64, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conversations, chatID)
+	return nil
+}
+
+// setConnected, filter and update must be called with mu held
+func (s *MemoryStore) setConnected(alerts []models.Alert, connected bool) {
+	ids := make(map[primitive.ObjectID]bool, len(alerts))
+	for _, v := range alerts {
+		ids[v.Id] = true
+	}
+	s.update(func(alert *models.Alert) {
+		if ids[alert.Id] {
+			alert.Connected = connected
+		}
+	})
+}
+
+func (s *MemoryStore) filter(keep func(alert *models.Alert) bool) {
+	alerts := s.alerts[:0]
+	for i := range s.alerts {
+		if keep(&s.alerts[i]) {
+			alerts = append(alerts, s.alerts[i])
+		}
+	}
+	s.alerts = alerts
+}
+
+func (s *MemoryStore) update(fn func(alert *models.Alert)) {
+	for i := range s.alerts {
+		fn(&s.alerts[i])
+	}
+}
+
+func (s *MemoryStore) find(match func(alert *models.Alert) bool) []models.Alert {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	alerts := make([]models.Alert, 0)
+	for i := range s.alerts {
+		if match(&s.alerts[i]) {
+			alerts = append(alerts, s.alerts[i])
+		}
+	}
+	return alerts
+}
@@ -0,0 +1,190 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	db "github.com/HomelessHunter/CTC/db/models"
+)
+
+func prepareMemory(t *testing.T) (*MemoryStore, []db.Alert) {
+	store := NewMemoryStore()
+	user, _ := db.NewMongoUser(db.WithUserID(ID), db.WithChatID(1))
+	err := store.InsertNewUser(user, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alertBi, _ := db.NewAlert(db.WithPair("ethbusd"), db.WithTargetPrice(4000.0), db.WithMarket("binance"))
+	alertBin, _ := db.NewAlert(db.WithPair("ethbusd"), db.WithTargetPrice(4200.0), db.WithMarket("binance"))
+	alertHuo, _ := db.NewAlert(db.WithPair("ethusdt"), db.WithTargetPrice(4200.0), db.WithMarket("huobi"))
+	alerts := []db.Alert{*alertBi, *alertBin, *alertHuo}
+	for i := range alerts {
+		err = store.AddAlert(ID, &alerts[i], context.TODO())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store, alerts
+}
+
+func TestMemoryUsers(t *testing.T) {
+	store, _ := prepareMemory(t)
+
+	user, _ := db.NewMongoUser(db.WithUserID(ID))
+	if err := store.InsertNewUser(user, context.TODO()); err == nil {
+		t.Error("inserting the same user twice should fail")
+	}
+	if _, err := store.GetUserByID(2, context.TODO()); err != ErrNoUser {
+		t.Errorf("expected ErrNoUser, got %v", err)
+	}
+
+	err := store.SetUserDefaults(ID, 0.002, time.Hour, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err = store.GetUserByID(ID, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.GetTolerance() != 0.002 || user.GetCooldown() != time.Hour {
+		t.Errorf("defaults weren't saved: %v %v", user.GetTolerance(), user.GetCooldown())
+	}
+
+	err = store.DeleteUserByID(ID, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerts, _ := store.GetAlerts(ID, context.TODO())
+	if len(alerts) != 0 {
+		t.Errorf("alerts should be deleted with the user, got %d", len(alerts))
+	}
+}
+
+func TestMemoryAlerts(t *testing.T) {
+	store, alerts := prepareMemory(t)
+
+	err := store.RemoveAlert(ID, alerts[0].Id, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := store.GetAlerts(ID, context.TODO())
+	if len(stored) != 2 || stored[0].Id != alerts[1].Id {
+		t.Errorf("unexpected alerts %v", stored)
+	}
+
+	err = store.SetTargetPrice(ID, alerts[1].Id, 4300, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ = store.GetAlerts(ID, context.TODO())
+	if stored[0].TargetPrice != 4300 {
+		t.Errorf("target wasn't updated: %v", stored[0])
+	}
+	if err = store.SetTargetPrice(ID, alerts[0].Id, 4300, context.TODO()); err != ErrNoAlert {
+		t.Errorf("expected ErrNoAlert, got %v", err)
+	}
+
+	alerts[1].TriggeredAt = time.Now()
+	err = store.ArchiveAlert(ID, &alerts[1], context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ = store.GetAlerts(ID, context.TODO())
+	history, _ := store.GetHistory(ID, context.TODO())
+	if len(stored) != 1 || len(history) != 1 || history[0].Id != alerts[1].Id {
+		t.Errorf("alert wasn't archived: %v %v", stored, history)
+	}
+
+	err = store.DeleteAlerts(ID, stored, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ = store.GetAlerts(ID, context.TODO())
+	if len(stored) != 0 {
+		t.Errorf("unexpected alerts %v", stored)
+	}
+}
+
+func TestMemoryUsersWithPairs(t *testing.T) {
+	store, alerts := prepareMemory(t)
+
+	users, _ := store.GetUsersWithPairs(false, context.TODO())
+	if len(users) != 1 || len(users[0].Alerts) != 3 {
+		t.Fatalf("unexpected users %v", users)
+	}
+
+	err := store.UpdateAlertsSqc(ID, alerts[:2], true, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, _ = store.GetUsersWithPairs(true, context.TODO())
+	if len(users) != 1 || len(users[0].Alerts) != 2 {
+		t.Fatalf("unexpected users %v", users)
+	}
+
+	err = store.ShutdownSequence(map[int64]*[]db.Alert{ID: &users[0].Alerts}, 2, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, _ = store.GetUsersWithPairs(true, context.TODO())
+	if len(users) != 0 {
+		t.Errorf("alerts should be disconnected, got %v", users)
+	}
+}
+
+func TestMemorySuspendAlerts(t *testing.T) {
+	store, alerts := prepareMemory(t)
+	err := store.ArchiveAlert(ID, &alerts[0], context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.SuspendAlerts(ID, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, _ := store.GetUsersWithPairs(false, context.TODO())
+	if len(users) != 0 {
+		t.Errorf("suspended alerts shouldn't be restored on start, got %v", users)
+	}
+
+	resumed, err := store.ResumeAlerts(ID, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resumed) != 2 {
+		t.Fatalf("archived alerts should stay in history, resumed %v", resumed)
+	}
+	for _, v := range resumed {
+		if v.Suspended || v.Connected {
+			t.Errorf("resumed alert should be disconnected until watched, got %v", v)
+		}
+	}
+	resumed, _ = store.ResumeAlerts(ID, context.TODO())
+	if len(resumed) != 0 {
+		t.Errorf("nothing is left to resume, got %v", resumed)
+	}
+}
+
+func TestMemoryConversations(t *testing.T) {
+	store := NewMemoryStore()
+	if _, err := store.GetConversation(1, context.TODO()); err != ErrNoConversation {
+		t.Errorf("expected ErrNoConversation, got %v", err)
+	}
+	conv, _ := db.NewConversation(db.WithConvChatID(1), db.WithConvUserID(ID))
+	store.SaveConversation(conv, context.TODO())
+	conv.Next(db.StepCondition)
+	conv.Pair = "btcusdt"
+	store.SaveConversation(conv, context.TODO())
+
+	stored, err := store.GetConversation(1, context.TODO())
+	if err != nil || stored.Step != db.StepCondition || stored.Pair != "btcusdt" {
+		t.Errorf("unexpected conversation %v (%v)", stored, err)
+	}
+	store.DeleteConversation(1, context.TODO())
+	if _, err = store.GetConversation(1, context.TODO()); err != ErrNoConversation {
+		t.Errorf("expected ErrNoConversation, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"errors"
+	"time"
+)
+
+// Conversation steps of a bare /alert, each one waits for the user's answer
+const (
+	StepPair      string = "pair"
+	StepCondition string = "condition"
+	StepPrice     string = "price"
+)
+
+// ConversationTimeout is how long a conversation waits for the next answer
+const ConversationTimeout time.Duration = 10 * time.Minute
+
+// Conversation is the state of an alert being created step by step in a chat
+type Conversation struct {
+	ChatID    int64     `bson:"_id"`
+	UserID    int64     `bson:"user_id"`
+	Step      string    `bson:"step"`
+	Pair      string    `bson:"pair,omitempty"`
+	Market    string    `bson:"market,omitempty"`
+	Condition string    `bson:"condition,omitempty"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+func NewConversation(opts ...ConversationOpts) (*Conversation, error) {
+	conv := Conversation{Step: StepPair}
+	for _, opt := range opts {
+		err := opt(&conv)
+		if err != nil {
+			return nil, err
+		}
+	}
+	conv.UpdatedAt = time.Now().In(time.UTC)
+
+	return &conv, nil
+}
+
+// Expired reports whether the user took longer than ConversationTimeout to answer
+func (conv *Conversation) Expired(now time.Time) bool {
+	return now.Sub(conv.UpdatedAt) > ConversationTimeout
+}
+
+// Next moves the conversation to step and restarts its timeout
+func (conv *Conversation) Next(step string) {
+	conv.Step = step
+	conv.UpdatedAt = time.Now().In(time.UTC)
+}
+
+type ConversationOpts func(*Conversation) error
+
+func WithConvChatID(chatID int64) ConversationOpts {
+	return func(c *Conversation) error {
+		if chatID == 0 {
+			return errors.New("chatID shouldn't be empty")
+		}
+
+		c.ChatID = chatID
+		return nil
+	}
+}
+
+func WithConvUserID(userID int64) ConversationOpts {
+	return func(c *Conversation) error {
+		if userID < 0 {
+			return errors.New("userID should be positive")
+		}
+
+		c.UserID = userID
+		return nil
+	}
+}
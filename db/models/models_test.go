@@ -54,3 +54,192 @@ func TestAlert(t *testing.T) {
 	alerts = append(alerts, binAlert)
 	fmt.Println(alerts[0])
 }
+
+func TestAlertTriggered(t *testing.T) {
+	cases := []struct {
+		condition   string
+		prev, price float64
+		seen        bool
+		want        bool
+	}{
+		{ConditionNear, 0, 70500, false, true},
+		{ConditionNear, 0, 71000, false, false},
+		{"", 69000, 69400, true, true},
+		{ConditionAbove, 0, 70001, false, true},
+		{ConditionAbove, 69000, 72000, true, true},
+		{ConditionAbove, 70500, 71000, true, false},
+		{ConditionAbove, 71000, 69000, true, false},
+		{ConditionBelow, 71000, 65000, true, true},
+		{ConditionBelow, 69000, 68000, true, false},
+		{ConditionCross, 0, 70000, false, false},
+		{ConditionCross, 60000, 75000, true, true},
+		{ConditionCross, 75000, 60000, true, true},
+		{ConditionCross, 71000, 72000, true, false},
+	}
+	for _, v := range cases {
+		alert := Alert{TargetPrice: 70000, Condition: v.condition}
+		if got := alert.Triggered(v.prev, v.price, v.seen); got != v.want {
+			t.Errorf("%s prev %f price %f: expected %t, got %t", v.condition, v.prev, v.price, v.want, got)
+		}
+	}
+
+	// funding rates sit at 0 often, a previous 0 is a real value
+	funding := Alert{Kind: KindFunding, Condition: ConditionCross, TargetPrice: 0.0001}
+	if !funding.Triggered(0, 0.0005, true) {
+		t.Error("funding crossing up from 0 should fire")
+	}
+	funding.Condition = ConditionAbove
+	if funding.Triggered(0.0002, 0.0003, true) || !funding.Triggered(0, 0.0002, true) {
+		t.Error("funding above should fire once the rate goes from 0 past the target")
+	}
+	funding.Condition, funding.TargetPrice = ConditionBelow, 0
+	if funding.Triggered(0, 0, true) || !funding.Triggered(0.0001, 0, true) {
+		t.Error("funding below 0 should wait for the rate to come down to it")
+	}
+}
+
+func TestFundingAlert(t *testing.T) {
+	alert, err := NewAlert(WithMarket("binance-futures"), WithPair("btcusdt"), WithCondition(">"), WithFundingRate(0.0005))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alert.GetKind() != KindFunding || alert.Describe() != "funding > 0.05%" {
+		t.Errorf("unexpected alert %s %s", alert.GetKind(), alert.Describe())
+	}
+	if alert.Triggered(0, 0.0001, false) || !alert.Triggered(0.0001, 0.0006, true) {
+		t.Error("funding alert should fire when the rate goes past the target")
+	}
+	price := Alert{Market: alert.Market, Pair: alert.Pair, Condition: alert.Condition, TargetPrice: alert.TargetPrice}
+	if alert.Same(&price) {
+		t.Error("a price alert isn't the same as a funding one")
+	}
+	if _, err = NewAlert(WithFundingRate(-1.5)); err == nil {
+		t.Error("out of range rate should fail")
+	}
+}
+
+func TestChangeAlert(t *testing.T) {
+	alert, err := NewAlert(WithMarket("binance"), WithPair("solusdt"), WithChange(0.05, time.Hour, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alert.Describe() != "±5% in 1h" {
+		t.Errorf("unexpected description %s", alert.Describe())
+	}
+	cases := []struct {
+		direction    string
+		prev, change float64
+		want         bool
+	}{
+		{DirectionUp, 0, 0.06, true},
+		{DirectionUp, 0.06, 0.07, false},
+		{DirectionUp, 0, -0.06, false},
+		{DirectionDown, 0.01, -0.05, true},
+		{DirectionDown, -0.06, -0.07, false},
+		{DirectionEither, 0.01, -0.05, true},
+		{DirectionEither, 0.02, 0.049, false},
+	}
+	for _, v := range cases {
+		alert.Condition = v.direction
+		if got := alert.Triggered(v.prev, v.change, true); got != v.want {
+			t.Errorf("%s prev %f change %f: expected %t, got %t", v.direction, v.prev, v.change, v.want, got)
+		}
+	}
+	if _, err = ParseWindow("30s"); err == nil {
+		t.Error("windows under a minute should fail")
+	}
+	if window, err := ParseWindow("1d"); err != nil || window != 24*time.Hour {
+		t.Errorf("1d: got %s %v", window, err)
+	}
+}
+
+func TestParseCondition(t *testing.T) {
+	for input, want := range map[string]string{"": ConditionNear, ">": ConditionAbove, "Below": ConditionBelow, "<": ConditionBelow, "cross": ConditionCross} {
+		got, err := ParseCondition(input)
+		if err != nil || got != want {
+			t.Errorf("%q: expected %s, got %s (%v)", input, want, got, err)
+		}
+	}
+	if _, err := ParseCondition(">="); err == nil {
+		t.Error("unknown condition should fail")
+	}
+}
+
+func TestAlertTolerance(t *testing.T) {
+	alert := Alert{TargetPrice: 3000, Tolerance: 0.002}
+	if !alert.Triggered(0, 3005, false) || alert.Triggered(0, 3010, false) {
+		t.Error("tolerance band isn't applied")
+	}
+}
+
+func TestAlertReady(t *testing.T) {
+	now := time.Now()
+	alert := Alert{}
+	if !alert.Ready(now) {
+		t.Error("alert that never fired should be ready")
+	}
+	alert.LastSignal = now.Add(-20 * time.Minute)
+	if !alert.Ready(now) {
+		t.Error("default cooldown should have passed")
+	}
+	alert.Cooldown = time.Hour
+	if alert.Ready(now) {
+		t.Error("custom cooldown shouldn't have passed")
+	}
+}
+
+func TestParseToleranceAndCooldown(t *testing.T) {
+	tolerance, err := ParseTolerance("0.2%")
+	if err != nil || tolerance != 0.002 {
+		t.Errorf("expected 0.002, got %f (%v)", tolerance, err)
+	}
+	if _, err = ParseTolerance("150%"); err == nil {
+		t.Error("tolerance over 100% should fail")
+	}
+	for input, want := range map[string]time.Duration{"1h": time.Hour, "30m": 30 * time.Minute, "1d": 24 * time.Hour} {
+		cooldown, err := ParseCooldown(input)
+		if err != nil || cooldown != want {
+			t.Errorf("%s: expected %s, got %s (%v)", input, want, cooldown, err)
+		}
+	}
+	for _, input := range []string{"-1h", "0s", "-1d", "0d"} {
+		if _, err = ParseCooldown(input); err == nil {
+			t.Errorf("%s: cooldown should be positive", input)
+		}
+	}
+}
+
+func TestAlertFireOnce(t *testing.T) {
+	now := time.Now()
+	once := Alert{Mode: ModeOnce}
+	once.Fire(now)
+	if once.Ready(now.Add(24 * time.Hour)) {
+		t.Error("one-shot alert shouldn't fire twice")
+	}
+
+	// alerts without a mode predate one-shot alerts and keep recurring
+	recurring := Alert{}
+	recurring.Fire(now)
+	if !recurring.TriggeredAt.IsZero() || !recurring.Ready(now.Add(DefaultCooldown)) {
+		t.Error("recurring alert should fire again after the cooldown")
+	}
+}
+
+func TestConversationExpired(t *testing.T) {
+	conv, err := NewConversation(WithConvChatID(1), WithConvUserID(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conv.Step != StepPair {
+		t.Errorf("a conversation starts with the pair, got %s", conv.Step)
+	}
+	if conv.Expired(conv.UpdatedAt.Add(ConversationTimeout)) {
+		t.Error("conversation expired too early")
+	}
+	if !conv.Expired(conv.UpdatedAt.Add(ConversationTimeout + time.Second)) {
+		t.Error("conversation should have expired")
+	}
+	if _, err = NewConversation(WithConvChatID(0)); err == nil {
+		t.Error("chat is required")
+	}
+}
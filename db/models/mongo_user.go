@@ -1,24 +1,54 @@
 package db
 
 import (
-	"encoding/hex"
 	"errors"
 	"fmt"
+	"math"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Fallbacks for alerts and users that don't set their own tolerance or cooldown
+const (
+	DefaultTolerance float64       = 0.01
+	DefaultCooldown  time.Duration = 15 * time.Minute
 )
 
 type MongoUser struct {
-	UsedID    int64     `bson:"_id"`
-	ChatID    int64     `bson:"chat_id"`
-	Alerts    []Alert   `bson:"alerts"`
+	UsedID int64 `bson:"_id"`
+	ChatID int64 `bson:"chat_id"`
+	// Alerts live in the alerts collection and are attached in memory,
+	// embedded alerts and history are legacy data read by MigrateAlerts
+	Alerts    []Alert   `bson:"alerts,omitempty"`
+	History   []Alert   `bson:"history,omitempty"`
 	Timestamp time.Time `bson:"timestamp"`
+	// Tolerance and Cooldown are copied into new alerts that don't set them
+	Tolerance float64       `bson:"tolerance,omitempty"`
+	Cooldown  time.Duration `bson:"cooldown,omitempty"`
 }
 
 func (user *MongoUser) String() string {
 	return fmt.Sprintf("UserID: %d\nChatID: %d\nAlerts: %v\nTimestamp: %v\n", user.UsedID, user.ChatID, user.Alerts, user.Timestamp)
 }
 
+func (user *MongoUser) GetTolerance() float64 {
+	if user.Tolerance <= 0 {
+		return DefaultTolerance
+	}
+	return user.Tolerance
+}
+
+func (user *MongoUser) GetCooldown() time.Duration {
+	if user.Cooldown <= 0 {
+		return DefaultCooldown
+	}
+	return user.Cooldown
+}
+
 func NewMongoUser(opts ...MongoUserOpts) (*MongoUser, error) {
 	user := MongoUser{}
 	for _, opt := range opts {
@@ -67,17 +97,292 @@ func WithAlerts(alerts ...Alert) MongoUserOpts {
 	}
 }
 
+// Alert conditions. Alerts stored before conditions existed have none and behave as ConditionNear
+const (
+	ConditionNear  string = "near"
+	ConditionAbove string = "above"
+	ConditionBelow string = "below"
+	ConditionCross string = "cross"
+)
+
+// ParseTolerance reads a percentage like "0.2%" or "0.2" into a fraction
+func ParseTolerance(tolerance string) (float64, error) {
+	value, err := strconv.ParseFloat(strings.TrimSuffix(tolerance, "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("ParseTolerance: %s", err)
+	}
+	if value <= 0 || value >= 100 {
+		return 0, fmt.Errorf("ParseTolerance: %s is out of range", tolerance)
+	}
+	return value / 100, nil
+}
+
+// ParseCooldown reads a duration like "30m" or "1h", "d" stands for 24h
+func ParseCooldown(cooldown string) (time.Duration, error) {
+	var duration time.Duration
+	if strings.HasSuffix(cooldown, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(cooldown, "d"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("ParseCooldown: %s", err)
+		}
+		duration = time.Duration(days * float64(24*time.Hour))
+	} else {
+		var err error
+		duration, err = time.ParseDuration(cooldown)
+		if err != nil {
+			return 0, fmt.Errorf("ParseCooldown: %s", err)
+		}
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("ParseCooldown: %s should be positive", cooldown)
+	}
+	return duration, nil
+}
+
+// Alert modes. Alerts stored before modes existed have none and keep recurring
+const (
+	ModeOnce      string = "once"
+	ModeRecurring string = "recurring"
+)
+
+// Alert kinds. Price alerts watch the last price and are stored without a kind, funding
+// alerts watch a perpetual's funding rate and keep it in TargetPrice as a fraction, change
+// alerts watch how much the price moved within Window and keep the move in TargetPrice
+const (
+	KindPrice   string = "price"
+	KindFunding string = "funding"
+	KindChange  string = "change"
+)
+
+// Directions of a change alert, they take the place of the condition
+const (
+	DirectionUp     string = "up"
+	DirectionDown   string = "down"
+	DirectionEither string = "either"
+)
+
+// Change alerts look back at least a minute and at most a day
+const (
+	MinWindow time.Duration = time.Minute
+	MaxWindow time.Duration = 24 * time.Hour
+)
+
+// ParseDirection maps user input to a direction, none means either way
+func ParseDirection(direction string) (string, error) {
+	switch strings.ToLower(direction) {
+	case "", DirectionEither:
+		return DirectionEither, nil
+	case DirectionUp:
+		return DirectionUp, nil
+	case DirectionDown:
+		return DirectionDown, nil
+	}
+	return "", fmt.Errorf("unknown direction %s", direction)
+}
+
+// ParseWindow reads a window like "15m", "4h" or "1d" and checks it's between MinWindow and MaxWindow
+func ParseWindow(window string) (time.Duration, error) {
+	duration, err := ParseCooldown(window)
+	if err != nil {
+		return 0, fmt.Errorf("ParseWindow: %s", err)
+	}
+	if duration < MinWindow || duration > MaxWindow {
+		return 0, fmt.Errorf("ParseWindow: %s should be between 1m and 24h", window)
+	}
+	return duration, nil
+}
+
 type Alert struct {
-	Market      string    `bson:"market"`
-	Pair        string    `bson:"pair"`
-	TargetPrice float64   `bson:"target_price"`
-	Connected   bool      `bson:"connected"`
-	LastSignal  time.Time `bson:"last_signal,omitempty"`
-	Hex         string    `bson:"hex"`
+	Id          primitive.ObjectID `bson:"_id,omitempty"`
+	UserID      int64              `bson:"user_id"`
+	Market      string             `bson:"market"`
+	Pair        string             `bson:"pair"`
+	TargetPrice float64            `bson:"target_price"`
+	Condition   string             `bson:"condition,omitempty"`
+	Kind        string             `bson:"kind,omitempty"`
+	// Window is how far back a change alert looks
+	Window time.Duration `bson:"window,omitempty"`
+	// Tolerance is the band around TargetPrice for ConditionNear as a fraction (0.01 = 1%)
+	Tolerance float64 `bson:"tolerance,omitempty"`
+	// Cooldown is the minimum time between two notifications
+	Cooldown   time.Duration `bson:"cooldown,omitempty"`
+	Mode       string        `bson:"mode,omitempty"`
+	Connected  bool          `bson:"connected"`
+	LastSignal time.Time     `bson:"last_signal,omitempty"`
+	Hex        string        `bson:"hex"`
+	// TriggeredAt is set once a one-shot alert fires
+	TriggeredAt time.Time `bson:"triggered_at,omitempty"`
+	// Archived alerts fired once and are kept as history
+	Archived bool `bson:"archived,omitempty"`
+	// Suspended alerts belong to a user who blocked the bot, they aren't watched until the user is back
+	Suspended bool `bson:"suspended,omitempty"`
+	// LastPrice is the previous value seen by this alert (a funding rate for funding alerts), it lives in memory only
+	LastPrice float64 `bson:"-"`
+	// Seen is set once LastPrice holds a value, a funding rate of 0 is a real one
+	Seen bool `bson:"-"`
 }
 
 func (alert *Alert) String() string {
-	return fmt.Sprintf("Market: %s, Pair: %s, TargetPrice: %f", alert.Market, alert.Pair, alert.TargetPrice)
+	return fmt.Sprintf("Market: %s, Pair: %s, Condition: %s, TargetPrice: %f", alert.Market, alert.Pair, alert.Cond(), alert.TargetPrice)
+}
+
+func (alert *Alert) Cond() string {
+	if alert.GetKind() == KindChange {
+		if alert.Condition == "" {
+			return DirectionEither
+		}
+		return alert.Condition
+	}
+	if alert.Condition == "" {
+		return ConditionNear
+	}
+	return alert.Condition
+}
+
+func (alert *Alert) GetTolerance() float64 {
+	if alert.Tolerance <= 0 {
+		return DefaultTolerance
+	}
+	return alert.Tolerance
+}
+
+func (alert *Alert) GetCooldown() time.Duration {
+	if alert.Cooldown <= 0 {
+		return DefaultCooldown
+	}
+	return alert.Cooldown
+}
+
+func (alert *Alert) GetKind() string {
+	if alert.Kind == "" {
+		return KindPrice
+	}
+	return alert.Kind
+}
+
+func (alert *Alert) GetMode() string {
+	if alert.Mode == "" {
+		return ModeRecurring
+	}
+	return alert.Mode
+}
+
+// Ready reports whether the alert may notify again: one-shot alerts fire
+// only once, recurring ones wait for the cooldown since the last notification
+func (alert *Alert) Ready(now time.Time) bool {
+	if alert.GetMode() == ModeOnce && !alert.TriggeredAt.IsZero() {
+		return false
+	}
+	return alert.LastSignal.IsZero() || !now.Before(alert.LastSignal.Add(alert.GetCooldown()))
+}
+
+// Fire records a notification sent at now and disarms one-shot alerts
+func (alert *Alert) Fire(now time.Time) {
+	alert.LastSignal = now
+	if alert.GetMode() == ModeOnce {
+		alert.TriggeredAt = now
+	}
+}
+
+// Describe renders the condition the way users type it (e.g. "> 70000" or "funding > 0.05%")
+func (alert *Alert) Describe() string {
+	switch alert.GetKind() {
+	case KindFunding:
+		return fmt.Sprintf("funding %s %.4g%%", conditionSign(alert.Cond()), alert.TargetPrice*100)
+	case KindChange:
+		move := fmt.Sprintf("%s %.4g%%", alert.Cond(), alert.TargetPrice*100)
+		if alert.Cond() == DirectionEither {
+			move = fmt.Sprintf("±%.4g%%", alert.TargetPrice*100)
+		}
+		return fmt.Sprintf("%s in %s", move, formatWindow(alert.Window))
+	}
+	switch alert.Cond() {
+	case ConditionAbove:
+		return fmt.Sprintf("> %g", alert.TargetPrice)
+	case ConditionBelow:
+		return fmt.Sprintf("< %g", alert.TargetPrice)
+	case ConditionCross:
+		return fmt.Sprintf("cross %g", alert.TargetPrice)
+	default:
+		return fmt.Sprintf("~ %g ±%g%%", alert.TargetPrice, alert.GetTolerance()*100)
+	}
+}
+
+func conditionSign(condition string) string {
+	switch condition {
+	case ConditionAbove:
+		return ">"
+	case ConditionBelow:
+		return "<"
+	}
+	return condition
+}
+
+// formatWindow drops the zero units Duration.String keeps, 1h is "1h" rather than "1h0m0s"
+func formatWindow(window time.Duration) string {
+	switch {
+	case window%time.Hour == 0:
+		return fmt.Sprintf("%dh", window/time.Hour)
+	case window%time.Minute == 0:
+		return fmt.Sprintf("%dm", window/time.Minute)
+	}
+	return window.String()
+}
+
+// Triggered reports whether price meets the alert condition. Crossings are
+// detected against the previous tick, so a move that gaps through the target
+// still fires. The first tick has no previous price (seen is false): above/below
+// fire when the price is already past the target, cross waits for an actual crossing.
+func (alert *Alert) Triggered(prev, price float64, seen bool) bool {
+	target := alert.TargetPrice
+	if alert.GetKind() == KindChange {
+		// no previous move counts as no move at all
+		return alert.moved(prev, price)
+	}
+	switch alert.Cond() {
+	case ConditionAbove:
+		return price >= target && (!seen || prev < target)
+	case ConditionBelow:
+		return price <= target && (!seen || prev > target)
+	case ConditionCross:
+		if !seen {
+			return false
+		}
+		return (prev < target && price >= target) || (prev > target && price <= target)
+	default:
+		tolerance := alert.GetTolerance()
+		return price <= target+(target*tolerance) && price >= target-(target*tolerance)
+	}
+}
+
+// moved reports whether a change alert's move went past its target, prev and change are
+// moves over the window as fractions. Like above and below it fires again only once the
+// move fell back under the target
+func (alert *Alert) moved(prev, change float64) bool {
+	target := alert.TargetPrice
+	switch alert.Cond() {
+	case DirectionUp:
+		return change >= target && prev < target
+	case DirectionDown:
+		return change <= -target && prev > -target
+	default:
+		return math.Abs(change) >= target && math.Abs(prev) < target
+	}
+}
+
+// ParseCondition maps user input (">", "<", "above", "below", "cross") to a condition
+func ParseCondition(condition string) (string, error) {
+	switch strings.ToLower(condition) {
+	case "", ConditionNear:
+		return ConditionNear, nil
+	case ">", ConditionAbove:
+		return ConditionAbove, nil
+	case "<", ConditionBelow:
+		return ConditionBelow, nil
+	case ConditionCross:
+		return ConditionCross, nil
+	}
+	return "", fmt.Errorf("unknown condition %s", condition)
 }
 
 func NewAlert(opts ...MongoAlertOpts) (*Alert, error) {
@@ -89,11 +394,20 @@ func NewAlert(opts ...MongoAlertOpts) (*Alert, error) {
 		}
 	}
 
-	alert.Hex = hex.EncodeToString([]byte(alert.Market + alert.Pair))
+	if alert.Id.IsZero() {
+		alert.Id = primitive.NewObjectID()
+	}
+	alert.Hex = alert.Id.Hex()
 
 	return &alert, nil
 }
 
+// Same reports whether both alerts watch the same level, so creating one twice can be refused
+func (alert *Alert) Same(other *Alert) bool {
+	return alert.Market == other.Market && alert.Pair == other.Pair && alert.GetKind() == other.GetKind() &&
+		alert.Cond() == other.Cond() && alert.TargetPrice == other.TargetPrice && alert.Window == other.Window
+}
+
 func (alert *Alert) SetLastSignal(lastSignal time.Time) {
 	alert.LastSignal = lastSignal
 }
@@ -106,7 +420,6 @@ func SortByHEX(alerts []Alert) {
 
 func (alert *Alert) SortNFind(alerts []Alert) (int, error) {
 	SortByHEX(alerts)
-	fmt.Println("SORT", alerts)
 	i := sort.Search(len(alerts), func(i int) bool {
 		return alerts[i].Hex >= alert.Hex
 	})
@@ -179,6 +492,86 @@ func WithTargetPrice(targetPrice float64) MongoAlertOpts {
 	}
 }
 
+// WithFundingRate makes a funding alert on rate, a fraction that may be negative
+func WithFundingRate(rate float64) MongoAlertOpts {
+	return func(a *Alert) error {
+		if rate <= -1 || rate >= 1 {
+			return errors.New("funding rate should be between -100% and 100%")
+		}
+
+		a.Kind = KindFunding
+		a.TargetPrice = rate
+		return nil
+	}
+}
+
+// WithChange makes a change alert on a move of percent (a fraction) within window in direction
+func WithChange(percent float64, window time.Duration, direction string) MongoAlertOpts {
+	return func(a *Alert) error {
+		if percent <= 0 {
+			return errors.New("change should be positive")
+		}
+		if window < MinWindow || window > MaxWindow {
+			return errors.New("window should be between 1m and 24h")
+		}
+		direction, err := ParseDirection(direction)
+		if err != nil {
+			return err
+		}
+
+		a.Kind = KindChange
+		a.TargetPrice = percent
+		a.Window = window
+		a.Condition = direction
+		return nil
+	}
+}
+
+func WithCondition(condition string) MongoAlertOpts {
+	return func(a *Alert) error {
+		condition, err := ParseCondition(condition)
+		if err != nil {
+			return err
+		}
+
+		a.Condition = condition
+		return nil
+	}
+}
+
+func WithTolerance(tolerance float64) MongoAlertOpts {
+	return func(a *Alert) error {
+		if tolerance < 0 || tolerance >= 1 {
+			return errors.New("tolerance should be between 0 and 100%")
+		}
+
+		a.Tolerance = tolerance
+		return nil
+	}
+}
+
+func WithCooldown(cooldown time.Duration) MongoAlertOpts {
+	return func(a *Alert) error {
+		if cooldown < 0 {
+			return errors.New("cooldown should be positive")
+		}
+
+		a.Cooldown = cooldown
+		return nil
+	}
+}
+
+func WithMode(mode string) MongoAlertOpts {
+	return func(a *Alert) error {
+		if mode != ModeOnce && mode != ModeRecurring {
+			return fmt.Errorf("unknown mode %s", mode)
+		}
+
+		a.Mode = mode
+		return nil
+	}
+}
+
 func WithConnected(connected bool) MongoAlertOpts {
 	return func(a *Alert) error {
 		a.Connected = connected
@@ -196,6 +589,16 @@ func WithLastSignal(lastSignal time.Time) MongoAlertOpts {
 	}
 }
 
+func WithID(id primitive.ObjectID) MongoAlertOpts {
+	return func(a *Alert) error {
+		if id.IsZero() {
+			return errors.New("id shouldn't be empty")
+		}
+		a.Id = id
+		return nil
+	}
+}
+
 func WithHex(hex string) MongoAlertOpts {
 	return func(a *Alert) error {
 		if hex == "" {
@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"strings"
@@ -20,6 +21,10 @@ func GetUserCollection(client *mongo.Client) *mongo.Collection {
 	return client.Database("crypto_bot").Collection("users", options.Collection())
 }
 
+func GetAlertCollection(client *mongo.Client) *mongo.Collection {
+	return client.Database("crypto_bot").Collection("alerts", options.Collection())
+}
+
 func InsertNewUser(coll *mongo.Collection, user *models.MongoUser, ctx context.Context) error {
 	_, err := coll.InsertOne(ctx, user)
 	if err != nil {
@@ -37,16 +42,36 @@ func GetUserByID(coll *mongo.Collection, id int64, ctx context.Context) (*models
 	return &user, nil
 }
 
-func GetUsersWithPairs(coll *mongo.Collection, connected bool, ctx context.Context) ([]models.MongoUser, error) {
+// GetUsersWithPairs returns users owning alerts with the given connected state, Alerts holds only those alerts
+func GetUsersWithPairs(coll *mongo.Collection, alertColl *mongo.Collection, connected bool, ctx context.Context) ([]models.MongoUser, error) {
+	var alerts []models.Alert
+	cursor, err := alertColl.Find(ctx, bson.D{
+		primitive.E{Key: "connected", Value: connected},
+		primitive.E{Key: "archived", Value: bson.D{primitive.E{Key: "$ne", Value: true}}},
+		primitive.E{Key: "suspended", Value: bson.D{primitive.E{Key: "$ne", Value: true}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("GetUsersWithPairs: %s", err)
+	}
+	err = cursor.All(ctx, &alerts)
+	if err != nil {
+		return nil, fmt.Errorf("GetUsersWithPairs: %s", err)
+	}
+	if len(alerts) == 0 {
+		return nil, nil
+	}
+
+	byUser := make(map[int64][]models.Alert)
+	ids := make([]int64, 0)
+	for _, v := range alerts {
+		if _, ok := byUser[v.UserID]; !ok {
+			ids = append(ids, v.UserID)
+		}
+		byUser[v.UserID] = append(byUser[v.UserID], v)
+	}
+
 	var users []models.MongoUser
-	cursor, err := coll.Find(ctx, bson.D{
-		primitive.E{
-			Key: "alerts.0", Value: bson.D{primitive.E{Key: "$exists", Value: true}},
-		},
-		primitive.E{
-			Key: "alerts", Value: bson.D{primitive.E{Key: "$elemMatch", Value: bson.D{primitive.E{Key: "connected", Value: connected}}}},
-		}},
-	)
+	cursor, err = coll.Find(ctx, bson.D{primitive.E{Key: "_id", Value: bson.D{primitive.E{Key: "$in", Value: ids}}}})
 	if err != nil {
 		return nil, fmt.Errorf("GetUsersWithPairs: %s", err)
 	}
@@ -54,9 +79,28 @@ func GetUsersWithPairs(coll *mongo.Collection, connected bool, ctx context.Conte
 	if err != nil {
 		return nil, fmt.Errorf("GetUsersWithPairs: %s", err)
 	}
+	for i := range users {
+		users[i].Alerts = byUser[users[i].UsedID]
+	}
 	return users, nil
 }
 
+// SetUserDefaults updates the tolerance and cooldown copied into new alerts, zero values are left untouched
+func SetUserDefaults(coll *mongo.Collection, id int64, tolerance float64, cooldown time.Duration, ctx context.Context) error {
+	set := bson.D{primitive.E{Key: "timestamp", Value: time.Now().In(time.UTC)}}
+	if tolerance > 0 {
+		set = append(set, primitive.E{Key: "tolerance", Value: tolerance})
+	}
+	if cooldown > 0 {
+		set = append(set, primitive.E{Key: "cooldown", Value: cooldown})
+	}
+	_, err := coll.UpdateByID(ctx, id, bson.D{primitive.E{Key: "$set", Value: set}})
+	if err != nil {
+		return fmt.Errorf("SetUserDefaults: %s", err)
+	}
+	return nil
+}
+
 func DeleteUserByID(coll *mongo.Collection, id int64, ctx context.Context) error {
 	_, err := coll.DeleteOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}})
 	if err != nil {
@@ -66,9 +110,12 @@ func DeleteUserByID(coll *mongo.Collection, id int64, ctx context.Context) error
 }
 
 func AddAlert(coll *mongo.Collection, id int64, alert *models.Alert, ctx context.Context) error {
-	_, err := coll.UpdateByID(ctx, id, bson.D{
-		primitive.E{Key: "$push", Value: bson.D{primitive.E{Key: "alerts", Value: alert}}},
-		primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "timestamp", Value: time.Now().In(time.UTC)}}}})
+	alert.UserID = id
+	if alert.Id.IsZero() {
+		alert.Id = primitive.NewObjectID()
+		alert.Hex = alert.Id.Hex()
+	}
+	_, err := coll.InsertOne(ctx, alert)
 	if err != nil {
 		return err
 	}
@@ -76,9 +123,8 @@ func AddAlert(coll *mongo.Collection, id int64, alert *models.Alert, ctx context
 	return nil
 }
 
-func RemoveAlert(coll *mongo.Collection, id int64, pair string, ctx context.Context) error {
-	_, err := coll.UpdateByID(ctx, id, bson.D{primitive.E{Key: "$pull", Value: bson.D{primitive.E{Key: "alerts", Value: bson.D{primitive.E{Key: "pair", Value: pair}}}}},
-		primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "timestamp", Value: time.Now().In(time.UTC)}}}})
+func RemoveAlert(coll *mongo.Collection, id int64, alertID primitive.ObjectID, ctx context.Context) error {
+	_, err := coll.DeleteOne(ctx, bson.D{primitive.E{Key: "_id", Value: alertID}, primitive.E{Key: "user_id", Value: id}})
 	if err != nil {
 		return err
 	}
@@ -86,9 +132,70 @@ func RemoveAlert(coll *mongo.Collection, id int64, pair string, ctx context.Cont
 	return nil
 }
 
+// ArchiveAlert keeps a fired one-shot alert as history
+func ArchiveAlert(coll *mongo.Collection, id int64, alert *models.Alert, ctx context.Context) error {
+	_, err := coll.UpdateOne(ctx, bson.D{primitive.E{Key: "_id", Value: alert.Id}, primitive.E{Key: "user_id", Value: id}},
+		bson.D{primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "archived", Value: true},
+			primitive.E{Key: "connected", Value: false},
+			primitive.E{Key: "triggered_at", Value: alert.TriggeredAt},
+			primitive.E{Key: "last_signal", Value: alert.LastSignal},
+		}}})
+	if err != nil {
+		return fmt.Errorf("ArchiveAlert: %s", err)
+	}
+	return nil
+}
+
+// SetTargetPrice updates the target of an active alert in place and clears its last signal,
+// mongo.ErrNoDocuments means the user has no such alert
+func SetTargetPrice(coll *mongo.Collection, id int64, alertID primitive.ObjectID, price float64, ctx context.Context) error {
+	result, err := coll.UpdateOne(ctx, bson.D{
+		primitive.E{Key: "_id", Value: alertID},
+		primitive.E{Key: "user_id", Value: id},
+		primitive.E{Key: "archived", Value: bson.D{primitive.E{Key: "$ne", Value: true}}},
+	}, bson.D{
+		primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "target_price", Value: price}}},
+		primitive.E{Key: "$unset", Value: bson.D{primitive.E{Key: "last_signal", Value: ""}}},
+	})
+	if err != nil {
+		return fmt.Errorf("SetTargetPrice: %s", err)
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func GetHistory(coll *mongo.Collection, id int64, ctx context.Context) ([]models.Alert, error) {
+	var history []models.Alert
+	cursor, err := coll.Find(ctx, bson.D{primitive.E{Key: "user_id", Value: id}, primitive.E{Key: "archived", Value: true}})
+	if err != nil {
+		return nil, fmt.Errorf("GetHistory: %s", err)
+	}
+	err = cursor.All(ctx, &history)
+	if err != nil {
+		return nil, fmt.Errorf("GetHistory: %s", err)
+	}
+	return history, nil
+}
+
 func DeleteAlerts(coll *mongo.Collection, id int64, alerts []models.Alert, ctx context.Context) error {
-	_, err := coll.UpdateByID(ctx, id, bson.D{primitive.E{Key: "$pullAll", Value: bson.D{primitive.E{Key: "alerts", Value: alerts}}},
-		primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "timestamp", Value: time.Now().In(time.UTC)}}}})
+	ids := make([]primitive.ObjectID, len(alerts))
+	for i, v := range alerts {
+		ids[i] = v.Id
+	}
+	_, err := coll.DeleteMany(ctx, bson.D{primitive.E{Key: "user_id", Value: id},
+		primitive.E{Key: "_id", Value: bson.D{primitive.E{Key: "$in", Value: ids}}}})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteUserAlerts drops every alert of the user including history
+func DeleteUserAlerts(coll *mongo.Collection, id int64, ctx context.Context) error {
+	_, err := coll.DeleteMany(ctx, bson.D{primitive.E{Key: "user_id", Value: id}})
 	if err != nil {
 		return err
 	}
@@ -97,8 +204,11 @@ func DeleteAlerts(coll *mongo.Collection, id int64, alerts []models.Alert, ctx c
 
 func ShutdownSequence(coll *mongo.Collection, sessionAlerts map[int64]*[]models.Alert, count int, ctx context.Context) error {
 	writeModels := make([]mongo.WriteModel, 0, count)
-	for k, v := range sessionAlerts {
-		writeModels = append(writeModels, setAlertsConnected(k, *v, false)...)
+	for _, v := range sessionAlerts {
+		writeModels = append(writeModels, setAlertsConnected(*v, false)...)
+	}
+	if len(writeModels) == 0 {
+		return nil
 	}
 
 	_, err := coll.BulkWrite(ctx, writeModels)
@@ -109,26 +219,157 @@ func ShutdownSequence(coll *mongo.Collection, sessionAlerts map[int64]*[]models.
 }
 
 func UpdateAlertsSqc(coll *mongo.Collection, id int64, alerts []models.Alert, connected bool, ctx context.Context) error {
-	_, err := coll.BulkWrite(ctx, setAlertsConnected(id, alerts, connected))
+	if len(alerts) == 0 {
+		return nil
+	}
+	_, err := coll.BulkWrite(ctx, setAlertsConnected(alerts, connected))
 	if err != nil {
 		return fmt.Errorf("UpdateAlertsSqc: %s", err)
 	}
 	return nil
 }
 
-func setAlertsConnected(id int64, alerts []models.Alert, connected bool) []mongo.WriteModel {
+// SuspendAlerts marks every active alert of the user suspended and disconnected
+func SuspendAlerts(coll *mongo.Collection, id int64, ctx context.Context) error {
+	_, err := coll.UpdateMany(ctx, bson.D{
+		primitive.E{Key: "user_id", Value: id},
+		primitive.E{Key: "archived", Value: bson.D{primitive.E{Key: "$ne", Value: true}}},
+	}, bson.D{primitive.E{Key: "$set", Value: bson.D{
+		primitive.E{Key: "suspended", Value: true},
+		primitive.E{Key: "connected", Value: false},
+	}}})
+	if err != nil {
+		return fmt.Errorf("SuspendAlerts: %s", err)
+	}
+	return nil
+}
+
+// ResumeAlerts clears the suspension of the user's alerts and returns them, they stay disconnected until watched again
+func ResumeAlerts(coll *mongo.Collection, id int64, ctx context.Context) ([]models.Alert, error) {
+	filter := bson.D{
+		primitive.E{Key: "user_id", Value: id},
+		primitive.E{Key: "suspended", Value: true},
+		primitive.E{Key: "archived", Value: bson.D{primitive.E{Key: "$ne", Value: true}}},
+	}
+	var alerts []models.Alert
+	cursor, err := coll.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("ResumeAlerts: %s", err)
+	}
+	err = cursor.All(ctx, &alerts)
+	if err != nil {
+		return nil, fmt.Errorf("ResumeAlerts: %s", err)
+	}
+	if len(alerts) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]primitive.ObjectID, len(alerts))
+	for i := range alerts {
+		ids[i] = alerts[i].Id
+		alerts[i].Suspended = false
+	}
+	_, err = coll.UpdateMany(ctx, bson.D{primitive.E{Key: "_id", Value: bson.D{primitive.E{Key: "$in", Value: ids}}}},
+		bson.D{primitive.E{Key: "$unset", Value: bson.D{primitive.E{Key: "suspended", Value: ""}}}})
+	if err != nil {
+		return nil, fmt.Errorf("ResumeAlerts: %s", err)
+	}
+	return alerts, nil
+}
+
+func setAlertsConnected(alerts []models.Alert, connected bool) []mongo.WriteModel {
 	updates := make([]mongo.WriteModel, len(alerts))
 	for i, v := range alerts {
 		updates[i] = mongo.NewUpdateOneModel().SetFilter(
-			bson.D{primitive.E{Key: "_id", Value: id}, primitive.E{Key: "alerts.pair", Value: v.Pair}},
-		).SetUpdate(bson.D{primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "alerts.$.connected", Value: connected}}}})
+			bson.D{primitive.E{Key: "_id", Value: v.Id}},
+		).SetUpdate(bson.D{primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "connected", Value: connected}}}})
 	}
 	return updates
 }
 
+// EnsureAlertIndexes creates the indexes alert lookups rely on, existing indexes are left as is
+func EnsureAlertIndexes(coll *mongo.Collection, ctx context.Context) error {
+	_, err := coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{
+			primitive.E{Key: "user_id", Value: 1},
+			primitive.E{Key: "market", Value: 1},
+			primitive.E{Key: "pair", Value: 1},
+		}},
+		{Keys: bson.D{primitive.E{Key: "connected", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("EnsureAlertIndexes: %s", err)
+	}
+	return nil
+}
+
+// MigrateAlerts moves alerts and history embedded in user documents into the alerts collection.
+// Alerts are upserted by id before they're unset from the user, legacy alerts without an id get
+// one derived from where they're embedded, so an interrupted run can be repeated
+func MigrateAlerts(coll *mongo.Collection, alertColl *mongo.Collection, ctx context.Context) error {
+	cursor, err := coll.Find(ctx, bson.D{primitive.E{Key: "$or", Value: bson.A{
+		bson.D{primitive.E{Key: "alerts.0", Value: bson.D{primitive.E{Key: "$exists", Value: true}}}},
+		bson.D{primitive.E{Key: "history.0", Value: bson.D{primitive.E{Key: "$exists", Value: true}}}},
+	}}})
+	if err != nil {
+		return fmt.Errorf("MigrateAlerts: %s", err)
+	}
+	var users []models.MongoUser
+	err = cursor.All(ctx, &users)
+	if err != nil {
+		return fmt.Errorf("MigrateAlerts: %s", err)
+	}
+
+	for _, user := range users {
+		writeModels := make([]mongo.WriteModel, 0, len(user.Alerts)+len(user.History))
+		migrate := func(alert models.Alert, archived bool, index int) {
+			if alert.Id.IsZero() {
+				alert.Id = legacyAlertID(user.UsedID, alert, archived, index)
+			}
+			alert.Hex = alert.Id.Hex()
+			alert.UserID = user.UsedID
+			if archived {
+				alert.Archived = true
+				alert.Connected = false
+			}
+			writeModels = append(writeModels, mongo.NewReplaceOneModel().
+				SetFilter(bson.D{primitive.E{Key: "_id", Value: alert.Id}}).SetReplacement(alert).SetUpsert(true))
+		}
+		for i, v := range user.Alerts {
+			migrate(v, false, i)
+		}
+		for i, v := range user.History {
+			migrate(v, true, i)
+		}
+
+		_, err = alertColl.BulkWrite(ctx, writeModels)
+		if err != nil {
+			return fmt.Errorf("MigrateAlerts: %s", err)
+		}
+		_, err = coll.UpdateByID(ctx, user.UsedID, bson.D{primitive.E{Key: "$unset", Value: bson.D{
+			primitive.E{Key: "alerts", Value: ""}, primitive.E{Key: "history", Value: ""}}}})
+		if err != nil {
+			return fmt.Errorf("MigrateAlerts: %s", err)
+		}
+	}
+	return nil
+}
+
+// legacyAlertID derives an id for an alert embedded without one from the user, the alert
+// and its position in alerts or history. The user document isn't changed until its alerts
+// are migrated, so every run derives the same ids
+func legacyAlertID(userID int64, alert models.Alert, archived bool, index int) primitive.ObjectID {
+	key := fmt.Sprintf("%d:%s:%s:%s:%t:%d", userID, alert.Market, alert.Pair, alert.Hex, archived, index)
+	sum := sha256.Sum256([]byte(key))
+	var id primitive.ObjectID
+	copy(id[:], sum[:])
+	return id
+}
+
 // Random order
 func GetPairs(coll *mongo.Collection, id int64, ctx context.Context) ([]string, error) {
-	result, err := coll.Distinct(ctx, "alerts.pair", bson.D{primitive.E{Key: "_id", Value: id}})
+	result, err := coll.Distinct(ctx, "pair", bson.D{primitive.E{Key: "user_id", Value: id},
+		primitive.E{Key: "archived", Value: bson.D{primitive.E{Key: "$ne", Value: true}}}})
 	if err != nil {
 		return nil, err
 	}
@@ -136,52 +377,43 @@ func GetPairs(coll *mongo.Collection, id int64, ctx context.Context) ([]string,
 }
 
 func GetAlerts(coll *mongo.Collection, id int64, ctx context.Context) ([]models.Alert, error) {
-	var user models.MongoUser
-	err := coll.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}},
-		options.FindOne().SetProjection(bson.D{primitive.E{Key: "_id", Value: 0},
-			primitive.E{Key: "chat_id", Value: 0}, primitive.E{Key: "timestamp", Value: 0}})).Decode(&user)
+	var alerts []models.Alert
+	cursor, err := coll.Find(ctx, bson.D{primitive.E{Key: "user_id", Value: id},
+		primitive.E{Key: "archived", Value: bson.D{primitive.E{Key: "$ne", Value: true}}}})
 	if err != nil {
 		return nil, err
 	}
-	return user.Alerts, nil
+	err = cursor.All(ctx, &alerts)
+	if err != nil {
+		return nil, err
+	}
+	return alerts, nil
 }
 
 func GetPairsByMarket(coll *mongo.Collection, id int64, market string, connected bool, ctx context.Context) ([]string, []models.Alert, error) {
-	var user models.MongoUser
-	err := coll.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}).Decode(&user)
+	var alerts []models.Alert
+	cursor, err := coll.Find(ctx, bson.D{
+		primitive.E{Key: "user_id", Value: id},
+		primitive.E{Key: "market", Value: market},
+		primitive.E{Key: "connected", Value: connected},
+		primitive.E{Key: "archived", Value: bson.D{primitive.E{Key: "$ne", Value: true}}},
+	})
 	if err != nil {
 		return nil, nil, err
 	}
-	if len(user.Alerts) == 0 {
-		return nil, nil, NoPairsErr
-	}
-
-	pairs := make([]string, len(user.Alerts))
-	alerts := make([]models.Alert, len(user.Alerts))
-
-	index := 0
-	for _, v := range user.Alerts {
-		if v.Market == market && v.Connected == connected {
-			pairs[index] = v.Pair
-			alerts[index] = v
-			index++
-		}
+	err = cursor.All(ctx, &alerts)
+	if err != nil {
+		return nil, nil, err
 	}
-
-	if pairs[0] == "" {
+	if len(alerts) == 0 {
 		return nil, nil, NoPairsErr
 	}
 
-	pairs = pairs[:index]
-	alerts = alerts[:index]
-
-	fPairs := make([]string, index)
-	fAlerts := make([]models.Alert, index)
-
-	copy(fPairs, pairs)
-	copy(fAlerts, alerts)
-
-	return fPairs, fAlerts, nil
+	pairs := make([]string, len(alerts))
+	for i, v := range alerts {
+		pairs[i] = v.Pair
+	}
+	return pairs, alerts, nil
 }
 
 func splitPairs(result []interface{}) []string {
@@ -194,7 +426,8 @@ func splitPairs(result []interface{}) []string {
 }
 
 func MarketExist(coll *mongo.Collection, id int64, market string, ctx context.Context) (bool, error) {
-	result := coll.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}, primitive.E{Key: "alerts.market", Value: market}})
+	result := coll.FindOne(ctx, bson.D{primitive.E{Key: "user_id", Value: id}, primitive.E{Key: "market", Value: market},
+		primitive.E{Key: "archived", Value: bson.D{primitive.E{Key: "$ne", Value: true}}}})
 	if err := result.Err(); err != nil {
 		if err == mongo.ErrNoDocuments {
 			return false, nil
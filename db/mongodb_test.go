@@ -8,6 +8,8 @@ import (
 	"time"
 
 	db "github.com/HomelessHunter/CTC/db/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -20,8 +22,34 @@ func prepare() (client *mongo.Client, coll *mongo.Collection, err error) {
 	return
 }
 
+// insertUser stores the user and moves its alerts into the alerts collection
+func insertUser(coll *mongo.Collection, alertColl *mongo.Collection, user *db.MongoUser) error {
+	alerts := user.Alerts
+	user.Alerts = nil
+	err := InsertNewUser(coll, user, context.TODO())
+	if err != nil {
+		return err
+	}
+	for i := range alerts {
+		err = AddAlert(alertColl, user.UsedID, &alerts[i], context.TODO())
+		if err != nil {
+			return err
+		}
+	}
+	user.Alerts = alerts
+	return nil
+}
+
+func deleteUser(client *mongo.Client, coll *mongo.Collection, id int64) error {
+	err := DeleteUserByID(coll, id, context.TODO())
+	if err != nil {
+		return err
+	}
+	return DeleteUserAlerts(GetAlertCollection(client), id, context.TODO())
+}
+
 func closeTest(client *mongo.Client, coll *mongo.Collection) error {
-	err := DeleteUserByID(coll, ID, context.TODO())
+	err := deleteUser(client, coll, ID)
 	if err != nil {
 		return err
 	}
@@ -49,7 +77,7 @@ func TestInsertUser(t *testing.T) {
 	if err != nil {
 		t.Errorf("Cannot create user %s", err)
 	}
-	err = InsertNewUser(coll, user, context.TODO())
+	err = insertUser(coll, GetAlertCollection(client), user)
 	if err != nil {
 		t.Errorf("Cannot insert user %s", err)
 	}
@@ -76,14 +104,14 @@ func TestDeleteUserByID(t *testing.T) {
 	}
 	defer client.Disconnect(context.TODO())
 
-	err = DeleteUserByID(coll, ID, context.TODO())
+	err = deleteUser(client, coll, ID)
 	if err != nil {
 		t.Errorf("Cannot delete user %s", err)
 	}
 }
 
 func TestAddAlert(t *testing.T) {
-	client, coll, err := prepare()
+	client, _, err := prepare()
 	if err != nil {
 		t.Errorf("Cannot connect %s", err)
 	}
@@ -93,20 +121,20 @@ func TestAddAlert(t *testing.T) {
 	if err != nil {
 		t.Errorf("Cannot create alert %s", err)
 	}
-	err = AddAlert(coll, ID, alert, context.TODO())
+	err = AddAlert(GetAlertCollection(client), ID, alert, context.TODO())
 	if err != nil {
 		t.Errorf("Cannot add new alert %s", err)
 	}
 }
 
 func TestDisctinct(t *testing.T) {
-	client, coll, err := prepare()
+	client, _, err := prepare()
 	if err != nil {
 		t.Errorf("Cannot connect %s", err)
 	}
 	defer client.Disconnect(context.TODO())
 
-	result, err := GetPairs(coll, ID, context.TODO())
+	result, err := GetPairs(GetAlertCollection(client), ID, context.TODO())
 	if err != nil {
 		t.Errorf("Cannot get pairs %s", err)
 	}
@@ -118,7 +146,7 @@ func TestRemoveAlert(t *testing.T) {
 		t.Errorf("Cannot connect %s", err)
 	}
 	defer func() {
-		err = DeleteUserByID(coll, ID, context.TODO())
+		err = deleteUser(client, coll, ID)
 		if err != nil {
 			t.Errorf("Cannot delete user %s", err)
 		}
@@ -133,26 +161,26 @@ func TestRemoveAlert(t *testing.T) {
 	if err != nil {
 		t.Errorf("Cannot create user %s", err)
 	}
-	err = InsertNewUser(coll, user, context.TODO())
+	err = insertUser(coll, GetAlertCollection(client), user)
 	if err != nil {
 		t.Errorf("Cannot insert user %s", err)
 	}
 
-	err = RemoveAlert(coll, ID, "btcusdt", context.TODO())
+	err = RemoveAlert(GetAlertCollection(client), ID, alertHu.Id, context.TODO())
 	if err != nil {
 		t.Errorf("Cannot remove alert %s", err)
 	}
 
-	user, err = GetUserByID(coll, ID, context.TODO())
+	alerts, err := GetAlerts(GetAlertCollection(client), ID, context.TODO())
 	if err != nil {
-		t.Errorf("Cannot get a user %s", err)
+		t.Errorf("Cannot get alerts %s", err)
 	}
 
-	if len(user.Alerts) > 0 {
-		t.Errorf("lenght of alerts should be 0 but %d instead", len(user.Alerts))
+	if len(alerts) > 0 {
+		t.Errorf("lenght of alerts should be 0 but %d instead", len(alerts))
 	}
 
-	fmt.Println(user)
+	fmt.Println(alerts)
 
 }
 
@@ -162,11 +190,11 @@ func TestMarketExist(t *testing.T) {
 		t.Errorf("Cannot connect %s", err)
 	}
 	defer func() {
-		err = DeleteUserByID(coll, ID, context.TODO())
+		err = deleteUser(client, coll, ID)
 		if err != nil {
 			t.Errorf("Cannot delete user %s", err)
 		}
-		err = DeleteUserByID(coll, 2, context.TODO())
+		err = deleteUser(client, coll, 2)
 		if err != nil {
 			t.Errorf("Cannot delete user %s", err)
 		}
@@ -186,7 +214,7 @@ func TestMarketExist(t *testing.T) {
 		if err != nil {
 			t.Errorf("Cannot create user %s", err)
 		}
-		err = InsertNewUser(coll, user, context.TODO())
+		err = insertUser(coll, GetAlertCollection(client), user)
 		if err != nil {
 			t.Errorf("Cannot insert user %s", err)
 		}
@@ -195,7 +223,7 @@ func TestMarketExist(t *testing.T) {
 	insertUser(ID, "huobi")
 	insertUser(2, "binance")
 
-	exist, err := MarketExist(coll, ID, "binance", context.TODO())
+	exist, err := MarketExist(GetAlertCollection(client), ID, "binance", context.TODO())
 	if err != nil {
 		t.Errorf("Cannot check if market exist: %s", err)
 	}
@@ -203,7 +231,7 @@ func TestMarketExist(t *testing.T) {
 
 }
 
-func prepareForPairsTest(coll *mongo.Collection) error {
+func prepareForPairsTest(client *mongo.Client, coll *mongo.Collection) error {
 	alertBi, err := db.NewAlert(db.WithPair("ethbusd"), db.WithTargetPrice(54000.0), db.WithMarket("binance"), db.WithConnected(true))
 	if err != nil {
 		return fmt.Errorf("Cannot create alert %s", err)
@@ -227,7 +255,7 @@ func prepareForPairsTest(coll *mongo.Collection) error {
 	if err != nil {
 		return fmt.Errorf("Cannot create user %s", err)
 	}
-	err = InsertNewUser(coll, user, context.TODO())
+	err = insertUser(coll, GetAlertCollection(client), user)
 	if err != nil {
 		return fmt.Errorf("Cannot insert user %s", err)
 	}
@@ -241,16 +269,16 @@ func TestGetPairsByMarket(t *testing.T) {
 	}
 
 	defer func() {
-		err = DeleteUserByID(coll, ID, context.TODO())
+		err = deleteUser(client, coll, ID)
 		if err != nil {
 			t.Errorf("Cannot delete user %s", err)
 		}
 		client.Disconnect(context.TODO())
 	}()
 
-	err = prepareForPairsTest(coll)
+	err = prepareForPairsTest(client, coll)
 
-	pairs, alerts, err := GetPairsByMarket(coll, ID, "huobi", false, context.TODO())
+	pairs, alerts, err := GetPairsByMarket(GetAlertCollection(client), ID, "huobi", false, context.TODO())
 	if err != nil {
 		t.Errorf("Cannot get pairs: %s", err)
 	}
@@ -269,7 +297,7 @@ func TestUpdateAlerts(t *testing.T) {
 	}
 
 	defer func() {
-		err = DeleteUserByID(coll, 2, context.TODO())
+		err = deleteUser(client, coll, 2)
 		if err != nil {
 			t.Error(err)
 		}
@@ -284,7 +312,7 @@ func TestUpdateAlerts(t *testing.T) {
 	alertHuo, _ := db.NewAlert(db.WithPair("ethusdt"), db.WithTargetPrice(54000.0), db.WithMarket("huobi"))
 	alertBina, _ := db.NewAlert(db.WithPair("solusdt"), db.WithTargetPrice(54000.0), db.WithMarket("binance"))
 	user, _ := db.NewMongoUser(db.WithUserID(ID), db.WithAlerts(*alertBi, *alertBin, *alertHuo, *alertBina), db.WithChatID(1))
-	err = InsertNewUser(coll, user, context.TODO())
+	err = insertUser(coll, GetAlertCollection(client), user)
 	if err != nil {
 		t.Error(err)
 	}
@@ -294,56 +322,56 @@ func TestUpdateAlerts(t *testing.T) {
 	alertHuo2, _ := db.NewAlert(db.WithPair("ethusdt"), db.WithTargetPrice(4200.0), db.WithMarket("huobi"), db.WithConnected(true))
 	alertBina2, _ := db.NewAlert(db.WithPair("solusdt"), db.WithTargetPrice(540.0), db.WithMarket("binance"), db.WithConnected(true))
 	user2, _ := db.NewMongoUser(db.WithUserID(2), db.WithAlerts(*alertBi2, *alertBin2, *alertHuo2, *alertBina2), db.WithChatID(2))
-	err = InsertNewUser(coll, user2, context.TODO())
+	err = insertUser(coll, GetAlertCollection(client), user2)
 	if err != nil {
 		t.Error(err)
 	}
 	sessionAlerts := map[int64]*[]db.Alert{1: {*alertBi, *alertBin, *alertHuo, *alertBina}, 2: {*alertBi2, *alertBin2, *alertHuo2, *alertBina2}}
 
-	err = ShutdownSequence(coll, sessionAlerts, 8, context.TODO())
+	err = ShutdownSequence(GetAlertCollection(client), sessionAlerts, 8, context.TODO())
 	if err != nil {
 		t.Error(err)
 	}
 
-	firstUser, err := GetUserByID(coll, 1, context.TODO())
+	firstAlerts, err := GetAlerts(GetAlertCollection(client), 1, context.TODO())
 	if err != nil {
 		t.Error(err)
 	}
-	fmt.Println(firstUser.Alerts)
-	secondUser, err := GetUserByID(coll, 2, context.TODO())
+	fmt.Println(firstAlerts)
+	secondAlerts, err := GetAlerts(GetAlertCollection(client), 2, context.TODO())
 	if err != nil {
 		t.Error(err)
 	}
-	fmt.Println(secondUser.Alerts)
+	fmt.Println(secondAlerts)
 
-	err = UpdateAlertsSqc(coll, 1, firstUser.Alerts, true, context.TODO())
+	err = UpdateAlertsSqc(GetAlertCollection(client), 1, firstAlerts, true, context.TODO())
 	if err != nil {
 		t.Error(err)
 	}
-	firstUser, err = GetUserByID(coll, 1, context.TODO())
+	firstAlerts, err = GetAlerts(GetAlertCollection(client), 1, context.TODO())
 	if err != nil {
 		t.Error(err)
 	}
-	fmt.Println(firstUser.Alerts)
+	fmt.Println(firstAlerts)
 }
 
 func TestGetUsersWithPairs(t *testing.T) {
-	clien, coll, err := prepare()
+	client, coll, err := prepare()
 	if err != nil {
 		t.Error(err)
 	}
 	defer func() {
-		err := DeleteUserByID(coll, 2, context.TODO())
+		err := deleteUser(client, coll, 2)
 		if err != nil {
 			t.Error(err)
 		}
-		err = closeTest(clien, coll)
+		err = closeTest(client, coll)
 		if err != nil {
 			t.Error(err)
 		}
 	}()
 
-	err = prepareForPairsTest(coll)
+	err = prepareForPairsTest(client, coll)
 	if err != nil {
 		t.Error(err)
 	}
@@ -352,12 +380,12 @@ func TestGetUsersWithPairs(t *testing.T) {
 	alertHuo2, _ := db.NewAlert(db.WithPair("ethusdt"), db.WithTargetPrice(4200.0), db.WithMarket("huobi"))
 	alertBina2, _ := db.NewAlert(db.WithPair("solusdt"), db.WithTargetPrice(540.0), db.WithMarket("binance"))
 	user2, _ := db.NewMongoUser(db.WithUserID(2), db.WithAlerts(*alertBi2, *alertBin2, *alertHuo2, *alertBina2))
-	err = InsertNewUser(coll, user2, context.TODO())
+	err = insertUser(coll, GetAlertCollection(client), user2)
 	if err != nil {
 		t.Error(err)
 	}
 
-	users, err := GetUsersWithPairs(coll, false, context.TODO())
+	users, err := GetUsersWithPairs(coll, GetAlertCollection(client), false, context.TODO())
 	if err != nil {
 		t.Error(err)
 	}
@@ -370,29 +398,29 @@ func TestDeletePairs(t *testing.T) {
 		t.Error(err)
 	}
 	defer func() {
-		err = DeleteUserByID(coll, ID, context.TODO())
+		err = deleteUser(client, coll, ID)
 		if err != nil {
 			t.Errorf("Cannot delete user %s", err)
 		}
 		client.Disconnect(context.TODO())
 	}()
 
-	err = prepareForPairsTest(coll)
+	err = prepareForPairsTest(client, coll)
 	if err != nil {
 		t.Error(err)
 	}
 
-	_, alerts, err := GetPairsByMarket(coll, ID, "binance", true, context.TODO())
+	_, alerts, err := GetPairsByMarket(GetAlertCollection(client), ID, "binance", true, context.TODO())
 	if err != nil {
 		t.Errorf("Cannot get pairs: %s", err)
 	}
 	fmt.Println(alerts)
-	err = DeleteAlerts(coll, ID, alerts, context.TODO())
+	err = DeleteAlerts(GetAlertCollection(client), ID, alerts, context.TODO())
 	if err != nil {
 		t.Errorf("Cannot delete pairs: %s", err)
 	}
 
-	_, alerts, err = GetPairsByMarket(coll, ID, "binance", true, context.TODO())
+	_, alerts, err = GetPairsByMarket(GetAlertCollection(client), ID, "binance", true, context.TODO())
 	if err != nil {
 		fmt.Println(alerts)
 	} else {
@@ -406,31 +434,70 @@ func TestGetAlerts(t *testing.T) {
 		t.Error(err)
 	}
 	defer func() {
-		err = DeleteUserByID(coll, ID, context.TODO())
+		err = deleteUser(client, coll, ID)
 		if err != nil {
 			t.Errorf("Cannot delete user %s", err)
 		}
 		client.Disconnect(context.TODO())
 	}()
-	err = prepareForPairsTest(coll)
+	err = prepareForPairsTest(client, coll)
 	if err != nil {
 		t.Error(err)
 	}
 
-	alerts, err := GetAlerts(coll, 1, context.TODO())
+	alerts, err := GetAlerts(GetAlertCollection(client), 1, context.TODO())
 	if err != nil {
 		t.Error(err)
 	}
 	fmt.Println(alerts)
 }
 
+func TestSuspendAlerts(t *testing.T) {
+	client, coll, err := prepare()
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() {
+		err = deleteUser(client, coll, ID)
+		if err != nil {
+			t.Errorf("Cannot delete user %s", err)
+		}
+		client.Disconnect(context.TODO())
+	}()
+	err = prepareForPairsTest(client, coll)
+	if err != nil {
+		t.Error(err)
+	}
+	alertColl := GetAlertCollection(client)
+
+	err = SuspendAlerts(alertColl, ID, context.TODO())
+	if err != nil {
+		t.Error(err)
+	}
+	users, err := GetUsersWithPairs(coll, alertColl, true, context.TODO())
+	if err != nil {
+		t.Error(err)
+	}
+	if len(users) != 0 {
+		t.Errorf("suspended alerts shouldn't be restored: %v", users)
+	}
+
+	alerts, err := ResumeAlerts(alertColl, ID, context.TODO())
+	if err != nil {
+		t.Error(err)
+	}
+	if len(alerts) == 0 {
+		t.Error("alerts should be resumed")
+	}
+}
+
 func TestWatchForChanges(t *testing.T) {
 	client, coll, err := prepare()
 	if err != nil {
 		t.Errorf("Cannot connect %s", err)
 	}
 	defer func() {
-		err = DeleteUserByID(coll, ID, context.TODO())
+		err = deleteUser(client, coll, ID)
 		if err != nil {
 			t.Errorf("Cannot delete user %s", err)
 		}
@@ -460,7 +527,7 @@ func TestWatchForChanges(t *testing.T) {
 	if err != nil {
 		t.Errorf("Cannot create user %s", err)
 	}
-	err = InsertNewUser(coll, user, context.TODO())
+	err = insertUser(coll, GetAlertCollection(client), user)
 	if err != nil {
 		t.Errorf("Cannot insert user %s", err)
 	}
@@ -476,7 +543,7 @@ func TestWatchForChanges(t *testing.T) {
 
 	<-time.After(2 * time.Second)
 
-	err = RemoveAlert(coll, ID, "ethusdt", context.TODO())
+	err = RemoveAlert(GetAlertCollection(client), ID, alertHuo.Id, context.TODO())
 	if err != nil {
 		t.Error(err)
 	}
@@ -487,7 +554,7 @@ func TestWatchForChanges(t *testing.T) {
 	if err != nil {
 		t.Errorf("Cannot create alert %s", err)
 	}
-	err = AddAlert(coll, ID, alert, context.TODO())
+	err = AddAlert(GetAlertCollection(client), ID, alert, context.TODO())
 	if err != nil {
 		t.Errorf("Cannot add new alert %s", err)
 	}
@@ -546,3 +613,81 @@ func TestCh(t *testing.T) {
 		fmt.Println("Second close didn't come")
 	}
 }
+
+func TestSaveConversation(t *testing.T) {
+	client, _, err := prepare()
+	if err != nil {
+		t.Error(err)
+	}
+	defer client.Disconnect(context.TODO())
+	coll := GetConversationCollection(client)
+	defer DeleteConversation(coll, ID, context.TODO())
+
+	conv, _ := db.NewConversation(db.WithConvChatID(ID), db.WithConvUserID(ID))
+	for _, step := range []string{db.StepPair, db.StepCondition} {
+		conv.Next(step)
+		err = SaveConversation(coll, conv, context.TODO())
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	stored, err := GetConversation(coll, ID, context.TODO())
+	if err != nil || stored.Step != db.StepCondition {
+		t.Errorf("unexpected conversation %v (%v)", stored, err)
+	}
+}
+
+func TestMigrateAlertsTwice(t *testing.T) {
+	client, coll, err := prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeTest(client, coll)
+	alertColl := GetAlertCollection(client)
+
+	legacy := bson.D{
+		primitive.E{Key: "alerts", Value: bson.A{
+			bson.D{primitive.E{Key: "market", Value: "binance"}, primitive.E{Key: "pair", Value: "btcusdt"}, primitive.E{Key: "target_price", Value: 70000.0}},
+			bson.D{primitive.E{Key: "market", Value: "binance"}, primitive.E{Key: "pair", Value: "btcusdt"}, primitive.E{Key: "target_price", Value: 60000.0}},
+		}},
+		primitive.E{Key: "history", Value: bson.A{
+			bson.D{primitive.E{Key: "market", Value: "huobi"}, primitive.E{Key: "pair", Value: "ethusdt"}, primitive.E{Key: "target_price", Value: 3000.0}},
+		}},
+	}
+	_, err = coll.InsertOne(context.TODO(), append(bson.D{primitive.E{Key: "_id", Value: ID}, primitive.E{Key: "chat_id", Value: ID}}, legacy...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = MigrateAlerts(coll, alertColl, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a run interrupted before the user was unset finds the same embedded alerts again
+	_, err = coll.UpdateByID(context.TODO(), ID, bson.D{primitive.E{Key: "$set", Value: legacy}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = MigrateAlerts(coll, alertColl, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := alertColl.CountDocuments(context.TODO(), bson.D{primitive.E{Key: "user_id", Value: ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("a repeated migration shouldn't duplicate alerts, got %d", count)
+	}
+}
+
+func TestLegacyAlertID(t *testing.T) {
+	alert := db.Alert{Market: "binance", Pair: "btcusdt", TargetPrice: 70000}
+	if legacyAlertID(ID, alert, false, 0) != legacyAlertID(ID, alert, false, 0) {
+		t.Error("the same legacy alert should get the same id")
+	}
+	if legacyAlertID(ID, alert, false, 0) == legacyAlertID(ID, alert, false, 1) ||
+		legacyAlertID(ID, alert, false, 0) == legacyAlertID(ID, alert, true, 0) {
+		t.Error("alerts embedded at different places should get different ids")
+	}
+}
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	models "github.com/HomelessHunter/CTC/db/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrNoUser  = errors.New("user doesn't exist")
+	ErrNoAlert = errors.New("alert doesn't exist")
+	// ErrNoConversation means the chat isn't in the middle of creating an alert
+	ErrNoConversation = errors.New("conversation doesn't exist")
+)
+
+type UserStore interface {
+	InsertNewUser(user *models.MongoUser, ctx context.Context) error
+	GetUserByID(id int64, ctx context.Context) (*models.MongoUser, error)
+	DeleteUserByID(id int64, ctx context.Context) error
+	SetUserDefaults(id int64, tolerance float64, cooldown time.Duration, ctx context.Context) error
+	// GetUsersWithPairs returns users owning alerts with the given connected state, Alerts holds only those alerts
+	GetUsersWithPairs(connected bool, ctx context.Context) ([]models.MongoUser, error)
+}
+
+type AlertStore interface {
+	AddAlert(id int64, alert *models.Alert, ctx context.Context) error
+	RemoveAlert(id int64, alertID primitive.ObjectID, ctx context.Context) error
+	DeleteAlerts(id int64, alerts []models.Alert, ctx context.Context) error
+	ArchiveAlert(id int64, alert *models.Alert, ctx context.Context) error
+	// SetTargetPrice moves an active alert to a new target, its cooldown starts over
+	SetTargetPrice(id int64, alertID primitive.ObjectID, price float64, ctx context.Context) error
+	GetAlerts(id int64, ctx context.Context) ([]models.Alert, error)
+	GetHistory(id int64, ctx context.Context) ([]models.Alert, error)
+	UpdateAlertsSqc(id int64, alerts []models.Alert, connected bool, ctx context.Context) error
+	ShutdownSequence(sessionAlerts map[int64]*[]models.Alert, count int, ctx context.Context) error
+	// SuspendAlerts disconnects every active alert of a user who can't be reached anymore
+	SuspendAlerts(id int64, ctx context.Context) error
+	// ResumeAlerts lifts the suspension and returns the alerts to watch again
+	ResumeAlerts(id int64, ctx context.Context) ([]models.Alert, error)
+}
+
+// ConversationStore keeps the state of alerts created step by step, one per chat
+type ConversationStore interface {
+	GetConversation(chatID int64, ctx context.Context) (*models.Conversation, error)
+	SaveConversation(conv *models.Conversation, ctx context.Context) error
+	DeleteConversation(chatID int64, ctx context.Context) error
+}
+
+type Store interface {
+	UserStore
+	AlertStore
+	ConversationStore
+	// Init prepares the storage before the first request
+	Init(ctx context.Context) error
+}
+
+// MongoStore keeps users, alerts and conversations in their own collections
+type MongoStore struct {
+	users         *mongo.Collection
+	alerts        *mongo.Collection
+	conversations *mongo.Collection
+}
+
+func NewMongoStore(client *mongo.Client) *MongoStore {
+	return &MongoStore{
+		users:         GetUserCollection(client),
+		alerts:        GetAlertCollection(client),
+		conversations: GetConversationCollection(client),
+	}
+}
+
+func (s *MongoStore) Init(ctx context.Context) error {
+	err := EnsureAlertIndexes(s.alerts, ctx)
+	if err != nil {
+		return err
+	}
+	err = EnsureConversationIndexes(s.conversations, ctx)
+	if err != nil {
+		return err
+	}
+	// alerts used to be embedded in user documents
+	return MigrateAlerts(s.users, s.alerts, ctx)
+}
+
+func (s *MongoStore) InsertNewUser(user *models.MongoUser, ctx context.Context) error {
+	return InsertNewUser(s.users, user, ctx)
+}
+
+func (s *MongoStore) GetUserByID(id int64, ctx context.Context) (*models.MongoUser, error) {
+	user, err := GetUserByID(s.users, id, ctx)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNoUser
+	}
+	return user, err
+}
+
+func (s *MongoStore) DeleteUserByID(id int64, ctx context.Context) error {
+	err := DeleteUserAlerts(s.alerts, id, ctx)
+	if err != nil {
+		return err
+	}
+	return DeleteUserByID(s.users, id, ctx)
+}
+
+func (s *MongoStore) SetUserDefaults(id int64, tolerance float64, cooldown time.Duration, ctx context.Context) error {
+	return SetUserDefaults(s.users, id, tolerance, cooldown, ctx)
+}
+
+func (s *MongoStore) GetUsersWithPairs(connected bool, ctx context.Context) ([]models.MongoUser, error) {
+	return GetUsersWithPairs(s.users, s.alerts, connected, ctx)
+}
+
+func (s *MongoStore) AddAlert(id int64, alert *models.Alert, ctx context.Context) error {
+	return AddAlert(s.alerts, id, alert, ctx)
+}
+
+func (s *MongoStore) RemoveAlert(id int64, alertID primitive.ObjectID, ctx context.Context) error {
+	return RemoveAlert(s.alerts, id, alertID, ctx)
+}
+
+func (s *MongoStore) DeleteAlerts(id int64, alerts []models.Alert, ctx context.Context) error {
+	return DeleteAlerts(s.alerts, id, alerts, ctx)
+}
+
+func (s *MongoStore) ArchiveAlert(id int64, alert *models.Alert, ctx context.Context) error {
+	return ArchiveAlert(s.alerts, id, alert, ctx)
+}
+
+func (s *MongoStore) SetTargetPrice(id int64, alertID primitive.ObjectID, price float64, ctx context.Context) error {
+	err := SetTargetPrice(s.alerts, id, alertID, price, ctx)
+	if err == mongo.ErrNoDocuments {
+		return ErrNoAlert
+	}
+	return err
+}
+
+func (s *MongoStore) GetAlerts(id int64, ctx context.Context) ([]models.Alert, error) {
+	return GetAlerts(s.alerts, id, ctx)
+}
+
+func (s *MongoStore) GetHistory(id int64, ctx context.Context) ([]models.Alert, error) {
+	return GetHistory(s.alerts, id, ctx)
+}
+
+func (s *MongoStore) UpdateAlertsSqc(id int64, alerts []models.Alert, connected bool, ctx context.Context) error {
+	return UpdateAlertsSqc(s.alerts, id, alerts, connected, ctx)
+}
+
+func (s *MongoStore) ShutdownSequence(sessionAlerts map[int64]*[]models.Alert, count int, ctx context.Context) error {
+	return ShutdownSequence(s.alerts, sessionAlerts, count, ctx)
+}
+
+func (s *MongoStore) SuspendAlerts(id int64, ctx context.Context) error {
+	return SuspendAlerts(s.alerts, id, ctx)
+}
+
+func (s *MongoStore) ResumeAlerts(id int64, ctx context.Context) ([]models.Alert, error) {
+	return ResumeAlerts(s.alerts, id, ctx)
+}
+
+func (s *MongoStore) GetConversation(chatID int64, ctx context.Context) (*models.Conversation, error) {
+	conv, err := GetConversation(s.conversations, chatID, ctx)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNoConversation
+	}
+	return conv, err
+}
+
+func (s *MongoStore) SaveConversation(conv *models.Conversation, ctx context.Context) error {
+	return SaveConversation(s.conversations, conv, ctx)
+}
+
+func (s *MongoStore) DeleteConversation(chatID int64, ctx context.Context) error {
+	return DeleteConversation(s.conversations, chatID, ctx)
+}
+
+var (
+	_ Store = (*MongoStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
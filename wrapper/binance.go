@@ -0,0 +1,200 @@
+package wrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	cryptoMarkets "github.com/HomelessHunter/CTC/wrapper/models/cryptoMarkets"
+	"github.com/gorilla/websocket"
+)
+
+const Binance string = "binance"
+
+type binance struct{}
+
+func (binance) Name() string {
+	return Binance
+}
+
+func (binance) Decal() string {
+	return "&#128310;"
+}
+
+func (binance) Connect(dialer *websocket.Dialer, pairs []string) (*websocket.Conn, error) {
+	return ConnectBinance(dialer, pairs)
+}
+
+func (binance) Subscribe(conn *websocket.Conn, pair string) error {
+	return SubscribeBi(conn, pair)
+}
+
+func (binance) Unsubscribe(conn *websocket.Conn, pair string) error {
+	return UnsubBi(conn, pair)
+}
+
+func (binance) Decode(data []byte) (*cryptoMarkets.Tick, []byte, error) {
+	ticker := cryptoMarkets.NewTickerBi()
+	err := json.Unmarshal(data, ticker)
+	if err != nil {
+		return nil, nil, fmt.Errorf("binance decode: %s", err)
+	}
+	// subscription acks come without stream data
+	symbol := ticker.GetSymbol()
+	if symbol.IsZero() {
+		return nil, nil, nil
+	}
+	lastPrice, err := ticker.GetLastPrice()
+	if err != nil {
+		return nil, nil, fmt.Errorf("binance decode: %s", err)
+	}
+	tick := cryptoMarkets.NewTick(Binance, symbol.String(), lastPrice)
+	// the 24h change is optional, change alerts fall back to their own window without it
+	tick.Change24h, _ = ticker.GetChange24h()
+	return tick, nil, nil
+}
+
+// Binance drops stream connections after 24 hours, hubs renew them a bit earlier
+func (binance) MaxConnAge() time.Duration {
+	return 23 * time.Hour
+}
+
+// a connection listens to 1024 streams at most
+func (binance) MaxStreams() int {
+	return 1024
+}
+
+func (binance) LatestPrice(client *http.Client, pair string) (float64, error) {
+	latestPrice, err := LatestPriceBi(pair, client)
+	if err != nil {
+		return 0, err
+	}
+	if latestPrice.Msg != "" {
+		return 0, fmt.Errorf("binance: %s", latestPrice.Msg)
+	}
+	return latestPrice.GetLastPrice()
+}
+
+func (b binance) PairExist(client *http.Client, pair string) bool {
+	_, err := b.LatestPrice(client, pair)
+	return err == nil
+}
+
+func (binance) Symbols(client *http.Client) ([]cryptoMarkets.SymbolInfo, error) {
+	info, err := ExchangeInfoBi(client)
+	if err != nil {
+		return nil, err
+	}
+	symbols := make([]cryptoMarkets.SymbolInfo, 0, len(info.Symbols))
+	for _, v := range info.Symbols {
+		symbols = append(symbols, cryptoMarkets.SymbolInfo{
+			Market:   Binance,
+			Symbol:   strings.ToLower(v.Symbol),
+			Base:     strings.ToLower(v.BaseAsset),
+			Quote:    strings.ToLower(v.QuoteAsset),
+			TickSize: tickSizeBi(v.Filters),
+			Status:   v.Status,
+			Trading:  v.Status == "TRADING",
+		})
+	}
+	return symbols, nil
+}
+
+func tickSizeBi(filters []cryptoMarkets.FilterBi) float64 {
+	for _, v := range filters {
+		if v.FilterType != "PRICE_FILTER" {
+			continue
+		}
+		tickSize, err := strconv.ParseFloat(v.TickSize, 64)
+		if err != nil {
+			return 0
+		}
+		return tickSize
+	}
+	return 0
+}
+
+func ConnectBinance(dialer *websocket.Dialer, pairs []string) (*websocket.Conn, error) {
+	return connectBi(dialer, "wss://stream.binance.com:9443/stream", parsePairsBi(pairs))
+}
+
+func SubscribeBi(conn *websocket.Conn, pair string) error {
+	return conn.WriteJSON(subscriptionBi("SUBSCRIBE", []string{parsePairBi(pair)}))
+}
+
+func UnsubBi(conn *websocket.Conn, pair string) error {
+	return conn.WriteJSON(subscriptionBi("UNSUBSCRIBE", []string{parsePairBi(pair)}))
+}
+
+// connectBi dials a Binance combined stream endpoint, spot and futures speak the same protocol
+func connectBi(dialer *websocket.Dialer, endpoint string, streams []string) (*websocket.Conn, error) {
+	conn, resp, err := dialer.Dial(endpoint, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Binance_Dialer_ERR: %s", err)
+		return nil, dialErr(resp, err)
+	}
+
+	err = conn.WriteJSON(subscriptionBi("SUBSCRIBE", streams))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Binance_WriteJSON: %s", err)
+		return nil, err
+	}
+	return conn, nil
+}
+
+func subscriptionBi(method string, streams []string) map[string]interface{} {
+	return map[string]interface{}{
+		"method": method,
+		"params": streams,
+		"id":     0,
+	}
+}
+
+func parsePairsBi(pairs []string) []string {
+	for i, v := range pairs {
+		pairs[i] = parsePairBi(v)
+	}
+	return pairs
+}
+
+func parsePairBi(pair string) string {
+	return fmt.Sprintf("%s@ticker", strings.ToLower(encodeSymbolBi(DefaultCatalog.ParseSymbol(pair))))
+}
+
+// encodeSymbolBi spells symbol the way Binance's REST API does, e.g. "BTCUSDT",
+// streams take it lower-cased
+func encodeSymbolBi(symbol cryptoMarkets.Symbol) string {
+	return strings.ToUpper(symbol.Base + symbol.Quote)
+}
+
+func LatestPriceBi(pair string, client *http.Client) (*cryptoMarkets.LatestTickerBi, error) {
+	data, err := getData(client, fmt.Sprintf("https://api.binance.com/api/v3/ticker/24hr?symbol=%s", encodeSymbolBi(DefaultCatalog.ParseSymbol(pair))))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "LatestPriceBi: %s", err)
+		return nil, err
+	}
+	latestPrice := &cryptoMarkets.LatestTickerBi{}
+	err = json.Unmarshal(data, latestPrice)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "LatestPriceBi: %s", err)
+		return nil, err
+	}
+	return latestPrice, nil
+}
+
+func ExchangeInfoBi(client *http.Client) (*cryptoMarkets.ExchangeInfoBi, error) {
+	data, err := getData(client, "https://api.binance.com/api/v3/exchangeInfo")
+	if err != nil {
+		return nil, fmt.Errorf("ExchangeInfoBi: %s", err)
+	}
+	info := &cryptoMarkets.ExchangeInfoBi{}
+	err = json.Unmarshal(data, info)
+	if err != nil {
+		return nil, fmt.Errorf("ExchangeInfoBi: %s", err)
+	}
+	return info, nil
+}
@@ -0,0 +1,170 @@
+package wrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	cryptoMarkets "github.com/HomelessHunter/CTC/wrapper/models/cryptoMarkets"
+	"github.com/gorilla/websocket"
+)
+
+// BinanceFutures is Binance's USDⓈ-M perpetuals market. It watches the mark price,
+// which is what liquidations use, and carries the funding rate along with it
+const BinanceFutures string = "binance-futures"
+
+type binanceFutures struct{}
+
+func (binanceFutures) Name() string {
+	return BinanceFutures
+}
+
+func (binanceFutures) Decal() string {
+	return "&#128310;"
+}
+
+func (binanceFutures) Derivative() bool {
+	return true
+}
+
+func (binanceFutures) Connect(dialer *websocket.Dialer, pairs []string) (*websocket.Conn, error) {
+	return ConnectBf(dialer, pairs)
+}
+
+func (binanceFutures) Subscribe(conn *websocket.Conn, pair string) error {
+	return SubscribeBf(conn, pair)
+}
+
+func (binanceFutures) Unsubscribe(conn *websocket.Conn, pair string) error {
+	return UnsubBf(conn, pair)
+}
+
+func (binanceFutures) Decode(data []byte) (*cryptoMarkets.Tick, []byte, error) {
+	ticker := &cryptoMarkets.TickerBf{}
+	err := json.Unmarshal(data, ticker)
+	if err != nil {
+		return nil, nil, fmt.Errorf("binance futures decode: %s", err)
+	}
+	// subscription acks come without stream data
+	symbol := ticker.GetSymbol()
+	if symbol.IsZero() {
+		return nil, nil, nil
+	}
+	markPrice, err := ticker.Data.GetMarkPrice()
+	if err != nil {
+		return nil, nil, fmt.Errorf("binance futures decode: %s", err)
+	}
+	fundingRate, err := ticker.Data.GetFundingRate()
+	if err != nil {
+		return nil, nil, fmt.Errorf("binance futures decode: %s", err)
+	}
+	tick := cryptoMarkets.NewTick(BinanceFutures, symbol.String(), markPrice)
+	tick.FundingRate = fundingRate
+	return tick, nil, nil
+}
+
+// futures streams are dropped after 24 hours like spot ones
+func (binanceFutures) MaxConnAge() time.Duration {
+	return 23 * time.Hour
+}
+
+// and they're capped at 1024 per connection too
+func (binanceFutures) MaxStreams() int {
+	return 1024
+}
+
+func (binanceFutures) LatestPrice(client *http.Client, pair string) (float64, error) {
+	index, err := PremiumIndexBf(pair, client)
+	if err != nil {
+		return 0, err
+	}
+	if index.Msg != "" {
+		return 0, fmt.Errorf("binance futures: %s", index.Msg)
+	}
+	return index.GetMarkPrice()
+}
+
+func (b binanceFutures) PairExist(client *http.Client, pair string) bool {
+	_, err := b.LatestPrice(client, pair)
+	return err == nil
+}
+
+// Symbols lists perpetual contracts only, quarterly ones expire and have no funding
+func (binanceFutures) Symbols(client *http.Client) ([]cryptoMarkets.SymbolInfo, error) {
+	info, err := ExchangeInfoBf(client)
+	if err != nil {
+		return nil, err
+	}
+	symbols := make([]cryptoMarkets.SymbolInfo, 0, len(info.Symbols))
+	for _, v := range info.Symbols {
+		if v.ContractType != "PERPETUAL" {
+			continue
+		}
+		symbols = append(symbols, cryptoMarkets.SymbolInfo{
+			Market:   BinanceFutures,
+			Symbol:   strings.ToLower(v.Symbol),
+			Base:     strings.ToLower(v.BaseAsset),
+			Quote:    strings.ToLower(v.QuoteAsset),
+			TickSize: tickSizeBi(v.Filters),
+			Status:   v.Status,
+			Trading:  v.Status == "TRADING",
+		})
+	}
+	return symbols, nil
+}
+
+func ConnectBf(dialer *websocket.Dialer, pairs []string) (*websocket.Conn, error) {
+	return connectBi(dialer, "wss://fstream.binance.com/stream", parsePairsBf(pairs))
+}
+
+func SubscribeBf(conn *websocket.Conn, pair string) error {
+	return conn.WriteJSON(subscriptionBi("SUBSCRIBE", []string{parsePairBf(pair)}))
+}
+
+func UnsubBf(conn *websocket.Conn, pair string) error {
+	return conn.WriteJSON(subscriptionBi("UNSUBSCRIBE", []string{parsePairBf(pair)}))
+}
+
+func parsePairsBf(pairs []string) []string {
+	streams := make([]string, len(pairs))
+	for i, v := range pairs {
+		streams[i] = parsePairBf(v)
+	}
+	return streams
+}
+
+// parsePairBf names the mark price stream, "btcusdt@markPrice@1s" updates every second instead of every three
+func parsePairBf(pair string) string {
+	return fmt.Sprintf("%s@markPrice@1s", strings.ToLower(encodeSymbolBi(DefaultCatalog.ParseSymbol(pair))))
+}
+
+func PremiumIndexBf(pair string, client *http.Client) (*cryptoMarkets.PremiumIndexBf, error) {
+	data, err := getData(client, fmt.Sprintf("https://fapi.binance.com/fapi/v1/premiumIndex?symbol=%s", encodeSymbolBi(DefaultCatalog.ParseSymbol(pair))))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "PremiumIndexBf: %s", err)
+		return nil, err
+	}
+	index := &cryptoMarkets.PremiumIndexBf{}
+	err = json.Unmarshal(data, index)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "PremiumIndexBf: %s", err)
+		return nil, err
+	}
+	return index, nil
+}
+
+func ExchangeInfoBf(client *http.Client) (*cryptoMarkets.ExchangeInfoBf, error) {
+	data, err := getData(client, "https://fapi.binance.com/fapi/v1/exchangeInfo")
+	if err != nil {
+		return nil, fmt.Errorf("ExchangeInfoBf: %s", err)
+	}
+	info := &cryptoMarkets.ExchangeInfoBf{}
+	err = json.Unmarshal(data, info)
+	if err != nil {
+		return nil, fmt.Errorf("ExchangeInfoBf: %s", err)
+	}
+	return info, nil
+}
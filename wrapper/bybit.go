@@ -0,0 +1,224 @@
+package wrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	cryptoMarkets "github.com/HomelessHunter/CTC/wrapper/models/cryptoMarkets"
+	"github.com/gorilla/websocket"
+)
+
+// Bybit spot pairs and linear perpetuals are separate markets, perpetuals are pinned as "bybit-perp:btcusdt"
+const (
+	Bybit     string = "bybit"
+	BybitPerp string = "bybit-perp"
+)
+
+type bybit struct {
+	name string
+	// category is Bybit's product category, spot or linear
+	category string
+}
+
+func (b bybit) Name() string {
+	return b.name
+}
+
+func (bybit) Decal() string {
+	return "&#128992;"
+}
+
+func (b bybit) Derivative() bool {
+	return b.category == "linear"
+}
+
+// Bybit drops connections that don't ping every 20 seconds or so
+func (bybit) PingInterval() time.Duration {
+	return 20 * time.Second
+}
+
+func (bybit) Ping() []byte {
+	return []byte(`{"op":"ping"}`)
+}
+
+func (b bybit) Connect(dialer *websocket.Dialer, pairs []string) (*websocket.Conn, error) {
+	return ConnectBybit(dialer, pairs, b.category)
+}
+
+func (bybit) Subscribe(conn *websocket.Conn, pair string) error {
+	return SubscribeBybit(conn, pair)
+}
+
+func (bybit) Unsubscribe(conn *websocket.Conn, pair string) error {
+	return UnsubBybit(conn, pair)
+}
+
+func (b bybit) Decode(data []byte) (*cryptoMarkets.Tick, []byte, error) {
+	ticker := &cryptoMarkets.TickerBybit{}
+	err := json.Unmarshal(data, ticker)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bybit decode: %s", err)
+	}
+	if ticker.Op != "" {
+		if ticker.Success != nil && !*ticker.Success {
+			return nil, nil, fmt.Errorf("bybit %s: %s", ticker.Op, ticker.RetMsg)
+		}
+		return nil, nil, nil
+	}
+	// perpetual deltas only carry what changed
+	if !strings.HasPrefix(ticker.Topic, "tickers.") || ticker.Data.LastPrice == "" {
+		return nil, nil, nil
+	}
+	lastPrice, err := ticker.Data.GetLastPrice()
+	if err != nil {
+		return nil, nil, fmt.Errorf("bybit decode: %s", err)
+	}
+	symbol := decodeSymbolBybit(strings.TrimPrefix(ticker.Topic, "tickers."))
+	return cryptoMarkets.NewTick(b.name, symbol.String(), lastPrice), nil, nil
+}
+
+func (b bybit) LatestPrice(client *http.Client, pair string) (float64, error) {
+	latestPrice, err := LatestPriceBybit(pair, b.category, client)
+	if err != nil {
+		return 0, err
+	}
+	if latestPrice.RetCode != 0 {
+		return 0, fmt.Errorf("bybit: %s", latestPrice.RetMsg)
+	}
+	if len(latestPrice.Result.List) == 0 {
+		return 0, fmt.Errorf("bybit: no data on this pair: %s", pair)
+	}
+	return latestPrice.Result.List[0].GetLastPrice()
+}
+
+func (b bybit) PairExist(client *http.Client, pair string) bool {
+	_, err := b.LatestPrice(client, pair)
+	return err == nil
+}
+
+func (b bybit) Symbols(client *http.Client) ([]cryptoMarkets.SymbolInfo, error) {
+	instruments, err := InstrumentsBybit(b.category, client)
+	if err != nil {
+		return nil, err
+	}
+	symbols := make([]cryptoMarkets.SymbolInfo, 0, len(instruments))
+	for _, v := range instruments {
+		// dated futures share the linear category with perpetuals
+		if b.Derivative() && v.ContractType != "LinearPerpetual" {
+			continue
+		}
+		symbol := cryptoMarkets.NewSymbol(v.BaseCoin, v.QuoteCoin)
+		tickSize, _ := strconv.ParseFloat(v.PriceFilter.TickSize, 64)
+		symbols = append(symbols, cryptoMarkets.SymbolInfo{
+			Market:   b.name,
+			Symbol:   symbol.String(),
+			Base:     symbol.Base,
+			Quote:    symbol.Quote,
+			TickSize: tickSize,
+			Status:   v.Status,
+			Trading:  v.Status == "Trading",
+		})
+	}
+	return symbols, nil
+}
+
+func ConnectBybit(dialer *websocket.Dialer, pairs []string, category string) (*websocket.Conn, error) {
+	conn, resp, err := dialer.Dial(fmt.Sprintf("wss://stream.bybit.com/v5/public/%s", category), nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Bybit_Dialer_ERR: %s", err)
+		return nil, dialErr(resp, err)
+	}
+	// spot takes at most 10 topics per request
+	for i := 0; i < len(pairs); i += 10 {
+		end := i + 10
+		if end > len(pairs) {
+			end = len(pairs)
+		}
+		err = conn.WriteJSON(subscriptionBybit("subscribe", pairs[i:end]))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Bybit_WriteJSON: %s", err)
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func SubscribeBybit(conn *websocket.Conn, pair string) error {
+	return conn.WriteJSON(subscriptionBybit("subscribe", []string{pair}))
+}
+
+func UnsubBybit(conn *websocket.Conn, pair string) error {
+	return conn.WriteJSON(subscriptionBybit("unsubscribe", []string{pair}))
+}
+
+func subscriptionBybit(op string, pairs []string) map[string]interface{} {
+	topics := make([]string, len(pairs))
+	for i, v := range pairs {
+		topics[i] = parsePairBybit(v)
+	}
+	return map[string]interface{}{"op": op, "args": topics}
+}
+
+func parsePairBybit(pair string) string {
+	return fmt.Sprintf("tickers.%s", encodeSymbolBybit(DefaultCatalog.ParseSymbol(pair)))
+}
+
+// encodeSymbolBybit spells symbol the way Bybit does for both categories, e.g. "BTCUSDT"
+func encodeSymbolBybit(symbol cryptoMarkets.Symbol) string {
+	return strings.ToUpper(symbol.Base + symbol.Quote)
+}
+
+func decodeSymbolBybit(symbol string) cryptoMarkets.Symbol {
+	return DefaultCatalog.ParseSymbol(symbol)
+}
+
+func LatestPriceBybit(pair string, category string, client *http.Client) (*cryptoMarkets.LatestTickerBybit, error) {
+	query := url.Values{"category": {category}, "symbol": {encodeSymbolBybit(DefaultCatalog.ParseSymbol(pair))}}
+	data, err := getData(client, "https://api.bybit.com/v5/market/tickers?"+query.Encode())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "LatestPriceBybit: %s", err)
+		return nil, err
+	}
+	latestPrice := &cryptoMarkets.LatestTickerBybit{}
+	err = json.Unmarshal(data, latestPrice)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "LatestPriceBybit: %s", err)
+		return nil, err
+	}
+	return latestPrice, nil
+}
+
+// InstrumentsBybit reads every page of a category
+func InstrumentsBybit(category string, client *http.Client) ([]cryptoMarkets.InstrumentBybit, error) {
+	var instruments []cryptoMarkets.InstrumentBybit
+	cursor := ""
+	for {
+		query := url.Values{"category": {category}, "limit": {"1000"}}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		data, err := getData(client, "https://api.bybit.com/v5/market/instruments-info?"+query.Encode())
+		if err != nil {
+			return nil, fmt.Errorf("InstrumentsBybit: %s", err)
+		}
+		page := &cryptoMarkets.InstrumentsBybit{}
+		err = json.Unmarshal(data, page)
+		if err != nil {
+			return nil, fmt.Errorf("InstrumentsBybit: %s", err)
+		}
+		if page.RetCode != 0 {
+			return nil, fmt.Errorf("InstrumentsBybit: %s", page.RetMsg)
+		}
+		instruments = append(instruments, page.Result.List...)
+		cursor = page.Result.NextPageCursor
+		if cursor == "" {
+			return instruments, nil
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package wrapper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cryptoMarkets "github.com/HomelessHunter/CTC/wrapper/models/cryptoMarkets"
+)
+
+// DefaultCatalog is the catalog suggestions are taken from, main keeps it fresh with Run
+var DefaultCatalog = NewCatalog()
+
+// quoteRank orders suggestions that are equally close, the most traded quotes first
+var quoteRank = map[string]int{"usdt": 0, "usdc": 1, "busd": 2, "btc": 3, "eth": 4}
+
+// Catalog caches the pairs listed on every exchange implementing SymbolLister,
+// markets are resolved from it instead of asking every exchange over REST
+type Catalog struct {
+	mu      sync.RWMutex
+	symbols map[string][]cryptoMarkets.SymbolInfo
+	// index maps a market to its pairs by symbol
+	index map[string]map[string]cryptoMarkets.SymbolInfo
+}
+
+func NewCatalog() *Catalog {
+	return &Catalog{
+		symbols: make(map[string][]cryptoMarkets.SymbolInfo),
+		index:   make(map[string]map[string]cryptoMarkets.SymbolInfo),
+	}
+}
+
+// Set replaces the pairs of a market
+func (c *Catalog) Set(market string, symbols []cryptoMarkets.SymbolInfo) {
+	index := make(map[string]cryptoMarkets.SymbolInfo, len(symbols))
+	for _, v := range symbols {
+		index[v.Symbol] = v
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.symbols[market] = symbols
+	c.index[market] = index
+}
+
+// Loaded reports whether the pairs of market were fetched at least once
+func (c *Catalog) Loaded(market string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.index[market]
+	return ok
+}
+
+// Lookup finds symbol among the pairs of market, halted pairs included
+func (c *Catalog) Lookup(market string, symbol string) (cryptoMarkets.SymbolInfo, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	info, ok := c.index[market][symbol]
+	return info, ok
+}
+
+// Refresh reloads every listing market, a market that fails keeps its previous pairs
+func (c *Catalog) Refresh(client *http.Client) error {
+	var failed []string
+	for _, exchange := range Exchanges() {
+		lister, ok := exchange.(SymbolLister)
+		if !ok {
+			continue
+		}
+		symbols, err := lister.Symbols(client)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Catalog: %s\n", err)
+			failed = append(failed, exchange.Name())
+			continue
+		}
+		c.Set(exchange.Name(), symbols)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Catalog: cannot refresh %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// Run refreshes the catalog right away and then every interval until ctx is done
+func (c *Catalog) Run(ctx context.Context, client *http.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.Refresh(client)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ParseSymbol reads a pair typed as "BTC/USDT", "btc-usdt" or "btcusdt". A listed pair
+// is split where its market splits it, anything else by its quote suffix
+func (c *Catalog) ParseSymbol(input string) cryptoMarkets.Symbol {
+	key := normalizePair(input)
+	if key != strings.ToLower(strings.TrimSpace(input)) {
+		return cryptoMarkets.ParseSymbol(input)
+	}
+	for _, exchange := range Exchanges() {
+		if info, ok := c.Lookup(exchange.Name(), key); ok {
+			return info.Pair()
+		}
+	}
+	return cryptoMarkets.ParseSymbol(key)
+}
+
+// DisplayPair shows a stored pair the same way whatever market it's on, e.g. "BTC/USDT"
+func DisplayPair(pair string) string {
+	return DefaultCatalog.ParseSymbol(pair).Display()
+}
+
+// Suggest returns up to max listed pairs close to input, the closest first.
+// Typos are measured by edit distance, a lone base ("eth") or a swapped pair ("usdteth")
+// are matched by splitting listed pairs into base and quote
+func (c *Catalog) Suggest(input string, max int) []cryptoMarkets.SymbolInfo {
+	input = normalizePair(input)
+	if input == "" {
+		return nil
+	}
+	limit := 1
+	if len(input) >= 6 {
+		limit = 2
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	type candidate struct {
+		info  cryptoMarkets.SymbolInfo
+		score int
+	}
+	best := make(map[string]candidate)
+	for _, market := range spotFirst(Exchanges()) {
+		for _, v := range c.symbols[market.Name()] {
+			if !v.Trading {
+				continue
+			}
+			score := editDistance(input, v.Symbol)
+			if input == v.Base || input == v.Quote+v.Base {
+				score = 1
+			}
+			if score > limit {
+				continue
+			}
+			// the first market listing a pair wins, like getMarket
+			if prev, ok := best[v.Symbol]; !ok || score < prev.score {
+				best[v.Symbol] = candidate{info: v, score: score}
+			}
+		}
+	}
+
+	candidates := make([]candidate, 0, len(best))
+	for _, v := range best {
+		candidates = append(candidates, v)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.score != b.score {
+			return a.score < b.score
+		}
+		if rankQuote(a.info.Quote) != rankQuote(b.info.Quote) {
+			return rankQuote(a.info.Quote) < rankQuote(b.info.Quote)
+		}
+		return a.info.Symbol < b.info.Symbol
+	})
+	if len(candidates) > max {
+		candidates = candidates[:max]
+	}
+	suggestions := make([]cryptoMarkets.SymbolInfo, len(candidates))
+	for i, v := range candidates {
+		suggestions[i] = v.info
+	}
+	return suggestions
+}
+
+func rankQuote(quote string) int {
+	if rank, ok := quoteRank[quote]; ok {
+		return rank
+	}
+	return len(quoteRank)
+}
+
+// normalizePair lowercases input and drops separators, so "ETH/USDT" and "eth-usdt" read as "ethusdt"
+func normalizePair(input string) string {
+	return strings.NewReplacer("/", "", "-", "", "_", "", " ", "").Replace(strings.ToLower(input))
+}
+
+// editDistance counts insertions, deletions, substitutions and swaps of adjacent letters
+// needed to turn a into b, a swap is what most typos are ("ehtbusd")
+func editDistance(a, b string) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = minInt(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	min := values[0]
+	for _, v := range values[1:] {
+		if v < min {
+			min = v
+		}
+	}
+	return min
+}
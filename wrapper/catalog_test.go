@@ -0,0 +1,179 @@
+package wrapper
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	cryptoMarkets "github.com/HomelessHunter/CTC/wrapper/models/cryptoMarkets"
+	telegram "github.com/HomelessHunter/CTC/wrapper/models/telegram"
+	"github.com/HomelessHunter/CTC/wrapper/models/telegram/telegramtest"
+)
+
+func newTestCatalog() *Catalog {
+	catalog := NewCatalog()
+	symbol := func(market, base, quote string) cryptoMarkets.SymbolInfo {
+		return cryptoMarkets.SymbolInfo{Market: market, Symbol: base + quote, Base: base, Quote: quote, Trading: true}
+	}
+	halted := symbol(Binance, "luna", "usdt")
+	halted.Status, halted.Trading = "BREAK", false
+	catalog.Set(Binance, []cryptoMarkets.SymbolInfo{
+		symbol(Binance, "eth", "usdt"), symbol(Binance, "eth", "busd"), symbol(Binance, "eth", "btc"),
+		symbol(Binance, "btc", "usdt"), symbol(Binance, "sol", "usdt"), halted,
+	})
+	catalog.Set(Huobi, []cryptoMarkets.SymbolInfo{symbol(Huobi, "eth", "usdt"), symbol(Huobi, "ht", "usdt")})
+	catalog.Set(Kraken, []cryptoMarkets.SymbolInfo{symbol(Kraken, "btc", "eur"), symbol(Kraken, "eth", "usdt")})
+	return catalog
+}
+
+func suggestedSymbols(suggestions []cryptoMarkets.SymbolInfo) string {
+	symbols := make([]string, len(suggestions))
+	for i, v := range suggestions {
+		symbols[i] = v.Symbol
+	}
+	return strings.Join(symbols, " ")
+}
+
+func TestCatalogSuggest(t *testing.T) {
+	catalog := newTestCatalog()
+	cases := map[string]string{
+		"ehtbusd":  "ethbusd",
+		"ETH/USDT": "ethusdt btcusdt htusdt",
+		"eth":      "ethusdt ethbusd ethbtc",
+		"usdteth":  "ethusdt",
+		"btcusd":   "btcusdt",
+		"xyzxyz":   "",
+		"lunusdt":  "",
+	}
+	for input, want := range cases {
+		if got := suggestedSymbols(catalog.Suggest(input, 3)); got != want {
+			t.Errorf("Suggest(%q) = %q, want %q", input, got, want)
+		}
+	}
+	// a pair listed on several markets is suggested once, from the first registered market
+	if suggestions := catalog.Suggest("ethusdt", 3); suggestions[0].Market != Huobi {
+		t.Errorf("unexpected market %s", suggestions[0].Market)
+	}
+}
+
+func TestGetMarket(t *testing.T) {
+	saved := DefaultCatalog
+	DefaultCatalog = newTestCatalog()
+	defer func() { DefaultCatalog = saved }()
+	DefaultCatalog.Set(Coinbase, []cryptoMarkets.SymbolInfo{{Market: Coinbase, Symbol: "btcusd", Base: "btc", Quote: "usd", Trading: true}})
+	DefaultCatalog.Set(BybitPerp, []cryptoMarkets.SymbolInfo{
+		{Market: BybitPerp, Symbol: "btcusdt", Base: "btc", Quote: "usdt", Trading: true},
+		{Market: BybitPerp, Symbol: "wifusdt", Base: "wif", Quote: "usdt", Trading: true},
+	})
+	// the rest of the markets list nothing, so nothing goes over the network
+	for _, exchange := range Exchanges() {
+		if !DefaultCatalog.Loaded(exchange.Name()) {
+			DefaultCatalog.Set(exchange.Name(), nil)
+		}
+	}
+
+	cases := []struct {
+		input  string
+		market string
+		pair   string
+		err    error
+	}{
+		{"btcusdt", Binance, "btcusdt", nil},
+		{"htusdt", Huobi, "htusdt", nil},
+		{"huobi:ethusdt", Huobi, "ethusdt", nil},
+		{"Binance:ETHUSDT", Binance, "ethusdt", nil},
+		{"huobi:btcusdt", "", "btcusdt", ErrNoPair},
+		{"nasdaq:btcusdt", "", "btcusdt", ErrNoMarket},
+		{"lunausdt", "", "lunausdt", ErrHalted},
+		{"btceur", Kraken, "btceur", nil},
+		{"BTC-USD", Coinbase, "btcusd", nil},
+		// spot markets go first, perpetuals are found when no spot market trades the pair
+		{"btcusdt", Binance, "btcusdt", nil},
+		{"wifusdt", BybitPerp, "wifusdt", nil},
+		{"bybit-perp:btcusdt", BybitPerp, "btcusdt", nil},
+		{"kraken:XBT/EUR", Kraken, "btceur", nil},
+	}
+	for _, v := range cases {
+		market, pair, err := getMarket(v.input, nil)
+		if !errors.Is(err, v.err) || market != v.market || pair != v.pair {
+			t.Errorf("getMarket(%q) = %q %q %v", v.input, market, pair, err)
+		}
+	}
+}
+
+func TestParseSymbol(t *testing.T) {
+	catalog := newTestCatalog()
+	catalog.Set(Huobi, []cryptoMarkets.SymbolInfo{{Market: Huobi, Symbol: "abcusdq", Base: "abc", Quote: "usdq", Trading: true}})
+	cases := map[string]cryptoMarkets.Symbol{
+		"BTC/USDT": {Base: "btc", Quote: "usdt"},
+		"btc-usdt": {Base: "btc", Quote: "usdt"},
+		"btcusdt":  {Base: "btc", Quote: "usdt"},
+		"ETHBTC":   {Base: "eth", Quote: "btc"},
+		"btcfdusd": {Base: "btc", Quote: "fdusd"},
+		// the catalog knows quotes the suffixes don't
+		"abcusdq": {Base: "abc", Quote: "usdq"},
+		"xyzqqq":  {Base: "xyzqqq"},
+	}
+	for input, want := range cases {
+		if got := catalog.ParseSymbol(input); got != want {
+			t.Errorf("ParseSymbol(%q) = %+v, want %+v", input, got, want)
+		}
+	}
+	symbol := catalog.ParseSymbol("btc_usdt")
+	if symbol.String() != "btcusdt" || symbol.Display() != "BTC/USDT" {
+		t.Errorf("unexpected %s %s", symbol, symbol.Display())
+	}
+	if encodeSymbolBi(symbol) != "BTCUSDT" || parsePairBi("BTC/USDT") != "btcusdt@ticker" || parsePairHu("btc-usdt") != "market.btcusdt.ticker" {
+		t.Error("pairs should be encoded the way each market spells them")
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"ethbusd", "ethbusd", 0},
+		{"ehtbusd", "ethbusd", 1},
+		{"ethusd", "ethusdt", 1},
+		{"btcusdt", "ethusdt", 2},
+		{"", "eth", 3},
+	}
+	for _, v := range cases {
+		if got := editDistance(v.a, v.b); got != v.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", v.a, v.b, got, v.want)
+		}
+	}
+}
+
+func TestSendNoPairErrSuggests(t *testing.T) {
+	srv := telegramtest.NewServer()
+	defer srv.Close()
+	saved := DefaultCatalog
+	DefaultCatalog = newTestCatalog()
+	defer func() { DefaultCatalog = saved }()
+
+	err := sendNoPairErr(srv.TGClient(), telegram.Chat{Id: 7}, "ehtbusd", func(symbol string) string {
+		return "/price " + symbol
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := srv.Sent()[0]
+	if !strings.Contains(sent.Text, "Did you mean <b>ETH/BUSD</b>?") {
+		t.Errorf("unexpected text %s", sent.Text)
+	}
+	if data := sent.ReplyMarkup.InlineKeyboard[0][0].CallbackData; data != "/price ethbusd" {
+		t.Errorf("unexpected callback %s", data)
+	}
+
+	update := newTestUpdate("")
+	update.CallbackQuery = telegram.CallbackQuery{From: telegram.User{Id: 7}, Msg: telegram.Message{Chat: telegram.Chat{Id: 7}}, Data: "/price ethbusd"}
+	retry, err := RetryCallback(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry.Msg.Text != "/price ethbusd" || retry.FromChat().Id != 7 || retry.Msg.Entities[0].Length != len("/price") {
+		t.Errorf("unexpected retry %v", retry.Msg)
+	}
+}
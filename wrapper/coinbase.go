@@ -0,0 +1,175 @@
+package wrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	cryptoMarkets "github.com/HomelessHunter/CTC/wrapper/models/cryptoMarkets"
+	"github.com/gorilla/websocket"
+)
+
+const Coinbase string = "coinbase"
+
+type coinbase struct{}
+
+func (coinbase) Name() string {
+	return Coinbase
+}
+
+func (coinbase) Decal() string {
+	return "&#128311;"
+}
+
+func (coinbase) Connect(dialer *websocket.Dialer, pairs []string) (*websocket.Conn, error) {
+	return ConnectCoinbase(dialer, pairs)
+}
+
+func (coinbase) Subscribe(conn *websocket.Conn, pair string) error {
+	return SubscribeCb(conn, pair)
+}
+
+func (coinbase) Unsubscribe(conn *websocket.Conn, pair string) error {
+	return UnsubCb(conn, pair)
+}
+
+func (coinbase) Decode(data []byte) (*cryptoMarkets.Tick, []byte, error) {
+	ticker := &cryptoMarkets.TickerCb{}
+	err := json.Unmarshal(data, ticker)
+	if err != nil {
+		return nil, nil, fmt.Errorf("coinbase decode: %s", err)
+	}
+	switch ticker.Type {
+	case "ticker":
+	case "error":
+		return nil, nil, fmt.Errorf("coinbase: %s %s", ticker.Message, ticker.Reason)
+	default:
+		// subscriptions acks and heartbeats
+		return nil, nil, nil
+	}
+	lastPrice, err := ticker.GetLastPrice()
+	if err != nil {
+		return nil, nil, fmt.Errorf("coinbase decode: %s", err)
+	}
+	return cryptoMarkets.NewTick(Coinbase, decodeSymbolCb(ticker.ProductID).String(), lastPrice), nil, nil
+}
+
+func (coinbase) LatestPrice(client *http.Client, pair string) (float64, error) {
+	latestPrice, err := LatestPriceCb(pair, client)
+	if err != nil {
+		return 0, err
+	}
+	if latestPrice.Message != "" {
+		return 0, fmt.Errorf("coinbase: %s", latestPrice.Message)
+	}
+	return latestPrice.GetLastPrice()
+}
+
+func (c coinbase) PairExist(client *http.Client, pair string) bool {
+	_, err := c.LatestPrice(client, pair)
+	return err == nil
+}
+
+func (coinbase) Symbols(client *http.Client) ([]cryptoMarkets.SymbolInfo, error) {
+	products, err := ProductsCb(client)
+	if err != nil {
+		return nil, err
+	}
+	symbols := make([]cryptoMarkets.SymbolInfo, 0, len(products))
+	for _, v := range products {
+		symbol := cryptoMarkets.NewSymbol(v.BaseCurrency, v.QuoteCurrency)
+		tickSize, _ := strconv.ParseFloat(v.QuoteIncrement, 64)
+		symbols = append(symbols, cryptoMarkets.SymbolInfo{
+			Market:   Coinbase,
+			Symbol:   symbol.String(),
+			Base:     symbol.Base,
+			Quote:    symbol.Quote,
+			TickSize: tickSize,
+			Status:   v.Status,
+			Trading:  v.Status == "online" && !v.TradingDisabled,
+		})
+	}
+	return symbols, nil
+}
+
+func ConnectCoinbase(dialer *websocket.Dialer, pairs []string) (*websocket.Conn, error) {
+	conn, resp, err := dialer.Dial("wss://ws-feed.exchange.coinbase.com", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Coinbase_Dialer_ERR: %s", err)
+		return nil, dialErr(resp, err)
+	}
+	err = conn.WriteJSON(subscriptionCb("subscribe", parsePairsCb(pairs)))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Coinbase_WriteJSON: %s", err)
+		return nil, err
+	}
+	return conn, nil
+}
+
+func SubscribeCb(conn *websocket.Conn, pair string) error {
+	return conn.WriteJSON(subscriptionCb("subscribe", []string{parsePairCb(pair)}))
+}
+
+func UnsubCb(conn *websocket.Conn, pair string) error {
+	return conn.WriteJSON(subscriptionCb("unsubscribe", []string{parsePairCb(pair)}))
+}
+
+func subscriptionCb(event string, productIDs []string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":        event,
+		"product_ids": productIDs,
+		"channels":    []string{"ticker"},
+	}
+}
+
+func parsePairsCb(pairs []string) []string {
+	productIDs := make([]string, len(pairs))
+	for i, v := range pairs {
+		productIDs[i] = parsePairCb(v)
+	}
+	return productIDs
+}
+
+func parsePairCb(pair string) string {
+	return encodeSymbolCb(DefaultCatalog.ParseSymbol(pair))
+}
+
+// encodeSymbolCb spells symbol as a Coinbase product id, e.g. "BTC-USD"
+func encodeSymbolCb(symbol cryptoMarkets.Symbol) string {
+	return strings.ToUpper(symbol.Base + "-" + symbol.Quote)
+}
+
+func decodeSymbolCb(productID string) cryptoMarkets.Symbol {
+	return cryptoMarkets.ParseSymbol(productID)
+}
+
+func LatestPriceCb(pair string, client *http.Client) (*cryptoMarkets.LatestTickerCb, error) {
+	data, err := getData(client, fmt.Sprintf("https://api.exchange.coinbase.com/products/%s/ticker", parsePairCb(pair)))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "LatestPriceCb: %s", err)
+		return nil, err
+	}
+	latestPrice := &cryptoMarkets.LatestTickerCb{}
+	err = json.Unmarshal(data, latestPrice)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "LatestPriceCb: %s", err)
+		return nil, err
+	}
+	return latestPrice, nil
+}
+
+func ProductsCb(client *http.Client) ([]cryptoMarkets.ProductCb, error) {
+	data, err := getData(client, "https://api.exchange.coinbase.com/products")
+	if err != nil {
+		return nil, fmt.Errorf("ProductsCb: %s", err)
+	}
+	var products []cryptoMarkets.ProductCb
+	err = json.Unmarshal(data, &products)
+	if err != nil {
+		return nil, fmt.Errorf("ProductsCb: %s", err)
+	}
+	return products, nil
+}
@@ -0,0 +1,171 @@
+package wrapper
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	db "github.com/HomelessHunter/CTC/db/models"
+	telegram "github.com/HomelessHunter/CTC/wrapper/models/telegram"
+)
+
+// Conversation buttons carry "conv <action> [value]"
+const (
+	ConvCancel    = "cancel"
+	ConvPair      = "pair"
+	ConvCondition = "cond"
+)
+
+// ConversationCallback reads the action and value of a conversation button
+func ConversationCallback(update *telegram.Update) (string, string, error) {
+	fields := strings.Fields(update.GetCallbackData())
+	if len(fields) < 2 || fields[0] != "conv" {
+		return "", "", fmt.Errorf("ConversationCallback: unexpected data %q", update.GetCallbackData())
+	}
+	if len(fields) == 2 {
+		return fields[1], "", nil
+	}
+	return fields[1], fields[2], nil
+}
+
+// AskPair starts a step by step alert, suggestions become buttons
+func AskPair(tg *telegram.Client, chatID int64, suggestions []string) error {
+	buttons := make([]telegram.InlineKeyboardButton, 0, len(suggestions))
+	for _, v := range suggestions {
+		ikb, err := telegram.NewInlineKeyboardButton(
+			telegram.WithIKBText(DisplayPair(v)),
+			telegram.WithIKBCallbackData(fmt.Sprintf("conv %s %s", ConvPair, v)),
+		)
+		if err != nil {
+			return fmt.Errorf("AskPair: %s", err)
+		}
+		buttons = append(buttons, *ikb)
+	}
+	err := sendConversationMsg(tg, chatID, "Which pair should I watch?\nPick one or type it (e.g. <u>btcusdt</u>)", buttons, 3)
+	if err != nil {
+		return fmt.Errorf("AskPair: %s", err)
+	}
+	return nil
+}
+
+// ResolvePair finds the market trading the typed pair and tells the user when there's none
+func ResolvePair(tg *telegram.Client, client *http.Client, chatID int64, input string) (string, string, error) {
+	pair := strings.TrimSpace(input)
+	chat, err := telegram.NewChat(telegram.WithChatId(chatID))
+	if err != nil {
+		return "", "", fmt.Errorf("ResolvePair: %s", err)
+	}
+	retry := func(symbol string) string {
+		return fmt.Sprintf("conv %s %s", ConvPair, symbol)
+	}
+	if pair == "" || strings.ContainsAny(pair, " ") {
+		sendNoPairErr(tg, *chat, pair, retry)
+		return "", "", fmt.Errorf("ResolvePair: bad pair %q", input)
+	}
+	market, pair, err := getMarket(pair, client)
+	if err != nil {
+		sendPairErr(tg, *chat, pair, err, retry)
+		return "", "", fmt.Errorf("ResolvePair: %s", err)
+	}
+	return pair, market, nil
+}
+
+// AskCondition asks when the alert should fire
+func AskCondition(tg *telegram.Client, chatID int64, pair string) error {
+	conditions := []struct{ text, condition string }{
+		{"Above", db.ConditionAbove},
+		{"Below", db.ConditionBelow},
+		{"Cross", db.ConditionCross},
+		{"Near", db.ConditionNear},
+	}
+	buttons := make([]telegram.InlineKeyboardButton, 0, len(conditions))
+	for _, v := range conditions {
+		ikb, err := telegram.NewInlineKeyboardButton(
+			telegram.WithIKBText(v.text),
+			telegram.WithIKBCallbackData(fmt.Sprintf("conv %s %s", ConvCondition, v.condition)),
+		)
+		if err != nil {
+			return fmt.Errorf("AskCondition: %s", err)
+		}
+		buttons = append(buttons, *ikb)
+	}
+	text := fmt.Sprintf("When should <b>%s</b> fire?\nWhen the price goes above, below, crosses the target or gets near it", DisplayPair(pair))
+	err := sendConversationMsg(tg, chatID, text, buttons, 2)
+	if err != nil {
+		return fmt.Errorf("AskCondition: %s", err)
+	}
+	return nil
+}
+
+// AskPrice asks for the target
+func AskPrice(tg *telegram.Client, chatID int64, pair string, condition string) error {
+	text := fmt.Sprintf("What's the target price for <b>%s</b> (%s)?", DisplayPair(pair), condition)
+	err := sendConversationMsg(tg, chatID, text, nil, 1)
+	if err != nil {
+		return fmt.Errorf("AskPrice: %s", err)
+	}
+	return nil
+}
+
+// ParseTargetPrice reads a typed target, separators like "70 000" or "70,000.5" are fine
+func ParseTargetPrice(input string) (float64, error) {
+	input = strings.NewReplacer(" ", "", ",", "").Replace(strings.TrimSpace(input))
+	price, err := strconv.ParseFloat(input, 64)
+	if err != nil || price <= 0 {
+		return 0, errors.New("the price should be a positive number")
+	}
+	return price, nil
+}
+
+func SendConversationEnd(tg *telegram.Client, chatID int64, text string) error {
+	chat, err := telegram.NewChat(telegram.WithChatId(chatID))
+	if err != nil {
+		return fmt.Errorf("SendConversationEnd: %s", err)
+	}
+	msg, err := telegram.NewMsg(telegram.WithMsgChat(chat), telegram.WithMsgText(text))
+	if err != nil {
+		return fmt.Errorf("SendConversationEnd: %s", err)
+	}
+	_, err = sendMsg(tg, *msg, false)
+	if err != nil {
+		return fmt.Errorf("SendConversationEnd: %s", err)
+	}
+	return nil
+}
+
+// SendConversationErr tells the user the answer didn't fit the question, the conversation goes on
+func SendConversationErr(tg *telegram.Client, chatID int64, err error) error {
+	chat, chatErr := telegram.NewChat(telegram.WithChatId(chatID))
+	if chatErr != nil {
+		return fmt.Errorf("SendConversationErr: %s", chatErr)
+	}
+	return sendErrMsg(tg, *chat, err)
+}
+
+// sendConversationMsg sends a question with its buttons and a cancel button below them
+func sendConversationMsg(tg *telegram.Client, chatID int64, text string, buttons []telegram.InlineKeyboardButton, perRow int) error {
+	chat, err := telegram.NewChat(telegram.WithChatId(chatID))
+	if err != nil {
+		return err
+	}
+	cancel, err := telegram.NewInlineKeyboardButton(
+		telegram.WithIKBText("Cancel"),
+		telegram.WithIKBCallbackData(fmt.Sprintf("conv %s", ConvCancel)),
+	)
+	if err != nil {
+		return err
+	}
+	rows := append(gridKeyboard(buttons, perRow), []telegram.InlineKeyboardButton{*cancel})
+	ik, err := telegram.NewInlineKeyboardMarkup(rows)
+	if err != nil {
+		return err
+	}
+	msg, err := telegram.NewMsg(telegram.WithMsgChat(chat), telegram.WithMsgText(text), telegram.WithMsgReplyMarkup(ik))
+	if err != nil {
+		return err
+	}
+	_, err = sendMsg(tg, *msg, false)
+	return err
+}
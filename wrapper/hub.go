@@ -0,0 +1,522 @@
+package wrapper
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	cryptoMarkets "github.com/HomelessHunter/CTC/wrapper/models/cryptoMarkets"
+	"github.com/gorilla/websocket"
+)
+
+var ErrHubClosed = errors.New("hub is closed")
+
+// ErrStreamLimit is returned by Acquire when the connection streams as many pairs as the exchange allows
+var ErrStreamLimit = errors.New("too many pairs on one connection")
+
+// ErrPermanent marks connection errors a reconnect won't fix, e.g. a rejected handshake
+var ErrPermanent = errors.New("permanent error")
+
+// ConnLifetime is implemented by exchanges that drop connections after a while,
+// hubs replace the connection before MaxConnAge runs out
+type ConnLifetime interface {
+	MaxConnAge() time.Duration
+}
+
+const (
+	defaultMinBackoff = time.Second
+	defaultMaxBackoff = time.Minute
+)
+
+// Hub multiplexes every subscription on a market over a single websocket, as many as
+// the exchange's StreamLimit allows.
+// Pairs are reference counted across all users: the first Acquire subscribes,
+// the last Release unsubscribes and an idle hub drops its connection.
+// A lost connection is re-established with exponential backoff and every pair resubscribed.
+type Hub struct {
+	exchange Exchange
+	dialer   *websocket.Dialer
+	onTick   func(*cryptoMarkets.Tick)
+	onDown   func(market string, err error)
+
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	maxAge     time.Duration
+	maxStreams int
+
+	// mu guards conn, refs and the supervisor state, and serializes writes to conn.
+	// It's released while dialing, dialing tells Acquire and the supervisor a connection is on its way
+	mu           sync.Mutex
+	conn         *websocket.Conn
+	ageTimer     *time.Timer
+	refs         map[string]int
+	reconnecting bool
+	dialing      bool
+	dialed       *sync.Cond
+	closed       bool
+	done         chan struct{}
+	wg           sync.WaitGroup
+}
+
+func NewHub(exchange Exchange, dialer *websocket.Dialer, onTick func(*cryptoMarkets.Tick), opts ...HubOpts) (*Hub, error) {
+	hub := &Hub{
+		exchange:   exchange,
+		dialer:     dialer,
+		onTick:     onTick,
+		onDown:     func(string, error) {},
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+		refs:       make(map[string]int),
+		done:       make(chan struct{}),
+	}
+	hub.dialed = sync.NewCond(&hub.mu)
+	if lifetime, ok := exchange.(ConnLifetime); ok {
+		hub.maxAge = lifetime.MaxConnAge()
+	}
+	if limit, ok := exchange.(StreamLimit); ok {
+		hub.maxStreams = limit.MaxStreams()
+	}
+	for _, opt := range opts {
+		err := opt(hub)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return hub, nil
+}
+
+type HubOpts func(*Hub) error
+
+// WithHubBackoff sets the first and the longest pause between reconnect attempts
+func WithHubBackoff(min time.Duration, max time.Duration) HubOpts {
+	return func(h *Hub) error {
+		if min <= 0 || max < min {
+			return errors.New("backoff should be positive and max shouldn't be less than min")
+		}
+		h.minBackoff = min
+		h.maxBackoff = max
+		return nil
+	}
+}
+
+// WithHubMaxAge replaces connections older than maxAge, 0 keeps them as long as they live
+func WithHubMaxAge(maxAge time.Duration) HubOpts {
+	return func(h *Hub) error {
+		if maxAge < 0 {
+			return errors.New("maxAge shouldn't be negative")
+		}
+		h.maxAge = maxAge
+		return nil
+	}
+}
+
+// WithHubMaxStreams caps how many pairs the hub watches, 0 doesn't cap them
+func WithHubMaxStreams(maxStreams int) HubOpts {
+	return func(h *Hub) error {
+		if maxStreams < 0 {
+			return errors.New("maxStreams shouldn't be negative")
+		}
+		h.maxStreams = maxStreams
+		return nil
+	}
+}
+
+// WithHubOnDown is called when the hub gives up reconnecting after a permanent error
+func WithHubOnDown(onDown func(market string, err error)) HubOpts {
+	return func(h *Hub) error {
+		if onDown == nil {
+			return errors.New("onDown shouldn't be empty")
+		}
+		h.onDown = onDown
+		return nil
+	}
+}
+
+func (h *Hub) Market() string {
+	return h.exchange.Name()
+}
+
+func (h *Hub) Acquire(pair string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for {
+		if h.closed {
+			return ErrHubClosed
+		}
+		if h.refs[pair] > 0 {
+			h.refs[pair]++
+			return nil
+		}
+		if h.conn != nil || !h.dialing {
+			break
+		}
+		// the connection being dialed may already bring the pair
+		h.dialed.Wait()
+	}
+	if h.maxStreams > 0 && len(h.refs) >= h.maxStreams {
+		return fmt.Errorf("Acquire: %w: %s watches %d pairs already", ErrStreamLimit, h.Market(), len(h.refs))
+	}
+
+	if h.conn != nil {
+		err := h.exchange.Subscribe(h.conn, pair)
+		if err != nil {
+			return fmt.Errorf("Acquire: %s", err)
+		}
+		h.refs[pair] = 1
+		return nil
+	}
+
+	// a failed reconnect may have left pairs without a connection
+	pairs := append(h.pairs(), pair)
+	h.dialing = true
+	conn, err := h.dial(pairs)
+	h.dialing = false
+	h.dialed.Broadcast()
+	if err != nil {
+		return fmt.Errorf("Acquire: %s", err)
+	}
+	if h.closed {
+		conn.Close()
+		return ErrHubClosed
+	}
+	h.refs[pair] = 1
+	err = h.catchUp(conn, pairs)
+	if err != nil {
+		delete(h.refs, pair)
+		conn.Close()
+		return fmt.Errorf("Acquire: %s", err)
+	}
+	h.attach(conn)
+	return nil
+}
+
+func (h *Hub) Release(pair string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.refs[pair] == 0 {
+		return fmt.Errorf("Release: %s isn't subscribed on %s", pair, h.Market())
+	}
+	h.refs[pair]--
+	if h.refs[pair] > 0 {
+		return nil
+	}
+	delete(h.refs, pair)
+
+	if len(h.refs) == 0 {
+		h.dropConn()
+		return nil
+	}
+	// there is no connection while the hub backs off or after it gave up, the next Connect only subscribes the pairs left
+	if h.conn == nil {
+		return nil
+	}
+	err := h.exchange.Unsubscribe(h.conn, pair)
+	if err != nil {
+		return fmt.Errorf("Release: %s", err)
+	}
+	return nil
+}
+
+func (h *Hub) Refs(pair string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.refs[pair]
+}
+
+// Pairs returns subscribed pairs in alphabetical order
+func (h *Hub) Pairs() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.pairs()
+}
+
+func (h *Hub) pairs() []string {
+	pairs := make([]string, 0, len(h.refs))
+	for k := range h.refs {
+		pairs = append(pairs, k)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+func (h *Hub) Close() {
+	h.mu.Lock()
+	if !h.closed {
+		h.closed = true
+		close(h.done)
+		h.dialed.Broadcast()
+	}
+	h.dropConn()
+	h.mu.Unlock()
+	h.wg.Wait()
+}
+
+// dial connects with mu released, so the handshake doesn't hold up reads, pings and other
+// users of the hub. It must be called with mu held and returns with mu held again,
+// by then pairs may be out of date and catchUp brings the connection in line
+func (h *Hub) dial(pairs []string) (*websocket.Conn, error) {
+	h.mu.Unlock()
+	defer h.mu.Lock()
+	return h.exchange.Connect(h.dialer, pairs)
+}
+
+// catchUp subscribes conn, dialed with pairs, to the pairs acquired meanwhile and
+// unsubscribes the ones released meanwhile, must be called with mu held
+func (h *Hub) catchUp(conn *websocket.Conn, pairs []string) error {
+	dialed := make(map[string]bool, len(pairs))
+	for _, v := range pairs {
+		dialed[v] = true
+		if h.refs[v] == 0 {
+			err := h.exchange.Unsubscribe(conn, v)
+			if err != nil {
+				return fmt.Errorf("catchUp: %s", err)
+			}
+		}
+	}
+	for _, v := range h.pairs() {
+		if !dialed[v] {
+			err := h.exchange.Subscribe(conn, v)
+			if err != nil {
+				return fmt.Errorf("catchUp: %s", err)
+			}
+		}
+	}
+	return nil
+}
+
+// attach makes conn the hub's connection and starts reading it, must be called with mu held
+func (h *Hub) attach(conn *websocket.Conn) {
+	h.conn = conn
+	h.wg.Add(1)
+	go h.read(conn)
+	if pinger, ok := h.exchange.(Pinger); ok {
+		h.wg.Add(1)
+		go h.ping(conn, pinger)
+	}
+	if h.maxAge > 0 {
+		h.ageTimer = time.AfterFunc(h.maxAge, func() { h.renew(conn) })
+	}
+}
+
+// dropConn must be called with mu held
+func (h *Hub) dropConn() {
+	if h.conn == nil {
+		return
+	}
+	if h.ageTimer != nil {
+		h.ageTimer.Stop()
+		h.ageTimer = nil
+	}
+	h.conn.Close()
+	h.conn = nil
+}
+
+func (h *Hub) write(conn *websocket.Conn, data []byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conn != conn {
+		return nil
+	}
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (h *Hub) read(conn *websocket.Conn) {
+	defer h.wg.Done()
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			h.handleReadErr(conn, err)
+			return
+		}
+
+		tick, reply, err := h.exchange.Decode(data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s hub: %s\n", h.Market(), err)
+			continue
+		}
+		if reply != nil {
+			err = h.write(conn, reply)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s hub: %s\n", h.Market(), err)
+			}
+		}
+		if tick != nil {
+			h.onTick(tick)
+		}
+	}
+}
+
+// ping keeps conn open for exchanges that want pings from the client, it stops once conn is replaced
+func (h *Hub) ping(conn *websocket.Conn, pinger Pinger) {
+	defer h.wg.Done()
+	ticker := time.NewTicker(pinger.PingInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+		}
+		h.mu.Lock()
+		if h.conn != conn {
+			h.mu.Unlock()
+			return
+		}
+		err := conn.WriteMessage(websocket.TextMessage, pinger.Ping())
+		h.mu.Unlock()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s hub: ping: %s\n", h.Market(), err)
+		}
+	}
+}
+
+// handleReadErr hands the pairs still referenced over to the supervisor
+// unless the connection was dropped on purpose
+func (h *Hub) handleReadErr(conn *websocket.Conn, readErr error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conn != conn {
+		return
+	}
+	h.dropConn()
+	if h.closed || len(h.refs) == 0 {
+		return
+	}
+	if isPermanent(readErr) {
+		fmt.Fprintf(os.Stderr, "%s hub: %s, giving up\n", h.Market(), readErr)
+		go h.onDown(h.Market(), readErr)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s hub: %s, reconnecting\n", h.Market(), readErr)
+	h.superviseLocked()
+}
+
+// superviseLocked starts reconnecting unless it's already going on, must be called with mu held
+func (h *Hub) superviseLocked() {
+	if h.reconnecting {
+		return
+	}
+	h.reconnecting = true
+	h.wg.Add(1)
+	go h.supervise()
+}
+
+// supervise reconnects with exponential backoff until a connection is up, the hub
+// has nothing left to watch, or the error is permanent. Acquire may connect meanwhile,
+// then there's nothing left to do either
+func (h *Hub) supervise() {
+	defer h.wg.Done()
+	for attempt := 0; ; attempt++ {
+		timer := time.NewTimer(h.backoff(attempt))
+		select {
+		case <-h.done:
+			timer.Stop()
+			h.mu.Lock()
+			h.reconnecting = false
+			h.mu.Unlock()
+			return
+		case <-timer.C:
+		}
+
+		h.mu.Lock()
+		for h.dialing && !h.closed {
+			h.dialed.Wait()
+		}
+		if h.closed || h.conn != nil || len(h.refs) == 0 {
+			h.reconnecting = false
+			h.mu.Unlock()
+			return
+		}
+		pairs := h.pairs()
+		h.dialing = true
+		conn, err := h.dial(pairs)
+		h.dialing = false
+		h.dialed.Broadcast()
+		if err == nil {
+			if h.closed || len(h.refs) == 0 {
+				conn.Close()
+				h.reconnecting = false
+				h.mu.Unlock()
+				return
+			}
+			err = h.catchUp(conn, pairs)
+			if err == nil {
+				h.attach(conn)
+				h.reconnecting = false
+				h.mu.Unlock()
+				return
+			}
+			conn.Close()
+		}
+		if isPermanent(err) {
+			h.reconnecting = false
+			h.mu.Unlock()
+			fmt.Fprintf(os.Stderr, "%s hub: cannot reconnect: %s, giving up\n", h.Market(), err)
+			h.onDown(h.Market(), err)
+			return
+		}
+		h.mu.Unlock()
+		fmt.Fprintf(os.Stderr, "%s hub: cannot reconnect: %s, attempt %d\n", h.Market(), err, attempt+1)
+	}
+}
+
+// renew replaces conn before the exchange drops it, the new connection is up
+// before the old one is closed so no tick is missed. Pairs keep going through conn
+// while the new one is dialed. A failed renew leaves conn as is, losing it later
+// goes through the supervisor
+func (h *Hub) renew(conn *websocket.Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conn != conn || h.closed {
+		return
+	}
+	pairs := h.pairs()
+	fresh, err := h.dial(pairs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s hub: cannot renew the connection: %s\n", h.Market(), err)
+		return
+	}
+	// conn may have been lost or dropped meanwhile
+	if h.conn != conn || h.closed {
+		fresh.Close()
+		return
+	}
+	err = h.catchUp(fresh, pairs)
+	if err != nil {
+		fresh.Close()
+		fmt.Fprintf(os.Stderr, "%s hub: cannot renew the connection: %s\n", h.Market(), err)
+		return
+	}
+	h.dropConn()
+	h.attach(fresh)
+}
+
+// backoff doubles the pause with every attempt up to maxBackoff, full jitter keeps
+// hubs that lost their connections together from reconnecting together
+func (h *Hub) backoff(attempt int) time.Duration {
+	d := h.maxBackoff
+	if attempt < 32 && h.minBackoff<<attempt < h.maxBackoff {
+		d = h.minBackoff << attempt
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// isPermanent tells errors a reconnect won't fix: a rejected handshake or
+// the exchange closing the connection because of what the hub sent
+func isPermanent(err error) bool {
+	if errors.Is(err, ErrPermanent) {
+		return true
+	}
+	closeErr := &websocket.CloseError{}
+	if errors.As(err, &closeErr) {
+		switch closeErr.Code {
+		case websocket.ClosePolicyViolation, websocket.CloseUnsupportedData, websocket.CloseInvalidFramePayloadData:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,520 @@
+package wrapper
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cryptoMarkets "github.com/HomelessHunter/CTC/wrapper/models/cryptoMarkets"
+	"github.com/gorilla/websocket"
+)
+
+// fakeExchange talks plain json {"op": "sub"|"unsub", "pair": ...} to fakeMarket
+type fakeExchange struct {
+	url string
+}
+
+func (fakeExchange) Name() string  { return "fake" }
+func (fakeExchange) Decal() string { return "" }
+
+func (e fakeExchange) Connect(dialer *websocket.Dialer, pairs []string) (*websocket.Conn, error) {
+	conn, resp, err := dialer.Dial(e.url, nil)
+	if err != nil {
+		return nil, dialErr(resp, err)
+	}
+	for _, v := range pairs {
+		err = e.Subscribe(conn, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (fakeExchange) Subscribe(conn *websocket.Conn, pair string) error {
+	return conn.WriteJSON(map[string]string{"op": "sub", "pair": pair})
+}
+
+func (fakeExchange) Unsubscribe(conn *websocket.Conn, pair string) error {
+	return conn.WriteJSON(map[string]string{"op": "unsub", "pair": pair})
+}
+
+func (fakeExchange) Decode(data []byte) (*cryptoMarkets.Tick, []byte, error) {
+	tick := &cryptoMarkets.Tick{}
+	err := json.Unmarshal(data, tick)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tick, nil, nil
+}
+
+func (fakeExchange) LatestPrice(client *http.Client, pair string) (float64, error) { return 0, nil }
+func (fakeExchange) PairExist(client *http.Client, pair string) bool               { return true }
+
+// fakeMarket records subscription ops and lets the test push ticks,
+// handshakes are answered with the statuses in reject first
+type fakeMarket struct {
+	mu     sync.Mutex
+	ops    []string
+	conns  []*websocket.Conn
+	reject []int
+	dials  int
+	closed int
+}
+
+func (m *fakeMarket) handler(t *testing.T) http.HandlerFunc {
+	upgrader := websocket.Upgrader{}
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		m.dials++
+		if len(m.reject) > 0 {
+			status := m.reject[0]
+			m.reject = m.reject[1:]
+			m.mu.Unlock()
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		m.mu.Unlock()
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		m.mu.Lock()
+		m.conns = append(m.conns, conn)
+		m.mu.Unlock()
+		for {
+			op := map[string]string{}
+			if err := conn.ReadJSON(&op); err != nil {
+				m.mu.Lock()
+				m.closed++
+				m.mu.Unlock()
+				return
+			}
+			m.mu.Lock()
+			m.ops = append(m.ops, op["op"]+" "+op["pair"])
+			m.mu.Unlock()
+		}
+	}
+}
+
+func (m *fakeMarket) waitOps(t *testing.T, n int) []string {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		m.mu.Lock()
+		if len(m.ops) >= n {
+			ops := append([]string(nil), m.ops...)
+			m.mu.Unlock()
+			return ops
+		}
+		m.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %d ops, got %v", n, m.ops)
+	return nil
+}
+
+func (m *fakeMarket) conn(i int) *websocket.Conn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.conns[i]
+}
+
+func (m *fakeMarket) setReject(statuses ...int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reject = statuses
+}
+
+func (m *fakeMarket) closedCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.closed
+}
+
+func (m *fakeMarket) dialCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.dials
+}
+
+func newFakeHub(t *testing.T, onTick func(*cryptoMarkets.Tick), opts ...HubOpts) (*Hub, *fakeMarket, func()) {
+	market := &fakeMarket{}
+	srv := httptest.NewServer(market.handler(t))
+	exchange := fakeExchange{url: "ws" + strings.TrimPrefix(srv.URL, "http")}
+	hub, err := NewHub(exchange, websocket.DefaultDialer, onTick, append([]HubOpts{WithHubBackoff(10*time.Millisecond, 40*time.Millisecond)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hub, market, func() {
+		hub.Close()
+		srv.Close()
+	}
+}
+
+func TestHubRefCount(t *testing.T) {
+	hub, market, done := newFakeHub(t, func(*cryptoMarkets.Tick) {})
+	defer done()
+
+	for _, v := range []string{"btcusdt", "btcusdt", "ethusdt"} {
+		if err := hub.Acquire(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hub.Refs("btcusdt") != 2 {
+		t.Errorf("expected 2 refs, got %d", hub.Refs("btcusdt"))
+	}
+	// one subscription per pair no matter how many alerts watch it
+	ops := market.waitOps(t, 2)
+	if ops[0] != "sub btcusdt" || ops[1] != "sub ethusdt" {
+		t.Errorf("unexpected ops %v", ops)
+	}
+
+	if err := hub.Release("btcusdt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := hub.Release("btcusdt"); err != nil {
+		t.Fatal(err)
+	}
+	ops = market.waitOps(t, 3)
+	if ops[2] != "unsub btcusdt" {
+		t.Errorf("unexpected ops %v", ops)
+	}
+	if err := hub.Release("btcusdt"); err == nil {
+		t.Error("releasing an unknown pair should fail")
+	}
+	if pairs := hub.Pairs(); len(pairs) != 1 || pairs[0] != "ethusdt" {
+		t.Errorf("unexpected pairs %v", pairs)
+	}
+}
+
+func TestHubStreamLimit(t *testing.T) {
+	hub, market, done := newFakeHub(t, func(*cryptoMarkets.Tick) {}, WithHubMaxStreams(2))
+	defer done()
+
+	hub.Acquire("btcusdt")
+	hub.Acquire("ethusdt")
+	if err := hub.Acquire("btcusdt"); err != nil {
+		t.Errorf("a pair already watched doesn't take another stream: %s", err)
+	}
+	if err := hub.Acquire("solusdt"); !errors.Is(err, ErrStreamLimit) {
+		t.Errorf("expected ErrStreamLimit, got %v", err)
+	}
+	hub.Release("ethusdt")
+	if err := hub.Acquire("solusdt"); err != nil {
+		t.Errorf("a released pair should free its stream: %s", err)
+	}
+	ops := market.waitOps(t, 4)
+	if ops[3] != "sub solusdt" {
+		t.Errorf("unexpected ops %v", ops)
+	}
+}
+
+func TestHubFanOut(t *testing.T) {
+	ticks := make(chan *cryptoMarkets.Tick, 1)
+	hub, market, done := newFakeHub(t, func(tick *cryptoMarkets.Tick) { ticks <- tick })
+	defer done()
+
+	if err := hub.Acquire("btcusdt"); err != nil {
+		t.Fatal(err)
+	}
+	market.waitOps(t, 1)
+	err := market.conn(0).WriteJSON(cryptoMarkets.Tick{Market: "fake", Symbol: "btcusdt", LastPrice: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case tick := <-ticks:
+		if tick.Symbol != "btcusdt" || tick.LastPrice != 42 {
+			t.Errorf("unexpected tick %+v", tick)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("tick wasn't delivered")
+	}
+}
+
+func TestHubResubscribesAfterReadErr(t *testing.T) {
+	hub, market, done := newFakeHub(t, func(*cryptoMarkets.Tick) {})
+	defer done()
+
+	hub.Acquire("btcusdt")
+	hub.Acquire("ethusdt")
+	market.waitOps(t, 2)
+
+	market.conn(0).Close()
+	ops := market.waitOps(t, 4)
+	if ops[2] != "sub btcusdt" || ops[3] != "sub ethusdt" {
+		t.Errorf("subscriptions weren't restored: %v", ops)
+	}
+}
+
+func TestHubBackoff(t *testing.T) {
+	hub, _ := NewHub(fakeExchange{}, websocket.DefaultDialer, nil, WithHubBackoff(time.Second, 10*time.Second))
+	for attempt, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		for i := 0; i < 20; i++ {
+			if d := hub.backoff(attempt); d < max/2 || d > max {
+				t.Errorf("attempt %d: %s isn't within [%s, %s]", attempt, d, max/2, max)
+			}
+		}
+	}
+	if d := hub.backoff(100); d > 10*time.Second {
+		t.Errorf("backoff should be capped, got %s", d)
+	}
+}
+
+func TestHubRetriesTransientErr(t *testing.T) {
+	hub, market, done := newFakeHub(t, func(*cryptoMarkets.Tick) {})
+	defer done()
+
+	hub.Acquire("btcusdt")
+	market.waitOps(t, 1)
+
+	market.setReject(http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	market.conn(0).Close()
+	ops := market.waitOps(t, 2)
+	if ops[1] != "sub btcusdt" {
+		t.Errorf("subscriptions weren't restored: %v", ops)
+	}
+	if n := market.dialCount(); n != 4 {
+		t.Errorf("expected 2 failed attempts and a successful one, got %d dials", n-1)
+	}
+}
+
+func TestHubGivesUpOnPermanentErr(t *testing.T) {
+	down := make(chan error, 1)
+	hub, market, done := newFakeHub(t, func(*cryptoMarkets.Tick) {}, WithHubOnDown(func(market string, err error) { down <- err }))
+	defer done()
+
+	hub.Acquire("btcusdt")
+	market.waitOps(t, 1)
+
+	market.setReject(http.StatusForbidden, http.StatusForbidden)
+	market.conn(0).Close()
+	select {
+	case err := <-down:
+		if !errors.Is(err, ErrPermanent) {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("onDown wasn't called")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := market.dialCount(); n != 2 {
+		t.Errorf("a refused handshake shouldn't be retried, got %d dials", n)
+	}
+	if hub.Refs("btcusdt") != 1 {
+		t.Error("pairs should be kept for the next Acquire")
+	}
+}
+
+func TestHubReleaseWhileDown(t *testing.T) {
+	down := make(chan error, 1)
+	hub, market, done := newFakeHub(t, func(*cryptoMarkets.Tick) {}, WithHubOnDown(func(market string, err error) { down <- err }))
+	defer done()
+
+	hub.Acquire("btcusdt")
+	hub.Acquire("ethusdt")
+	market.waitOps(t, 2)
+
+	market.setReject(http.StatusForbidden)
+	market.conn(0).Close()
+	select {
+	case <-down:
+	case <-time.After(2 * time.Second):
+		t.Fatal("onDown wasn't called")
+	}
+	if err := hub.Release("ethusdt"); err != nil {
+		t.Fatalf("releasing a pair of a hub that's down should work: %s", err)
+	}
+
+	hub.Acquire("solusdt")
+	ops := market.waitOps(t, 4)
+	if ops[2] != "sub btcusdt" || ops[3] != "sub solusdt" {
+		t.Errorf("the released pair shouldn't be subscribed again: %v", ops)
+	}
+}
+
+func TestHubReleaseWhileBackingOff(t *testing.T) {
+	hub, market, done := newFakeHub(t, func(*cryptoMarkets.Tick) {}, WithHubBackoff(200*time.Millisecond, 400*time.Millisecond))
+	defer done()
+
+	hub.Acquire("btcusdt")
+	hub.Acquire("ethusdt")
+	market.waitOps(t, 2)
+
+	market.conn(0).Close()
+	// wait for the hub to notice before the supervisor's first attempt
+	time.Sleep(50 * time.Millisecond)
+	if err := hub.Release("ethusdt"); err != nil {
+		t.Fatalf("releasing a pair while reconnecting should work: %s", err)
+	}
+	ops := market.waitOps(t, 3)
+	time.Sleep(50 * time.Millisecond)
+	if ops = market.waitOps(t, 3); len(ops) != 3 || ops[2] != "sub btcusdt" {
+		t.Errorf("only the pair left should be resubscribed: %v", ops)
+	}
+}
+
+func TestHubRenewsOldConn(t *testing.T) {
+	hub, market, done := newFakeHub(t, func(*cryptoMarkets.Tick) {}, WithHubMaxAge(100*time.Millisecond))
+	defer done()
+
+	hub.Acquire("btcusdt")
+	ops := market.waitOps(t, 3)
+	if ops[1] != "sub btcusdt" || ops[2] != "sub btcusdt" {
+		t.Errorf("renewed connections should restore subscriptions: %v", ops)
+	}
+	// the replaced connection is closed by the hub
+	deadline := time.Now().Add(time.Second)
+	for market.closedCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if market.closedCount() == 0 {
+		t.Error("old connection should be closed")
+	}
+}
+
+// gatedExchange holds every Connect until the test lets it through the gate
+type gatedExchange struct {
+	fakeExchange
+	dialing chan struct{}
+	gate    chan struct{}
+}
+
+func (e gatedExchange) Connect(dialer *websocket.Dialer, pairs []string) (*websocket.Conn, error) {
+	select {
+	case e.dialing <- struct{}{}:
+	default:
+	}
+	<-e.gate
+	return e.fakeExchange.Connect(dialer, pairs)
+}
+
+func newGatedHub(t *testing.T, opts ...HubOpts) (*Hub, *fakeMarket, gatedExchange, func()) {
+	market := &fakeMarket{}
+	srv := httptest.NewServer(market.handler(t))
+	exchange := gatedExchange{
+		fakeExchange: fakeExchange{url: "ws" + strings.TrimPrefix(srv.URL, "http")},
+		dialing:      make(chan struct{}, 4),
+		gate:         make(chan struct{}, 1),
+	}
+	hub, err := NewHub(exchange, websocket.DefaultDialer, func(*cryptoMarkets.Tick) {}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hub, market, exchange, func() {
+		// dials still waiting would keep Close waiting
+		close(exchange.gate)
+		hub.Close()
+		srv.Close()
+	}
+}
+
+// within fails the test if f doesn't return in time, e.g. because it waits for a dial
+func within(t *testing.T, f func()) {
+	t.Helper()
+	returned := make(chan struct{})
+	go func() {
+		f()
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("the hub is held up by a dial")
+	}
+}
+
+func TestHubDialsWithoutLock(t *testing.T) {
+	hub, market, exchange, done := newGatedHub(t)
+	defer done()
+
+	acquired := make(chan error, 2)
+	go func() { acquired <- hub.Acquire("btcusdt") }()
+	<-exchange.dialing
+	within(t, func() {
+		if pairs := hub.Pairs(); len(pairs) != 0 {
+			t.Errorf("a pair isn't acquired before it's connected: %v", pairs)
+		}
+	})
+
+	// another pair waits for the connection on its way instead of dialing one more
+	go func() { acquired <- hub.Acquire("ethusdt") }()
+	select {
+	case <-exchange.dialing:
+		t.Fatal("a second connection was dialed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	exchange.gate <- struct{}{}
+	for i := 0; i < 2; i++ {
+		if err := <-acquired; err != nil {
+			t.Fatal(err)
+		}
+	}
+	ops := market.waitOps(t, 2)
+	if ops[0] != "sub btcusdt" || ops[1] != "sub ethusdt" || market.dialCount() != 1 {
+		t.Errorf("unexpected ops %v after %d dials", ops, market.dialCount())
+	}
+}
+
+func TestHubRenewCatchesUp(t *testing.T) {
+	hub, market, exchange, done := newGatedHub(t, WithHubMaxAge(100*time.Millisecond))
+	defer done()
+
+	exchange.gate <- struct{}{}
+	if err := hub.Acquire("btcusdt"); err != nil {
+		t.Fatal(err)
+	}
+	hub.Acquire("solusdt")
+	<-exchange.dialing
+	market.waitOps(t, 2)
+
+	// the old connection keeps serving while the new one is dialed
+	select {
+	case <-exchange.dialing:
+	case <-time.After(time.Second):
+		t.Fatal("the connection wasn't renewed")
+	}
+	within(t, func() {
+		if err := hub.Acquire("ethusdt"); err != nil {
+			t.Error(err)
+		}
+		if err := hub.Release("solusdt"); err != nil {
+			t.Error(err)
+		}
+	})
+	market.waitOps(t, 4)
+	exchange.gate <- struct{}{}
+	ops := market.waitOps(t, 8)
+	if strings.Join(ops[4:], ",") != "sub btcusdt,sub solusdt,unsub solusdt,sub ethusdt" {
+		t.Errorf("the new connection should catch up with the pairs: %v", ops)
+	}
+}
+
+// pingingExchange wants {"op": "ping"} from the client
+type pingingExchange struct {
+	fakeExchange
+}
+
+func (pingingExchange) PingInterval() time.Duration { return 20 * time.Millisecond }
+func (pingingExchange) Ping() []byte                { return []byte(`{"op":"ping"}`) }
+
+func TestHubPings(t *testing.T) {
+	market := &fakeMarket{}
+	srv := httptest.NewServer(market.handler(t))
+	defer srv.Close()
+	exchange := pingingExchange{fakeExchange{url: "ws" + strings.TrimPrefix(srv.URL, "http")}}
+	hub, _ := NewHub(exchange, websocket.DefaultDialer, func(*cryptoMarkets.Tick) {})
+	defer hub.Close()
+
+	hub.Acquire("btcusdt")
+	ops := market.waitOps(t, 3)
+	if ops[0] != "sub btcusdt" || ops[1] != "ping " || ops[2] != "ping " {
+		t.Errorf("unexpected ops %v", ops)
+	}
+}
@@ -0,0 +1,194 @@
+package wrapper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+
+	cryptoMarkets "github.com/HomelessHunter/CTC/wrapper/models/cryptoMarkets"
+	"github.com/gorilla/websocket"
+)
+
+const Huobi string = "huobi"
+
+type huobi struct{}
+
+func (huobi) Name() string {
+	return Huobi
+}
+
+func (huobi) Decal() string {
+	return "&#128309;"
+}
+
+func (huobi) Connect(dialer *websocket.Dialer, pairs []string) (*websocket.Conn, error) {
+	return ConnectHuobi(dialer, pairs)
+}
+
+func (huobi) Subscribe(conn *websocket.Conn, pair string) error {
+	return SubscribeHu(conn, pair)
+}
+
+func (huobi) Unsubscribe(conn *websocket.Conn, pair string) error {
+	return UnsubHu(conn, pair)
+}
+
+// Huobi gzips every frame and expects {"pong": n} for each {"ping": n}
+func (huobi) Decode(data []byte) (*cryptoMarkets.Tick, []byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("huobi decode: %s", err)
+	}
+	defer zr.Close()
+	data, err = io.ReadAll(zr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("huobi decode: %s", err)
+	}
+
+	ping := cryptoMarkets.NewPing()
+	err = json.Unmarshal(data, ping)
+	if err == nil && ping.Ping > 0 {
+		return nil, []byte(fmt.Sprintf("{\"pong\": %d}", ping.Ping)), nil
+	}
+
+	ticker := cryptoMarkets.NewTickerHuobi()
+	err = json.Unmarshal(data, ticker)
+	if err != nil {
+		return nil, nil, fmt.Errorf("huobi decode: %s", err)
+	}
+	symbol := ticker.GetSymbol()
+	if symbol.IsZero() {
+		return nil, nil, nil
+	}
+	return cryptoMarkets.NewTick(Huobi, symbol.String(), ticker.GetLastPrice()), nil, nil
+}
+
+func (huobi) LatestPrice(client *http.Client, pair string) (float64, error) {
+	latestPrice, err := LatestPriceHu(pair, client)
+	if err != nil {
+		return 0, err
+	}
+	if latestPrice.Status == "error" {
+		return 0, fmt.Errorf("huobi: no data on this pair: %s", pair)
+	}
+	return latestPrice.GetClosePrice(), nil
+}
+
+func (h huobi) PairExist(client *http.Client, pair string) bool {
+	_, err := h.LatestPrice(client, pair)
+	return err == nil
+}
+
+func (huobi) Symbols(client *http.Client) ([]cryptoMarkets.SymbolInfo, error) {
+	list, err := SymbolsHu(client)
+	if err != nil {
+		return nil, err
+	}
+	symbols := make([]cryptoMarkets.SymbolInfo, 0, len(list.Data))
+	for _, v := range list.Data {
+		symbols = append(symbols, cryptoMarkets.SymbolInfo{
+			Market:   Huobi,
+			Symbol:   strings.ToLower(v.Symbol),
+			Base:     strings.ToLower(v.BaseCurrency),
+			Quote:    strings.ToLower(v.QuoteCurrency),
+			TickSize: math.Pow10(-v.PricePrecision),
+			Status:   v.State,
+			Trading:  v.State == "online",
+		})
+	}
+	return symbols, nil
+}
+
+func ConnectHuobi(dialer *websocket.Dialer, pairs []string) (*websocket.Conn, error) {
+	conn, resp, err := dialer.Dial("wss://api-aws.huobi.pro/ws", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Huobi_Dialer_ERR: %s", err)
+		return nil, dialErr(resp, err)
+	}
+	for _, v := range parsePairsHu(pairs) {
+		err = conn.WriteJSON(map[string]string{
+			"sub": v,
+			"id":  v,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Huobi_WriteJSON: %s", err)
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func SubscribeHu(conn *websocket.Conn, pair string) error {
+	err := conn.WriteJSON(map[string]string{
+		"sub": parsePairHu(pair),
+		"id":  pair,
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func UnsubHu(conn *websocket.Conn, pair string) error {
+	err := conn.WriteJSON(map[string]string{
+		"unsub": parsePairHu(pair),
+		"id":    pair,
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func parsePairsHu(pairs []string) []string {
+	for i, v := range pairs {
+		pairs[i] = parsePairHu(v)
+	}
+	return pairs
+}
+
+func parsePairHu(pair string) string {
+	return fmt.Sprintf("market.%s.ticker", encodeSymbolHu(DefaultCatalog.ParseSymbol(pair)))
+}
+
+// encodeSymbolHu spells symbol the way Huobi does in channels and REST, e.g. "btcusdt"
+func encodeSymbolHu(symbol cryptoMarkets.Symbol) string {
+	return strings.ToLower(symbol.Base + symbol.Quote)
+}
+
+func LatestPriceHu(pairs string, client *http.Client) (*cryptoMarkets.LatestTickerHu, error) {
+	data, err := getData(client, fmt.Sprintf("https://api.huobi.pro/market/detail?symbol=%s", encodeSymbolHu(DefaultCatalog.ParseSymbol(pairs))))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "LatestPriceHu: %s", err)
+		return nil, err
+	}
+	latestPrice := &cryptoMarkets.LatestTickerHu{}
+	err = json.Unmarshal(data, latestPrice)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "LatestPriceHu: %s", err)
+		return nil, err
+	}
+	return latestPrice, nil
+}
+
+func SymbolsHu(client *http.Client) (*cryptoMarkets.SymbolsHu, error) {
+	data, err := getData(client, "https://api.huobi.pro/v1/common/symbols")
+	if err != nil {
+		return nil, fmt.Errorf("SymbolsHu: %s", err)
+	}
+	list := &cryptoMarkets.SymbolsHu{}
+	err = json.Unmarshal(data, list)
+	if err != nil {
+		return nil, fmt.Errorf("SymbolsHu: %s", err)
+	}
+	if list.Status != "ok" {
+		return nil, fmt.Errorf("SymbolsHu: status %s", list.Status)
+	}
+	return list, nil
+}
@@ -0,0 +1,222 @@
+package wrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	cryptoMarkets "github.com/HomelessHunter/CTC/wrapper/models/cryptoMarkets"
+	"github.com/gorilla/websocket"
+)
+
+const Kraken string = "kraken"
+
+// assetsKr maps assets to Kraken's own names, Symbol reads them back as aliases
+var assetsKr = map[string]string{"btc": "xbt", "doge": "xdg"}
+
+type kraken struct{}
+
+func (kraken) Name() string {
+	return Kraken
+}
+
+func (kraken) Decal() string {
+	return "&#128995;"
+}
+
+func (kraken) Connect(dialer *websocket.Dialer, pairs []string) (*websocket.Conn, error) {
+	return ConnectKraken(dialer, pairs)
+}
+
+func (kraken) Subscribe(conn *websocket.Conn, pair string) error {
+	return SubscribeKr(conn, pair)
+}
+
+func (kraken) Unsubscribe(conn *websocket.Conn, pair string) error {
+	return UnsubKr(conn, pair)
+}
+
+// Kraken sends events as objects and data as arrays: [channelID, ticker, "ticker", "XBT/USD"]
+func (kraken) Decode(data []byte) (*cryptoMarkets.Tick, []byte, error) {
+	if len(data) > 0 && data[0] == '{' {
+		event := &cryptoMarkets.EventKr{}
+		err := json.Unmarshal(data, event)
+		if err != nil {
+			return nil, nil, fmt.Errorf("kraken decode: %s", err)
+		}
+		if event.Status == "error" {
+			return nil, nil, fmt.Errorf("kraken %s %s: %s", event.Event, event.Pair, event.ErrorMessage)
+		}
+		return nil, nil, nil
+	}
+
+	var frame []json.RawMessage
+	err := json.Unmarshal(data, &frame)
+	if err != nil {
+		return nil, nil, fmt.Errorf("kraken decode: %s", err)
+	}
+	if len(frame) < 4 {
+		return nil, nil, fmt.Errorf("kraken decode: unexpected frame %s", data)
+	}
+	var channel, wsName string
+	if json.Unmarshal(frame[len(frame)-2], &channel) != nil || channel != "ticker" {
+		return nil, nil, nil
+	}
+	err = json.Unmarshal(frame[len(frame)-1], &wsName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("kraken decode: %s", err)
+	}
+	ticker := &cryptoMarkets.TickerDataKr{}
+	err = json.Unmarshal(frame[1], ticker)
+	if err != nil {
+		return nil, nil, fmt.Errorf("kraken decode: %s", err)
+	}
+	lastPrice, err := ticker.GetLastPrice()
+	if err != nil {
+		return nil, nil, fmt.Errorf("kraken decode: %s", err)
+	}
+	return cryptoMarkets.NewTick(Kraken, decodeSymbolKr(wsName).String(), lastPrice), nil, nil
+}
+
+func (kraken) LatestPrice(client *http.Client, pair string) (float64, error) {
+	latestPrice, err := LatestPriceKr(pair, client)
+	if err != nil {
+		return 0, err
+	}
+	if len(latestPrice.Error) > 0 {
+		return 0, fmt.Errorf("kraken: %s", strings.Join(latestPrice.Error, ", "))
+	}
+	// the result is keyed by Kraken's internal name, there's a single one
+	for _, v := range latestPrice.Result {
+		return v.GetLastPrice()
+	}
+	return 0, fmt.Errorf("kraken: no data on this pair: %s", pair)
+}
+
+func (k kraken) PairExist(client *http.Client, pair string) bool {
+	_, err := k.LatestPrice(client, pair)
+	return err == nil
+}
+
+func (kraken) Symbols(client *http.Client) ([]cryptoMarkets.SymbolInfo, error) {
+	list, err := AssetPairsKr(client)
+	if err != nil {
+		return nil, err
+	}
+	symbols := make([]cryptoMarkets.SymbolInfo, 0, len(list.Result))
+	for _, v := range list.Result {
+		// dark pool pairs (".d") have no websocket name
+		if v.WsName == "" {
+			continue
+		}
+		symbol := decodeSymbolKr(v.WsName)
+		tickSize, _ := strconv.ParseFloat(v.TickSize, 64)
+		symbols = append(symbols, cryptoMarkets.SymbolInfo{
+			Market:   Kraken,
+			Symbol:   symbol.String(),
+			Base:     symbol.Base,
+			Quote:    symbol.Quote,
+			TickSize: tickSize,
+			Status:   v.Status,
+			Trading:  v.Status == "online",
+		})
+	}
+	return symbols, nil
+}
+
+func ConnectKraken(dialer *websocket.Dialer, pairs []string) (*websocket.Conn, error) {
+	conn, resp, err := dialer.Dial("wss://ws.kraken.com", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Kraken_Dialer_ERR: %s", err)
+		return nil, dialErr(resp, err)
+	}
+	err = conn.WriteJSON(subscriptionKr("subscribe", parsePairsKr(pairs)))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Kraken_WriteJSON: %s", err)
+		return nil, err
+	}
+	return conn, nil
+}
+
+func SubscribeKr(conn *websocket.Conn, pair string) error {
+	return conn.WriteJSON(subscriptionKr("subscribe", []string{parsePairKr(pair)}))
+}
+
+func UnsubKr(conn *websocket.Conn, pair string) error {
+	return conn.WriteJSON(subscriptionKr("unsubscribe", []string{parsePairKr(pair)}))
+}
+
+func subscriptionKr(event string, wsNames []string) map[string]interface{} {
+	return map[string]interface{}{
+		"event":        event,
+		"pair":         wsNames,
+		"subscription": map[string]string{"name": "ticker"},
+	}
+}
+
+func parsePairsKr(pairs []string) []string {
+	wsNames := make([]string, len(pairs))
+	for i, v := range pairs {
+		wsNames[i] = parsePairKr(v)
+	}
+	return wsNames
+}
+
+func parsePairKr(pair string) string {
+	return encodeSymbolKr(DefaultCatalog.ParseSymbol(pair))
+}
+
+// encodeSymbolKr spells symbol the way Kraken's websocket does, e.g. "XBT/EUR",
+// REST takes the same name without the slash
+func encodeSymbolKr(symbol cryptoMarkets.Symbol) string {
+	return strings.ToUpper(assetKr(symbol.Base) + "/" + assetKr(symbol.Quote))
+}
+
+// decodeSymbolKr reads Kraken's websocket name, "XBT/EUR" is btc/eur
+func decodeSymbolKr(wsName string) cryptoMarkets.Symbol {
+	base, quote, _ := strings.Cut(wsName, "/")
+	return cryptoMarkets.NewSymbol(base, quote)
+}
+
+func assetKr(asset string) string {
+	if kr, ok := assetsKr[asset]; ok {
+		return kr
+	}
+	return asset
+}
+
+func LatestPriceKr(pair string, client *http.Client) (*cryptoMarkets.LatestTickerKr, error) {
+	name := strings.ReplaceAll(parsePairKr(pair), "/", "")
+	data, err := getData(client, fmt.Sprintf("https://api.kraken.com/0/public/Ticker?pair=%s", url.QueryEscape(name)))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "LatestPriceKr: %s", err)
+		return nil, err
+	}
+	latestPrice := &cryptoMarkets.LatestTickerKr{}
+	err = json.Unmarshal(data, latestPrice)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "LatestPriceKr: %s", err)
+		return nil, err
+	}
+	return latestPrice, nil
+}
+
+func AssetPairsKr(client *http.Client) (*cryptoMarkets.AssetPairsKr, error) {
+	data, err := getData(client, "https://api.kraken.com/0/public/AssetPairs")
+	if err != nil {
+		return nil, fmt.Errorf("AssetPairsKr: %s", err)
+	}
+	list := &cryptoMarkets.AssetPairsKr{}
+	err = json.Unmarshal(data, list)
+	if err != nil {
+		return nil, fmt.Errorf("AssetPairsKr: %s", err)
+	}
+	if len(list.Error) > 0 {
+		return nil, fmt.Errorf("AssetPairsKr: %s", strings.Join(list.Error, ", "))
+	}
+	return list, nil
+}
@@ -1,223 +1,244 @@
 package wrapper
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	cryptoMarkets "github.com/HomelessHunter/CTC/wrapper/models/cryptoMarkets"
 	"github.com/gorilla/websocket"
 )
 
-const Huobi string = "huobi"
-const Binance string = "binance"
-
-var ErrEmptyPing = errors.New("ping is 0")
-
-func TickerConnect(market string, pairs []string, dialer *websocket.Dialer, client *http.Client) (*websocket.Conn, error) {
-
-	if len(pairs) == 0 {
-		return nil, errors.New("pairs shouldn't be empty")
-	}
-
-	switch market {
-	case Huobi:
-		return ConnectHuobi(dialer, pairs)
-	case Binance:
-		return ConnectBinance(dialer, pairs)
-	}
+// Exchange is a market the bot can stream tickers from and query prices on.
+// The built-in ones are registered in priority order by the init func below.
+type Exchange interface {
+	Name() string
+	// Decal is an html entity shown next to prices from this market
+	Decal() string
+	Connect(dialer *websocket.Dialer, pairs []string) (*websocket.Conn, error)
+	Subscribe(conn *websocket.Conn, pair string) error
+	Unsubscribe(conn *websocket.Conn, pair string) error
+	// Decode parses a raw websocket frame. Control frames yield a nil tick,
+	// reply is non-nil when the exchange expects an answer (e.g. pong).
+	Decode(data []byte) (tick *cryptoMarkets.Tick, reply []byte, err error)
+	LatestPrice(client *http.Client, pair string) (float64, error)
+	PairExist(client *http.Client, pair string) bool
+}
 
-	return nil, fmt.Errorf("no suck market %s", market)
+// SymbolLister is implemented by exchanges that can list every pair they trade
+type SymbolLister interface {
+	Symbols(client *http.Client) ([]cryptoMarkets.SymbolInfo, error)
 }
 
-func Subscribe(conn *websocket.Conn, pair string, market string) error {
-	switch market {
-	case Huobi:
-		err := SubscribeHu(conn, pair)
-		if err != nil {
-			return err
-		}
-	case Binance:
-		err := SubscribeBi(conn, pair)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+// Pinger is implemented by exchanges that close connections without pings from the client,
+// hubs send Ping every PingInterval. Answers come back through Decode
+type Pinger interface {
+	PingInterval() time.Duration
+	Ping() []byte
 }
 
-func Unsubscribe(conn *websocket.Conn, pair string, market string) error {
-	switch market {
-	case Huobi:
-		err := UnsubHu(conn, pair)
-		if err != nil {
-			return err
-		}
-	case Binance:
-		err := UnsubBi(conn, pair)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+// StreamLimit is implemented by exchanges that cap how many pairs one connection streams,
+// hubs refuse to acquire pairs past MaxStreams
+type StreamLimit interface {
+	MaxStreams() int
 }
 
-func ConnectHuobi(dialer *websocket.Dialer, pairs []string) (*websocket.Conn, error) {
-	conn, _, err := dialer.Dial("wss://api-aws.huobi.pro/ws", nil)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Huobi_Dialer_ERR: %s", err)
-		return nil, err
-	}
-	for _, v := range parsePairsHu(pairs) {
-		err = conn.WriteJSON(map[string]string{
-			"sub": v,
-			"id":  v,
-		})
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Huobi_WriteJSON: %s", err)
-			return nil, err
-		}
-	}
-	return conn, nil
+// Derivative is implemented by markets that can trade contracts instead of spot pairs,
+// a pair without a pinned market resolves to them only when no spot market trades it
+type Derivative interface {
+	Derivative() bool
 }
 
-func CheckPingHuobi(conn *websocket.Conn, data []byte) error {
-	ping := cryptoMarkets.NewPing()
-	err := json.Unmarshal(data, ping)
-	if err != nil {
-		return err
-	}
-	if ping.Ping > 0 {
-		pongMsg := fmt.Sprintf("{\"pong\": %d}", ping.Ping)
-		conn.WriteMessage(websocket.TextMessage, []byte(pongMsg))
-	} else {
-		return ErrEmptyPing
+var (
+	ErrNoMarket = errors.New("no such market")
+	ErrNoPair   = errors.New("no data on this pair")
+	ErrHalted   = errors.New("trading is halted")
+)
+
+var (
+	exchangesMu sync.RWMutex
+	exchanges   = make(map[string]Exchange)
+	// exchangeNames keeps registration order so markets are probed predictably
+	exchangeNames []string
+)
+
+// Pairs without a pinned market are probed in this order: Huobi first as it always was,
+// then Binance and the other spot markets, derivatives last so a spot pair never resolves to a contract
+func init() {
+	for _, v := range []Exchange{
+		huobi{},
+		binance{},
+		coinbase{},
+		kraken{},
+		okx{name: OKX, instType: "SPOT"},
+		bybit{name: Bybit, category: "spot"},
+		binanceFutures{},
+		okx{name: OKXSwap, instType: "SWAP"},
+		bybit{name: BybitPerp, category: "linear"},
+	} {
+		RegisterExchange(v)
 	}
-	return nil
 }
 
-func SubscribeHu(conn *websocket.Conn, pair string) error {
-	err := conn.WriteJSON(map[string]string{
-		"sub": parsePairHu(pair),
-		"id":  pair,
-	})
-	if err != nil {
-		return err
+func RegisterExchange(exchange Exchange) {
+	exchangesMu.Lock()
+	defer exchangesMu.Unlock()
+	if _, ok := exchanges[exchange.Name()]; !ok {
+		exchangeNames = append(exchangeNames, exchange.Name())
 	}
-	return nil
+	exchanges[exchange.Name()] = exchange
 }
 
-func UnsubHu(conn *websocket.Conn, pair string) error {
-	err := conn.WriteJSON(map[string]string{
-		"unsub": parsePairHu(pair),
-		"id":    pair,
-	})
-	if err != nil {
-		return err
+func GetExchange(market string) (Exchange, error) {
+	exchangesMu.RLock()
+	defer exchangesMu.RUnlock()
+	exchange, ok := exchanges[market]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoMarket, market)
 	}
-	return nil
+	return exchange, nil
 }
 
-func parsePairsHu(pairs []string) []string {
-	for i, v := range pairs {
-		pairs[i] = parsePairHu(v)
+func Exchanges() []Exchange {
+	exchangesMu.RLock()
+	defer exchangesMu.RUnlock()
+	result := make([]Exchange, len(exchangeNames))
+	for i, v := range exchangeNames {
+		result[i] = exchanges[v]
 	}
-	return pairs
+	return result
 }
 
-func parsePairHu(pair string) string {
-	return fmt.Sprintf("market.%s.ticker", pair)
-}
+func TickerConnect(market string, pairs []string, dialer *websocket.Dialer, client *http.Client) (*websocket.Conn, error) {
 
-func ConnectBinance(dialer *websocket.Dialer, pairs []string) (*websocket.Conn, error) {
-	conn, _, err := dialer.Dial("wss://stream.binance.com:9443/stream", nil)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Binance_Dialer_ERR: %s", err)
-		return nil, err
+	if len(pairs) == 0 {
+		return nil, errors.New("pairs shouldn't be empty")
 	}
 
-	err = conn.WriteJSON(map[string]interface{}{
-		"method": "SUBSCRIBE",
-		"params": parsePairsBi(pairs),
-		"id":     0,
-	})
+	exchange, err := GetExchange(market)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Binance_WriteJSON: %s", err)
 		return nil, err
 	}
-	return conn, nil
+	return exchange.Connect(dialer, pairs)
 }
 
-func SubscribeBi(conn *websocket.Conn, pair string) error {
-	err := conn.WriteJSON(map[string]interface{}{
-		"method": "SUBSCRIBE",
-		"params": []string{parsePairBi(pair)},
-		"id":     0,
-	})
+func Subscribe(conn *websocket.Conn, pair string, market string) error {
+	exchange, err := GetExchange(market)
 	if err != nil {
 		return err
 	}
-	return nil
+	return exchange.Subscribe(conn, pair)
 }
 
-func UnsubBi(conn *websocket.Conn, pair string) error {
-	err := conn.WriteJSON(map[string]interface{}{
-		"method": "UNSUBSCRIBE",
-		"params": []string{parsePairBi(pair)},
-		"id":     0,
-	})
+func Unsubscribe(conn *websocket.Conn, pair string, market string) error {
+	exchange, err := GetExchange(market)
 	if err != nil {
 		return err
 	}
-	return nil
+	return exchange.Unsubscribe(conn, pair)
 }
 
-func parsePairsBi(pairs []string) []string {
-	for i, v := range pairs {
-		pairs[i] = parsePairBi(v)
+// getLatestPrice resolves input like getMarket and asks the market for the price
+func getLatestPrice(input string, client *http.Client) (float64, string, string, error) {
+	market, pair, err := getMarket(input, client)
+	if err != nil {
+		return 0, "", pair, err
 	}
-	return pairs
-}
-
-func parsePairBi(pair string) string {
-	return fmt.Sprintf("%s@ticker", pair)
+	exchange, err := GetExchange(market)
+	if err != nil {
+		return 0, "", pair, err
+	}
+	price, err := exchange.LatestPrice(client, pair)
+	if err != nil {
+		return 0, "", pair, err
+	}
+	return price, market, pair, nil
 }
 
-func getLatestPrice(pair string, client *http.Client) (float64, string, error) {
-	latestPriceHu, err := LatestPriceHu(pair, client)
-	if err == nil && latestPriceHu.Status != "error" {
-		return latestPriceHu.GetClosePrice(), Huobi, nil
+// getMarket resolves input to the market trading it and the pair as it's stored ("btcusdt").
+// "<market>:<pair>" pins the market, otherwise the first registered market trading the pair wins.
+// Pairs are looked up in DefaultCatalog, a market the catalog hasn't loaded yet is probed over REST
+func getMarket(input string, client *http.Client) (string, string, error) {
+	pinned, typed := splitPin(input)
+	pair := DefaultCatalog.ParseSymbol(typed).String()
+	candidates := spotFirst(Exchanges())
+	if pinned != "" {
+		exchange, err := GetExchange(pinned)
+		if err != nil {
+			return "", pair, err
+		}
+		candidates = []Exchange{exchange}
 	}
 
-	latestPriceBi, err := LatestPriceBi(pair, client)
-	if err == nil && latestPriceBi.Msg == "" {
-		price, err := latestPriceBi.GetLastPrice()
-		if err != nil {
-			return 0, "", fmt.Errorf("GetLatestPrice: %s", err)
+	halted := false
+	for _, exchange := range candidates {
+		err := pairTrading(exchange, pair, client)
+		if err == nil {
+			return exchange.Name(), pair, nil
+		}
+		if err == ErrHalted {
+			halted = true
 		}
-		return price, Binance, nil
 	}
+	if halted {
+		return "", pair, fmt.Errorf("%w: %s", ErrHalted, pair)
+	}
+	return "", pair, fmt.Errorf("%w: %s", ErrNoPair, pair)
+}
 
-	return 0, "", fmt.Errorf("no data on this pair: %s", pair)
+// spotFirst moves derivative markets after spot ones, keeping registration order otherwise
+func spotFirst(exchanges []Exchange) []Exchange {
+	sort.SliceStable(exchanges, func(i, j int) bool {
+		return !isDerivative(exchanges[i]) && isDerivative(exchanges[j])
+	})
+	return exchanges
 }
 
-func getMarket(pair string, client *http.Client) (string, error) {
+func isDerivative(exchange Exchange) bool {
+	derivative, ok := exchange.(Derivative)
+	return ok && derivative.Derivative()
+}
 
-	latestPriceHu, err := LatestPriceHu(pair, client)
-	if err == nil && latestPriceHu.Status != "error" {
-		return Huobi, nil
+// splitPin separates a pinned market from the pair, "binance:btcusdt" gives "binance" and "btcusdt"
+func splitPin(input string) (string, string) {
+	input = strings.ToLower(strings.TrimSpace(input))
+	market, pair, ok := strings.Cut(input, ":")
+	if !ok {
+		return "", input
 	}
+	return market, pair
+}
 
-	latestPriceBi, err := LatestPriceBi(pair, client)
-	if err == nil && latestPriceBi.Msg == "" {
-		return Binance, nil
+// pairTrading returns nil when exchange trades pair, ErrHalted when it lists pair but doesn't trade it
+func pairTrading(exchange Exchange, pair string, client *http.Client) error {
+	if !DefaultCatalog.Loaded(exchange.Name()) {
+		if exchange.PairExist(client, pair) {
+			return nil
+		}
+		return ErrNoPair
 	}
+	info, ok := DefaultCatalog.Lookup(exchange.Name(), pair)
+	if !ok {
+		return ErrNoPair
+	}
+	if !info.Trading {
+		return ErrHalted
+	}
+	return nil
+}
 
-	return "", fmt.Errorf("no data on this pair: %s", pair)
+// dialErr marks a handshake the exchange refused as permanent, 429 and 5xx are worth retrying
+func dialErr(resp *http.Response, err error) error {
+	if resp != nil && resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return fmt.Errorf("%w: handshake %s", ErrPermanent, resp.Status)
+	}
+	return err
 }
 
 func getData(client *http.Client, url string) ([]byte, error) {
@@ -234,33 +255,3 @@ func getData(client *http.Client, url string) ([]byte, error) {
 	defer resp.Body.Close()
 	return data, nil
 }
-
-func LatestPriceBi(pair string, client *http.Client) (*cryptoMarkets.LatestTickerBi, error) {
-	data, err := getData(client, fmt.Sprintf("https://api.binance.com/api/v3/ticker/24hr?symbol=%s", strings.ToUpper(pair)))
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "LatestPriceBi: %s", err)
-		return nil, err
-	}
-	latestPrice := &cryptoMarkets.LatestTickerBi{}
-	err = json.Unmarshal(data, latestPrice)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "LatestPriceBi: %s", err)
-		return nil, err
-	}
-	return latestPrice, nil
-}
-
-func LatestPriceHu(pairs string, client *http.Client) (*cryptoMarkets.LatestTickerHu, error) {
-	data, err := getData(client, fmt.Sprintf("https://api.huobi.pro/market/detail?symbol=%s", strings.ToLower(pairs)))
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "LatestPriceHu: %s", err)
-		return nil, err
-	}
-	latestPrice := &cryptoMarkets.LatestTickerHu{}
-	err = json.Unmarshal(data, latestPrice)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "LatestPriceHu: %s", err)
-		return nil, err
-	}
-	return latestPrice, nil
-}
@@ -166,3 +166,217 @@ func TestBinanceConnection(t *testing.T) {
 
 	<-closeCh
 }
+
+func TestExchangesRegistered(t *testing.T) {
+	for _, market := range []string{Binance, Huobi, Kraken, Coinbase, OKX, OKXSwap, Bybit, BybitPerp} {
+		exchange, err := GetExchange(market)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exchange.Name() != market {
+			t.Errorf("expected %s, got %s", market, exchange.Name())
+		}
+	}
+	if _, err := GetExchange("nasdaq"); err == nil {
+		t.Error("unknown market should return an error")
+	}
+
+	// tests may register fakes after the built-in markets
+	order := []string{Huobi, Binance, Coinbase, Kraken, OKX, Bybit, BinanceFutures, OKXSwap, BybitPerp}
+	registered := Exchanges()
+	for i, v := range order {
+		if registered[i].Name() != v {
+			t.Errorf("expected %s at %d, got %s", v, i, registered[i].Name())
+		}
+	}
+}
+
+func TestDecodeBinance(t *testing.T) {
+	exchange, _ := GetExchange(Binance)
+
+	tick, reply, err := exchange.Decode([]byte(`{"result":null,"id":0}`))
+	if err != nil || tick != nil || reply != nil {
+		t.Errorf("ack should be skipped: %v %v %v", tick, reply, err)
+	}
+
+	tick, _, err = exchange.Decode([]byte(`{"stream":"btcusdt@ticker","data":{"s":"BTCUSDT","c":"64000.5","P":"-2.5"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tick.Market != Binance || tick.Symbol != "btcusdt" || tick.LastPrice != 64000.5 || tick.Change24h != -0.025 {
+		t.Errorf("unexpected tick %+v", tick)
+	}
+}
+
+func TestDecodeBinanceFutures(t *testing.T) {
+	exchange, _ := GetExchange(BinanceFutures)
+
+	tick, reply, err := exchange.Decode([]byte(`{"result":null,"id":0}`))
+	if err != nil || tick != nil || reply != nil {
+		t.Errorf("ack should be skipped: %v %v %v", tick, reply, err)
+	}
+
+	tick, _, err = exchange.Decode([]byte(`{"stream":"btcusdt@markPrice@1s","data":{"e":"markPriceUpdate","E":1,"s":"BTCUSDT","p":"64010.1","i":"64000.0","r":"0.00050000","T":2}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tick.Market != BinanceFutures || tick.Symbol != "btcusdt" || tick.LastPrice != 64010.1 || tick.FundingRate != 0.0005 {
+		t.Errorf("unexpected tick %+v", tick)
+	}
+	if parsePairBf("BTC/USDT") != "btcusdt@markPrice@1s" {
+		t.Errorf("unexpected stream %s", parsePairBf("BTC/USDT"))
+	}
+}
+
+func TestDecodeHuobi(t *testing.T) {
+	exchange, _ := GetExchange(Huobi)
+	gzipped := func(s string) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(s))
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	tick, reply, err := exchange.Decode(gzipped(`{"ping": 1492420473027}`))
+	if err != nil || tick != nil {
+		t.Fatalf("ping should only produce a reply: %v %v", tick, err)
+	}
+	if string(reply) != `{"pong": 1492420473027}` {
+		t.Errorf("unexpected pong %s", reply)
+	}
+
+	tick, _, err = exchange.Decode(gzipped(`{"ch":"market.ethusdt.ticker","ts":1,"tick":{"lastPrice":3000.25}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tick.Market != Huobi || tick.Symbol != "ethusdt" || tick.LastPrice != 3000.25 {
+		t.Errorf("unexpected tick %+v", tick)
+	}
+
+	tick, _, err = exchange.Decode(gzipped(`{"ch":"market.ethusdt.depth.step0","ts":1}`))
+	if err != nil || tick != nil {
+		t.Errorf("channels other than tickers should be skipped: %v %v", tick, err)
+	}
+}
+
+func TestDecodeKraken(t *testing.T) {
+	exchange, _ := GetExchange(Kraken)
+
+	for _, event := range []string{`{"event":"heartbeat"}`, `{"event":"subscriptionStatus","status":"subscribed","pair":"XBT/EUR"}`} {
+		tick, reply, err := exchange.Decode([]byte(event))
+		if err != nil || tick != nil || reply != nil {
+			t.Errorf("%s should be skipped: %v %v %v", event, tick, reply, err)
+		}
+	}
+	_, _, err := exchange.Decode([]byte(`{"event":"subscriptionStatus","status":"error","pair":"FOO/EUR","errorMessage":"Currency pair not supported"}`))
+	if err == nil {
+		t.Error("a refused subscription should be reported")
+	}
+
+	tick, _, err := exchange.Decode([]byte(`[340,{"a":["61000.1",1,"1.0"],"b":["61000.0",2,"2.0"],"c":["61000.05","0.01"]},"ticker","XBT/EUR"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tick.Market != Kraken || tick.Symbol != "btceur" || tick.LastPrice != 61000.05 {
+		t.Errorf("unexpected tick %+v", tick)
+	}
+}
+
+func TestSymbolKraken(t *testing.T) {
+	if name := parsePairKr("btceur"); name != "XBT/EUR" {
+		t.Errorf("expected XBT/EUR, got %s", name)
+	}
+	if name := parsePairKr("doge/usd"); name != "XDG/USD" {
+		t.Errorf("expected XDG/USD, got %s", name)
+	}
+	// users typing Kraken's names get the common ones
+	if symbol := cryptoMarkets.ParseSymbol("XBTEUR"); symbol.String() != "btceur" {
+		t.Errorf("expected btceur, got %s", symbol)
+	}
+	if symbol := decodeSymbolKr("ETH/XBT"); symbol.Base != "eth" || symbol.Quote != "btc" {
+		t.Errorf("unexpected symbol %+v", symbol)
+	}
+}
+
+func TestDecodeCoinbase(t *testing.T) {
+	exchange, _ := GetExchange(Coinbase)
+
+	tick, reply, err := exchange.Decode([]byte(`{"type":"subscriptions","channels":[{"name":"ticker","product_ids":["BTC-USD"]}]}`))
+	if err != nil || tick != nil || reply != nil {
+		t.Errorf("ack should be skipped: %v %v %v", tick, reply, err)
+	}
+	if _, _, err = exchange.Decode([]byte(`{"type":"error","message":"Failed to subscribe","reason":"FOO-USD is not a valid product"}`)); err == nil {
+		t.Error("a refused subscription should be reported")
+	}
+
+	tick, _, err = exchange.Decode([]byte(`{"type":"ticker","sequence":1,"product_id":"BTC-USD","price":"64000.01","best_bid":"64000.00"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tick.Market != Coinbase || tick.Symbol != "btcusd" || tick.LastPrice != 64000.01 {
+		t.Errorf("unexpected tick %+v", tick)
+	}
+	if id := parsePairCb("ethusd"); id != "ETH-USD" {
+		t.Errorf("expected ETH-USD, got %s", id)
+	}
+}
+
+func TestDecodeOkx(t *testing.T) {
+	spot, _ := GetExchange(OKX)
+	swap, _ := GetExchange(OKXSwap)
+
+	for _, frame := range []string{"pong", `{"event":"subscribe","arg":{"channel":"tickers","instId":"BTC-USDT"}}`} {
+		tick, reply, err := spot.Decode([]byte(frame))
+		if err != nil || tick != nil || reply != nil {
+			t.Errorf("%s should be skipped: %v %v %v", frame, tick, reply, err)
+		}
+	}
+	if _, _, err := spot.Decode([]byte(`{"event":"error","code":"60018","msg":"Wrong URL or channel"}`)); err == nil {
+		t.Error("errors should be reported")
+	}
+
+	tick, _, err := swap.Decode([]byte(`{"arg":{"channel":"tickers","instId":"ETH-USDT-SWAP"},"data":[{"instType":"SWAP","instId":"ETH-USDT-SWAP","last":"3100.5"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tick.Market != OKXSwap || tick.Symbol != "ethusdt" || tick.LastPrice != 3100.5 {
+		t.Errorf("unexpected tick %+v", tick)
+	}
+	if id := parsePairOkx("ethusdt", true); id != "ETH-USDT-SWAP" {
+		t.Errorf("expected ETH-USDT-SWAP, got %s", id)
+	}
+	if pinger, ok := spot.(Pinger); !ok || string(pinger.Ping()) != "ping" {
+		t.Error("okx expects text pings")
+	}
+}
+
+func TestDecodeBybit(t *testing.T) {
+	perp, _ := GetExchange(BybitPerp)
+
+	for _, frame := range []string{`{"success":true,"ret_msg":"","op":"subscribe"}`, `{"success":true,"ret_msg":"pong","op":"ping"}`} {
+		tick, reply, err := perp.Decode([]byte(frame))
+		if err != nil || tick != nil || reply != nil {
+			t.Errorf("%s should be skipped: %v %v %v", frame, tick, reply, err)
+		}
+	}
+	if _, _, err := perp.Decode([]byte(`{"success":false,"ret_msg":"error:handler not found","op":"subscribe"}`)); err == nil {
+		t.Error("a refused subscription should be reported")
+	}
+	// a delta without the last price changes nothing
+	tick, _, err := perp.Decode([]byte(`{"topic":"tickers.BTCUSDT","type":"delta","data":{"symbol":"BTCUSDT","fundingRate":"0.0001"}}`))
+	if err != nil || tick != nil {
+		t.Errorf("delta without a price should be skipped: %v %v", tick, err)
+	}
+
+	tick, _, err = perp.Decode([]byte(`{"topic":"tickers.BTCUSDT","type":"snapshot","data":{"symbol":"BTCUSDT","lastPrice":"64010.5"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tick.Market != BybitPerp || tick.Symbol != "btcusdt" || tick.LastPrice != 64010.5 {
+		t.Errorf("unexpected tick %+v", tick)
+	}
+	if topic := parsePairBybit("btc/usdt"); topic != "tickers.BTCUSDT" {
+		t.Errorf("expected tickers.BTCUSDT, got %s", topic)
+	}
+}
@@ -0,0 +1,60 @@
+package models
+
+import "strings"
+
+// Symbol is a pair regardless of how a market spells it, exchanges encode it for their
+// streams and REST endpoints and decode what they send back into it
+type Symbol struct {
+	Base  string
+	Quote string
+}
+
+// knownQuotes split pairs typed without a separator, longer quotes go first so "fdusd" isn't read as "usd"
+var knownQuotes = []string{"fdusd", "usdt", "usdc", "busd", "tusd", "usdd", "btc", "eth", "bnb", "eur", "gbp", "try", "usd", "dai", "trx", "ht"}
+
+// aliases are names some markets give to assets known elsewhere by another one
+var aliases = map[string]string{"xbt": "btc", "xdg": "doge"}
+
+func NewSymbol(base, quote string) Symbol {
+	return Symbol{Base: alias(strings.ToLower(base)), Quote: alias(strings.ToLower(quote))}
+}
+
+func alias(asset string) string {
+	if common, ok := aliases[asset]; ok {
+		return common
+	}
+	return asset
+}
+
+// ParseSymbol reads "BTC/USDT", "btc-usdt", "btc_usdt" or "btcusdt". Without a separator the quote
+// is found by its suffix, a pair with an unknown quote keeps everything in Base. Aliases like
+// Kraken's "XBT" are read as the common name
+func ParseSymbol(input string) Symbol {
+	input = strings.ToLower(strings.TrimSpace(input))
+	if i := strings.IndexAny(input, "/-_"); i >= 0 {
+		return NewSymbol(input[:i], input[i+1:])
+	}
+	for _, quote := range knownQuotes {
+		if len(input) > len(quote) && strings.HasSuffix(input, quote) {
+			return NewSymbol(strings.TrimSuffix(input, quote), quote)
+		}
+	}
+	return NewSymbol(input, "")
+}
+
+// String is the key alerts are stored and watched by, e.g. "btcusdt"
+func (s Symbol) String() string {
+	return s.Base + s.Quote
+}
+
+// Display is how the pair is shown to users, e.g. "BTC/USDT"
+func (s Symbol) Display() string {
+	if s.Quote == "" {
+		return strings.ToUpper(s.Base)
+	}
+	return strings.ToUpper(s.Base + "/" + s.Quote)
+}
+
+func (s Symbol) IsZero() bool {
+	return s.Base == "" && s.Quote == ""
+}
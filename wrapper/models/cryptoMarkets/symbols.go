@@ -0,0 +1,68 @@
+package models
+
+// SymbolInfo is a pair listed on a market, Symbol is how the bot stores it (e.g. "btcusdt")
+type SymbolInfo struct {
+	Market string
+	Symbol string
+	Base   string
+	Quote  string
+	// TickSize is the smallest price step, 0 when the market doesn't say
+	TickSize float64
+	// Status is the market's own word for the pair state (e.g. "TRADING", "BREAK", "online")
+	Status string
+	// Trading is false while the pair is halted or delisted
+	Trading bool
+}
+
+// Pair splits the listed symbol into base and quote
+func (info SymbolInfo) Pair() Symbol {
+	return NewSymbol(info.Base, info.Quote)
+}
+
+// ExchangeInfoBi is the part of Binance's /api/v3/exchangeInfo the bot reads
+type ExchangeInfoBi struct {
+	Symbols []SymbolBi `json:"symbols"`
+}
+
+type SymbolBi struct {
+	Symbol     string     `json:"symbol"`
+	Status     string     `json:"status"`
+	BaseAsset  string     `json:"baseAsset"`
+	QuoteAsset string     `json:"quoteAsset"`
+	Filters    []FilterBi `json:"filters"`
+}
+
+// FilterBi is a trading rule of a symbol, only PRICE_FILTER's tickSize is read
+type FilterBi struct {
+	FilterType string `json:"filterType"`
+	TickSize   string `json:"tickSize"`
+}
+
+// ExchangeInfoBf is the part of Binance futures' /fapi/v1/exchangeInfo the bot reads
+type ExchangeInfoBf struct {
+	Symbols []SymbolBf `json:"symbols"`
+}
+
+// SymbolBf is a futures contract, ContractType tells perpetuals from quarterly ones
+type SymbolBf struct {
+	Symbol       string     `json:"symbol"`
+	ContractType string     `json:"contractType"`
+	Status       string     `json:"status"`
+	BaseAsset    string     `json:"baseAsset"`
+	QuoteAsset   string     `json:"quoteAsset"`
+	Filters      []FilterBi `json:"filters"`
+}
+
+// SymbolsHu is Huobi's /v1/common/symbols
+type SymbolsHu struct {
+	Status string     `json:"status"`
+	Data   []SymbolHu `json:"data"`
+}
+
+type SymbolHu struct {
+	Symbol         string `json:"symbol"`
+	State          string `json:"state"`
+	BaseCurrency   string `json:"base-currency"`
+	QuoteCurrency  string `json:"quote-currency"`
+	PricePrecision int    `json:"price-precision"`
+}
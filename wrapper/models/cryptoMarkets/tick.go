@@ -0,0 +1,16 @@
+package models
+
+// Tick is an exchange-agnostic price update decoded from a ticker stream
+type Tick struct {
+	Market    string
+	Symbol    string
+	LastPrice float64
+	// FundingRate is set by perpetual markets that stream it, as a fraction per funding period
+	FundingRate float64
+	// Change24h is the move over the last 24 hours as a fraction, set by markets that stream it
+	Change24h float64
+}
+
+func NewTick(market, symbol string, lastPrice float64) *Tick {
+	return &Tick{Market: market, Symbol: symbol, LastPrice: lastPrice}
+}
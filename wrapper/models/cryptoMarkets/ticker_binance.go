@@ -2,7 +2,6 @@ package models
 
 import (
 	"strconv"
-	"strings"
 )
 
 type TickerBinance struct {
@@ -18,8 +17,17 @@ func (ticker *TickerBinance) GetLastPrice() (float64, error) {
 	return strconv.ParseFloat(ticker.Data.LastPrice, 64)
 }
 
-func (ticker *TickerBinance) GetSymbol() string {
-	return strings.ToLower(ticker.Data.Symbol)
+// GetChange24h reads the rolling 24h change, Binance sends it in percent
+func (ticker *TickerBinance) GetChange24h() (float64, error) {
+	percent, err := strconv.ParseFloat(ticker.Data.PriceChangePercent, 64)
+	if err != nil {
+		return 0, err
+	}
+	return percent / 100, nil
+}
+
+func (ticker *TickerBinance) GetSymbol() Symbol {
+	return ParseSymbol(ticker.Data.Symbol)
 }
 
 type StreamDataBi struct {
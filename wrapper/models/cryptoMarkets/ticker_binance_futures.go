@@ -0,0 +1,51 @@
+package models
+
+import "strconv"
+
+// TickerBf is a frame of Binance USDⓈ-M futures' combined markPrice stream
+type TickerBf struct {
+	Stream string      `json:"stream"`
+	Data   MarkPriceBf `json:"data"`
+}
+
+func (ticker *TickerBf) GetSymbol() Symbol {
+	return ParseSymbol(ticker.Data.Symbol)
+}
+
+type MarkPriceBf struct {
+	Type            string `json:"e"`
+	Time            int64  `json:"E"`
+	Symbol          string `json:"s"`
+	MarkPrice       string `json:"p"`
+	IndexPrice      string `json:"i"`
+	FundingRate     string `json:"r"`
+	NextFundingTime int64  `json:"T"`
+}
+
+func (data *MarkPriceBf) GetMarkPrice() (float64, error) {
+	return strconv.ParseFloat(data.MarkPrice, 64)
+}
+
+// GetFundingRate is a fraction, "0.00010000" is 0.01% per funding period
+func (data *MarkPriceBf) GetFundingRate() (float64, error) {
+	return strconv.ParseFloat(data.FundingRate, 64)
+}
+
+// PremiumIndexBf is /fapi/v1/premiumIndex for a single symbol, Code and Msg are set on errors
+type PremiumIndexBf struct {
+	Symbol          string `json:"symbol"`
+	MarkPrice       string `json:"markPrice"`
+	IndexPrice      string `json:"indexPrice"`
+	LastFundingRate string `json:"lastFundingRate"`
+	NextFundingTime int64  `json:"nextFundingTime"`
+	Code            int    `json:"code"`
+	Msg             string `json:"msg"`
+}
+
+func (index *PremiumIndexBf) GetMarkPrice() (float64, error) {
+	return strconv.ParseFloat(index.MarkPrice, 64)
+}
+
+func (index *PremiumIndexBf) GetFundingRate() (float64, error) {
+	return strconv.ParseFloat(index.LastFundingRate, 64)
+}
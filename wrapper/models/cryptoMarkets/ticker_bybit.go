@@ -0,0 +1,53 @@
+package models
+
+import "strconv"
+
+// TickerBybit is a frame of Bybit's public websocket. Op frames answer subscribe and ping,
+// topic frames carry the ticker. Perpetuals send deltas that may leave LastPrice out
+type TickerBybit struct {
+	Op      string          `json:"op"`
+	Success *bool           `json:"success"`
+	RetMsg  string          `json:"ret_msg"`
+	Topic   string          `json:"topic"`
+	Type    string          `json:"type"`
+	Data    TickerDataBybit `json:"data"`
+}
+
+type TickerDataBybit struct {
+	Symbol    string `json:"symbol"`
+	LastPrice string `json:"lastPrice"`
+}
+
+func (ticker *TickerDataBybit) GetLastPrice() (float64, error) {
+	return strconv.ParseFloat(ticker.LastPrice, 64)
+}
+
+// LatestTickerBybit is /v5/market/tickers, RetCode is 0 on success
+type LatestTickerBybit struct {
+	RetCode int    `json:"retCode"`
+	RetMsg  string `json:"retMsg"`
+	Result  struct {
+		List []TickerDataBybit `json:"list"`
+	} `json:"result"`
+}
+
+// InstrumentsBybit is a page of /v5/market/instruments-info
+type InstrumentsBybit struct {
+	RetCode int    `json:"retCode"`
+	RetMsg  string `json:"retMsg"`
+	Result  struct {
+		List           []InstrumentBybit `json:"list"`
+		NextPageCursor string            `json:"nextPageCursor"`
+	} `json:"result"`
+}
+
+type InstrumentBybit struct {
+	Symbol       string `json:"symbol"`
+	BaseCoin     string `json:"baseCoin"`
+	QuoteCoin    string `json:"quoteCoin"`
+	Status       string `json:"status"`
+	ContractType string `json:"contractType"`
+	PriceFilter  struct {
+		TickSize string `json:"tickSize"`
+	} `json:"priceFilter"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import "strconv"
+
+// TickerCb is a frame of Coinbase's websocket feed, only "ticker" frames carry a price
+type TickerCb struct {
+	Type      string `json:"type"`
+	ProductID string `json:"product_id"`
+	Price     string `json:"price"`
+	Message   string `json:"message"`
+	Reason    string `json:"reason"`
+}
+
+func (ticker *TickerCb) GetLastPrice() (float64, error) {
+	return strconv.ParseFloat(ticker.Price, 64)
+}
+
+// LatestTickerCb is /products/<id>/ticker, Message is set instead of Price for unknown products
+type LatestTickerCb struct {
+	Price   string `json:"price"`
+	Message string `json:"message"`
+}
+
+func (latestTicker *LatestTickerCb) GetLastPrice() (float64, error) {
+	return strconv.ParseFloat(latestTicker.Price, 64)
+}
+
+// ProductCb is an entry of /products
+type ProductCb struct {
+	ID              string `json:"id"`
+	BaseCurrency    string `json:"base_currency"`
+	QuoteCurrency   string `json:"quote_currency"`
+	QuoteIncrement  string `json:"quote_increment"`
+	Status          string `json:"status"`
+	TradingDisabled bool   `json:"trading_disabled"`
+}
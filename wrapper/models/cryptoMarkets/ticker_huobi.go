@@ -16,12 +16,13 @@ func (ticker *TickerHuobi) GetLastPrice() float64 {
 	return ticker.StreamDataHu.LastPrice
 }
 
-func (ticker *TickerHuobi) GetSymbol() string {
-	if ticker.Channel == "" {
-		return ""
+// GetSymbol reads the pair out of a "market.<symbol>.ticker" channel
+func (ticker *TickerHuobi) GetSymbol() Symbol {
+	parts := strings.Split(ticker.Channel, ".")
+	if len(parts) != 3 || parts[0] != "market" {
+		return Symbol{}
 	}
-	symbol := strings.Split(ticker.Channel, ".")[1]
-	return symbol
+	return ParseSymbol(parts[1])
 }
 
 type StreamDataHu struct {
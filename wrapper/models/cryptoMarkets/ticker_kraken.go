@@ -0,0 +1,46 @@
+package models
+
+import (
+	"errors"
+	"strconv"
+)
+
+// TickerDataKr is a ticker of Kraken's websocket and REST API, prices come as strings
+type TickerDataKr struct {
+	// Close is the last trade, [price, lot volume]
+	Close []string `json:"c"`
+}
+
+func (ticker *TickerDataKr) GetLastPrice() (float64, error) {
+	if len(ticker.Close) == 0 {
+		return 0, errors.New("no last trade in ticker")
+	}
+	return strconv.ParseFloat(ticker.Close[0], 64)
+}
+
+// EventKr is a non-data frame of Kraken's websocket: heartbeat, systemStatus, subscriptionStatus
+type EventKr struct {
+	Event        string `json:"event"`
+	Status       string `json:"status"`
+	Pair         string `json:"pair"`
+	ErrorMessage string `json:"errorMessage"`
+}
+
+// LatestTickerKr is /0/public/Ticker, result is keyed by Kraken's own pair name (e.g. "XXBTZUSD")
+type LatestTickerKr struct {
+	Error  []string                `json:"error"`
+	Result map[string]TickerDataKr `json:"result"`
+}
+
+// AssetPairsKr is /0/public/AssetPairs
+type AssetPairsKr struct {
+	Error  []string               `json:"error"`
+	Result map[string]AssetPairKr `json:"result"`
+}
+
+type AssetPairKr struct {
+	Altname  string `json:"altname"`
+	WsName   string `json:"wsname"`
+	TickSize string `json:"tick_size"`
+	Status   string `json:"status"`
+}
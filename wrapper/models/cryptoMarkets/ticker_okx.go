@@ -0,0 +1,50 @@
+package models
+
+import "strconv"
+
+// TickerOkx is a frame of OKX's public websocket, events carry Event and data frames carry Data
+type TickerOkx struct {
+	Event string          `json:"event"`
+	Code  string          `json:"code"`
+	Msg   string          `json:"msg"`
+	Arg   ArgOkx          `json:"arg"`
+	Data  []TickerDataOkx `json:"data"`
+}
+
+type ArgOkx struct {
+	Channel string `json:"channel"`
+	InstID  string `json:"instId"`
+}
+
+type TickerDataOkx struct {
+	InstType string `json:"instType"`
+	InstID   string `json:"instId"`
+	Last     string `json:"last"`
+}
+
+func (ticker *TickerDataOkx) GetLastPrice() (float64, error) {
+	return strconv.ParseFloat(ticker.Last, 64)
+}
+
+// LatestTickerOkx is /api/v5/market/ticker, Code is "0" on success
+type LatestTickerOkx struct {
+	Code string          `json:"code"`
+	Msg  string          `json:"msg"`
+	Data []TickerDataOkx `json:"data"`
+}
+
+// InstrumentsOkx is /api/v5/public/instruments
+type InstrumentsOkx struct {
+	Code string          `json:"code"`
+	Msg  string          `json:"msg"`
+	Data []InstrumentOkx `json:"data"`
+}
+
+// InstrumentOkx is named by InstID ("BTC-USDT", "BTC-USDT-SWAP"), swaps leave BaseCcy and QuoteCcy empty
+type InstrumentOkx struct {
+	InstID   string `json:"instId"`
+	BaseCcy  string `json:"baseCcy"`
+	QuoteCcy string `json:"quoteCcy"`
+	TickSz   string `json:"tickSz"`
+	State    string `json:"state"`
+}
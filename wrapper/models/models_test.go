@@ -1,10 +1,15 @@
 package models
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	telegram "github.com/HomelessHunter/CTC/wrapper/models/telegram"
+	"github.com/HomelessHunter/CTC/wrapper/models/telegram/telegramtest"
 )
 
 func TestUser(t *testing.T) {
@@ -18,3 +23,76 @@ func TestUser(t *testing.T) {
 		fmt.Println(user)
 	}
 }
+
+func TestClientSendMessage(t *testing.T) {
+	srv := telegramtest.NewServer()
+	defer srv.Close()
+
+	sendObj, _ := telegram.NewSendMsgObj(telegram.WithSendChatId(42), telegram.WithSendText("hi"))
+	msg, err := srv.TGClient().SendMessage(context.TODO(), sendObj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Id != 1 || msg.FromChatID() != 42 || msg.Text != "hi" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	sent := srv.Sent()
+	if len(sent) != 1 || sent[0].ChatId != 42 {
+		t.Errorf("unexpected calls %+v", sent)
+	}
+}
+
+func TestClientAPIError(t *testing.T) {
+	srv := telegramtest.NewServer()
+	defer srv.Close()
+
+	srv.Fail("deleteMessage", 400, "Bad Request: message to delete not found", 0)
+	srv.Fail("answerCallbackQuery", 429, "Too Many Requests: retry after 3", 3)
+	client := srv.TGClient()
+
+	err := client.DeleteMessage(context.TODO(), 1, 1)
+	apiErr := &telegram.APIError{}
+	if !errors.As(err, &apiErr) || apiErr.Code != 400 || apiErr.Method != "deleteMessage" {
+		t.Errorf("unexpected error %v", err)
+	}
+	err = client.AnswerCallbackQuery(context.TODO(), &telegram.CallbackAnswer{Id: "1"})
+	if !errors.As(err, &apiErr) || apiErr.Code != 429 || apiErr.Parameters.RetryAfter != 3 {
+		t.Errorf("unexpected error %v", err)
+	}
+	if telegram.IsForbidden(err) {
+		t.Error("429 isn't forbidden")
+	}
+	// failures are one-shot
+	if err = client.DeleteMessage(context.TODO(), 1, 1); err != nil {
+		t.Error(err)
+	}
+	srv.Fail("sendMessage", 403, "Forbidden: bot was blocked by the user", 0)
+	_, err = client.SendMessage(context.TODO(), &telegram.SendMsgObj{ChatId: 1, Text: "hi"})
+	if !telegram.IsForbidden(err) {
+		t.Errorf("blocked bot should be forbidden, got %v", err)
+	}
+
+	bad, _ := telegram.NewClient("wrong", telegram.WithClientBaseURL(srv.URL))
+	if err = bad.DeleteWebhook(context.TODO()); !errors.As(err, &apiErr) || apiErr.Code != 401 {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestClientGetUpdates(t *testing.T) {
+	srv := telegramtest.NewServer()
+	defer srv.Close()
+
+	srv.PushUpdates(telegram.Update{Id: 1}, telegram.Update{Id: 2})
+	updates, err := srv.TGClient().GetUpdates(context.TODO(), 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 1 || updates[0].Id != 2 {
+		t.Errorf("unexpected updates %+v", updates)
+	}
+	params := map[string]int{}
+	json.Unmarshal(srv.Calls("getUpdates")[0].Body, &params)
+	if params["offset"] != 2 || params["timeout"] != 1 {
+		t.Errorf("unexpected params %v", params)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	dbModels "github.com/HomelessHunter/CTC/db/models"
 )
@@ -12,16 +13,94 @@ type Session struct {
 	mu          sync.RWMutex
 	alerts      map[int64]*[]dbModels.Alert
 	alertsCount int
-	markets     map[int64]*[]market
+	// markets tells whether a user has alerts on a market
+	markets map[int64]map[string]bool
+	chats   map[int64]int64
+	// watchers counts alerts per user for every market pair
+	watchers map[string]map[int64]int
 }
 
-type market struct {
-	market string
-	exist  bool
+// Signal is an alert that fired on a tick
+type Signal struct {
+	UserID int64
+	ChatID int64
+	Alert  dbModels.Alert
 }
 
 func NewSession() *Session {
-	return &Session{alerts: make(map[int64]*[]dbModels.Alert), alertsCount: 0, markets: make(map[int64]*[]market)}
+	return &Session{
+		alerts:      make(map[int64]*[]dbModels.Alert),
+		alertsCount: 0,
+		markets:     make(map[int64]map[string]bool),
+		chats:       make(map[int64]int64),
+		watchers:    make(map[string]map[int64]int),
+	}
+}
+
+func pairKey(market, pair string) string {
+	return market + ":" + pair
+}
+
+func (s *Session) SetChatID(id int64, chatID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chats[id] = chatID
+}
+
+func (s *Session) ChatID(id int64) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.chats[id]
+}
+
+// UserByChat finds the user owning chatID, in private chats both ids are the same
+func (s *Session) UserByChat(chatID int64) (int64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.chats[chatID] == chatID {
+		return chatID, true
+	}
+	for id, chat := range s.chats {
+		if chat == chatID {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// Fire runs check against every alert on market pair across all users
+// and returns the alerts it reported as fired. check may modify the alert.
+func (s *Session) Fire(market, pair string, check func(alert *dbModels.Alert) bool) []Signal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var signals []Signal
+	for id := range s.watchers[pairKey(market, pair)] {
+		alerts := *s.alerts[id]
+		for i := range alerts {
+			if alerts[i].Market != market || alerts[i].Pair != pair {
+				continue
+			}
+			if check(&alerts[i]) {
+				signals = append(signals, Signal{UserID: id, ChatID: s.chats[id], Alert: alerts[i]})
+			}
+		}
+	}
+	return signals
+}
+
+// watch must be called with mu held
+func (s *Session) watch(id int64, alert dbModels.Alert, delta int) {
+	key := pairKey(alert.Market, alert.Pair)
+	if s.watchers[key] == nil {
+		s.watchers[key] = make(map[int64]int)
+	}
+	s.watchers[key][id] += delta
+	if s.watchers[key][id] <= 0 {
+		delete(s.watchers[key], id)
+	}
+	if len(s.watchers[key]) == 0 {
+		delete(s.watchers, key)
+	}
 }
 
 func (s *Session) Alerts() map[int64]*[]dbModels.Alert {
@@ -30,6 +109,7 @@ func (s *Session) Alerts() map[int64]*[]dbModels.Alert {
 	return s.alerts
 }
 
+// AlertsByID returns a copy of the user's alerts, Fire updates the session's own while ticks come in
 func (s *Session) AlertsByID(id int64) []dbModels.Alert {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -37,7 +117,7 @@ func (s *Session) AlertsByID(id int64) []dbModels.Alert {
 	if alerts == nil {
 		return nil
 	}
-	return *alerts
+	return append([]dbModels.Alert(nil), (*alerts)...)
 }
 
 func (s *Session) AlertsByMarket(id int64, market string) (alerts []dbModels.Alert, newAlerts []dbModels.Alert) {
@@ -75,57 +155,48 @@ func (s *Session) AlertsCount() int {
 func (s *Session) InitMarketsByID(id int64) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	fmt.Println(id)
 	if s.markets[id] == nil {
-		s.markets[id] = &[]market{
-			{market: "binance", exist: false},
-			{market: "huobi", exist: false},
-		}
+		s.markets[id] = make(map[string]bool)
 	}
 }
 
 func (s *Session) SetMarketByID(id int64, market string, exist bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	markets := *s.markets[id]
-	switch market {
-	case "binance":
-		markets[0].exist = exist
-	case "huobi":
-		markets[1].exist = exist
+	if s.markets[id] == nil {
+		s.markets[id] = make(map[string]bool)
 	}
+	s.markets[id][market] = exist
 }
 
 func (s *Session) SetMarketsByID(id int64, exist bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	markets := *s.markets[id]
-	for i := range markets {
-		markets[i].exist = exist
+	for k := range s.markets[id] {
+		s.markets[id][k] = exist
 	}
 }
 
 func (s *Session) MarketExist(id int64, market string) bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	markets := *s.markets[id]
-	switch market {
-	case "binance":
-		return markets[0].exist
-	case "huobi":
-		return markets[1].exist
-	}
-	return false
+	return s.markets[id][market]
 }
 
-func (s *Session) MarketsByID(id int64) ([]market, error) {
+func (s *Session) MarketsByID(id int64) ([]string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	markets := *s.markets[id]
-	if markets[0].market == "" {
-		return nil, errors.New("")
+	markets, ok := s.markets[id]
+	if !ok {
+		return nil, errors.New("markets aren't initialized")
+	}
+	result := make([]string, 0, len(markets))
+	for k, v := range markets {
+		if v {
+			result = append(result, k)
+		}
 	}
-	return markets, nil
+	return result, nil
 }
 
 // func (s *Session) DeleteMarkets(id int64) {
@@ -143,6 +214,7 @@ func (s *Session) AddAlerts(id int64, alerts ...dbModels.Alert) {
 	}
 	for i := range alerts {
 		alerts[i].Connected = true
+		s.watch(id, alerts[i], 1)
 	}
 	*s.alerts[id] = append(*s.alerts[id], alerts...)
 	s.alertsCount += len(alerts)
@@ -161,6 +233,7 @@ func (s *Session) DeleteAlert(id int64, index int) error {
 	s.mu.RUnlock()
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.watch(id, alerts[index], -1)
 	oldAlerts := append(alerts[:index], alerts[index+1:]...)
 	newAlerts := make([]dbModels.Alert, len(oldAlerts))
 	copy(newAlerts, oldAlerts)
@@ -171,10 +244,79 @@ func (s *Session) DeleteAlert(id int64, index int) error {
 	return nil
 }
 
+// RemoveAlert deletes the user's alert with the given hex and returns it
+func (s *Session) RemoveAlert(id int64, hex string) (dbModels.Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	alertsP, ok := s.alerts[id]
+	if !ok {
+		return dbModels.Alert{}, fmt.Errorf("RemoveAlert: no alerts for %d", id)
+	}
+	alerts := *alertsP
+	for i, v := range alerts {
+		if v.Hex != hex {
+			continue
+		}
+		s.watch(id, v, -1)
+		newAlerts := make([]dbModels.Alert, 0, len(alerts)-1)
+		newAlerts = append(newAlerts, alerts[:i]...)
+		newAlerts = append(newAlerts, alerts[i+1:]...)
+		*alertsP = newAlerts
+		s.alertsCount -= 1
+		return v, nil
+	}
+	return dbModels.Alert{}, fmt.Errorf("RemoveAlert: no alert %s for %d", hex, id)
+}
+
+// SetTargetPrice moves the user's alert to a new target in place, the pair stays watched.
+// The alert's cooldown starts over and the updated alert is returned
+func (s *Session) SetTargetPrice(id int64, hex string, price float64) (dbModels.Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	alertsP, ok := s.alerts[id]
+	if !ok {
+		return dbModels.Alert{}, fmt.Errorf("SetTargetPrice: no alerts for %d", id)
+	}
+	alerts := *alertsP
+	for i := range alerts {
+		if alerts[i].Hex != hex {
+			continue
+		}
+		alerts[i].TargetPrice = price
+		alerts[i].LastSignal = time.Time{}
+		return alerts[i], nil
+	}
+	return dbModels.Alert{}, fmt.Errorf("SetTargetPrice: no alert %s for %d", hex, id)
+}
+
+// TakeAlerts removes all alerts of the user and returns them, so only one caller gets to release them
+func (s *Session) TakeAlerts(id int64) []dbModels.Alert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	alertsP, ok := s.alerts[id]
+	if !ok {
+		return nil
+	}
+	alerts := *alertsP
+	for _, v := range alerts {
+		s.watch(id, v, -1)
+	}
+	delete(s.alerts, id)
+	for market := range s.markets[id] {
+		s.markets[id][market] = false
+	}
+	s.alertsCount -= len(alerts)
+	return alerts
+}
+
 func (s *Session) DeleteAlerts(id int64, length int) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	fmt.Println(s.alerts)
+	if alerts, ok := s.alerts[id]; ok {
+		for _, v := range *alerts {
+			s.watch(id, v, -1)
+		}
+	}
 	delete(s.alerts, id)
 	if s.alertsCount > 0 {
 		s.alertsCount -= length
@@ -18,16 +18,21 @@ func TestMapAllocation(t *testing.T) {
 	session := NewSession()
 	printAlloc()
 
+	var alertT, alertT1 *dbModels.Alert
 	for i := 0; i < 1_000_000; i++ {
 		alert, _ := dbModels.NewAlert(dbModels.WithPair(fmt.Sprint(i)), dbModels.WithMarket("binance"), dbModels.WithTargetPrice(58000.23))
 
 		session.AddAlerts(1, *alert)
+		switch i {
+		case 100:
+			alertT = alert
+		case 876:
+			alertT1 = alert
+		}
 	}
 	printAlloc()
 
 	// fmt.Println(len(*session.alerts[1]))
-	alertT, _ := dbModels.NewAlert(dbModels.WithPair(fmt.Sprint(100)), dbModels.WithMarket("binance"))
-	alertT1, _ := dbModels.NewAlert(dbModels.WithPair(fmt.Sprint(876)), dbModels.WithMarket("binance"))
 	alerts := *session.alerts[1]
 	i, err := alertT.SortNFind(alerts)
 	if err != nil {
@@ -116,3 +121,101 @@ func TestDeleteAlerts(t *testing.T) {
 // 	session.DeleteMarket(1, "huobi")
 // 	fmt.Println(session.markets[1])
 // }
+
+func TestFire(t *testing.T) {
+	session := NewSession()
+	session.SetChatID(1, 10)
+	session.SetChatID(2, 20)
+	session.AddAlerts(1, dbModels.Alert{Market: "binance", Pair: "btcusdt", TargetPrice: 60000})
+	session.AddAlerts(2, dbModels.Alert{Market: "binance", Pair: "btcusdt", TargetPrice: 70000},
+		dbModels.Alert{Market: "huobi", Pair: "btcusdt", TargetPrice: 60000})
+
+	signals := session.Fire("binance", "btcusdt", func(alert *dbModels.Alert) bool {
+		return alert.TargetPrice == 60000
+	})
+	if len(signals) != 1 || signals[0].UserID != 1 || signals[0].ChatID != 10 {
+		t.Errorf("unexpected signals %v", signals)
+	}
+
+	session.DeleteAlerts(1, 1)
+	signals = session.Fire("binance", "btcusdt", func(alert *dbModels.Alert) bool { return true })
+	if len(signals) != 1 || signals[0].UserID != 2 {
+		t.Errorf("unexpected signals %v", signals)
+	}
+}
+
+func TestAlertsByIDCopies(t *testing.T) {
+	session := NewSession()
+	session.AddAlerts(1, dbModels.Alert{Market: "binance", Pair: "btcusdt", Hex: "a"})
+
+	alerts := session.AlertsByID(1)
+	session.Fire("binance", "btcusdt", func(alert *dbModels.Alert) bool {
+		alert.LastPrice = 70000
+		return false
+	})
+	if alerts[0].LastPrice != 0 {
+		t.Error("Fire shouldn't change alerts handed out by AlertsByID")
+	}
+	if session.AlertsByID(1)[0].LastPrice != 70000 {
+		t.Error("Fire should update the session's alerts")
+	}
+}
+
+func TestRemoveAlert(t *testing.T) {
+	session := NewSession()
+	session.AddAlerts(1, dbModels.Alert{Market: "binance", Pair: "btcusdt", Hex: "a"}, dbModels.Alert{Market: "binance", Pair: "ethusdt", Hex: "b"})
+
+	alert, err := session.RemoveAlert(1, "a")
+	if err != nil || alert.Pair != "btcusdt" {
+		t.Fatalf("unexpected alert %v (%v)", alert, err)
+	}
+	if alerts := session.AlertsByID(1); len(alerts) != 1 || alerts[0].Hex != "b" {
+		t.Errorf("unexpected alerts %v", alerts)
+	}
+	if signals := session.Fire("binance", "btcusdt", func(*dbModels.Alert) bool { return true }); len(signals) != 0 {
+		t.Errorf("removed alert still fires %v", signals)
+	}
+	if _, err = session.RemoveAlert(1, "a"); err == nil {
+		t.Error("removing twice should fail")
+	}
+}
+
+func TestTakeAlerts(t *testing.T) {
+	session := NewSession()
+	session.SetChatID(1, 10)
+	session.SetMarketByID(1, "binance", true)
+	session.AddAlerts(1, dbModels.Alert{Market: "binance", Pair: "btcusdt", Hex: "a"}, dbModels.Alert{Market: "binance", Pair: "ethusdt", Hex: "b"})
+
+	if id, ok := session.UserByChat(10); !ok || id != 1 {
+		t.Errorf("chat 10 belongs to 1, got %d", id)
+	}
+	if alerts := session.TakeAlerts(1); len(alerts) != 2 {
+		t.Fatalf("unexpected alerts %v", alerts)
+	}
+	if alerts := session.TakeAlerts(1); len(alerts) != 0 {
+		t.Errorf("alerts should be taken once, got %v", alerts)
+	}
+	if session.AlertsCount() != 0 || session.MarketExist(1, "binance") {
+		t.Error("taken alerts are still counted")
+	}
+	if signals := session.Fire("binance", "btcusdt", func(*dbModels.Alert) bool { return true }); len(signals) != 0 {
+		t.Errorf("taken alert still fires %v", signals)
+	}
+}
+
+func TestSessionSetTargetPrice(t *testing.T) {
+	session := NewSession()
+	session.AddAlerts(1, dbModels.Alert{Market: "binance", Pair: "btcusdt", Hex: "a", TargetPrice: 60000, LastPrice: 59000})
+
+	alert, err := session.SetTargetPrice(1, "a", 65000)
+	if err != nil || alert.TargetPrice != 65000 || alert.LastPrice != 59000 {
+		t.Fatalf("unexpected alert %v (%v)", alert, err)
+	}
+	signals := session.Fire("binance", "btcusdt", func(alert *dbModels.Alert) bool { return alert.TargetPrice == 65000 })
+	if len(signals) != 1 {
+		t.Errorf("edited alert should stay watched, got %v", signals)
+	}
+	if _, err = session.SetTargetPrice(1, "b", 65000); err == nil {
+		t.Error("unknown alert should fail")
+	}
+}
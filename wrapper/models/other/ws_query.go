@@ -1,6 +1,11 @@
 package models
 
-import "errors"
+import (
+	"errors"
+	"time"
+
+	dbModels "github.com/HomelessHunter/CTC/db/models"
+)
 
 type WSQuery struct {
 	UserId int64   `json:"user_id"`
@@ -8,6 +13,17 @@ type WSQuery struct {
 	Market string  `json:"market"`
 	Pair   string  `json:"pair"`
 	Price  float64 `json:"price"`
+	// Condition is one of the dbModels.Condition* values
+	Condition string `json:"condition"`
+	// Tolerance and Cooldown are zero when the user relies on defaults
+	Tolerance float64       `json:"tolerance"`
+	Cooldown  time.Duration `json:"cooldown"`
+	// Mode is empty for the default one-shot alert
+	Mode string `json:"mode"`
+	// Kind is empty for price alerts, funding alerts keep their rate in Price
+	// and change alerts their move, with the direction in Condition
+	Kind   string        `json:"kind"`
+	Window time.Duration `json:"window"`
 }
 
 func NewWsQuery(opts ...WSQueryOpts) (*WSQuery, error) {
@@ -83,3 +99,74 @@ func WithWSPrice(price float64) WSQueryOpts {
 		return nil
 	}
 }
+
+func WithWSCondition(condition string) WSQueryOpts {
+	return func(w *WSQuery) error {
+		if condition == "" {
+			return errors.New("condition shouldn't be empty")
+		}
+
+		w.Condition = condition
+		return nil
+	}
+}
+
+func WithWSTolerance(tolerance float64) WSQueryOpts {
+	return func(w *WSQuery) error {
+		if tolerance < 0 {
+			return errors.New("tolerance should be positive")
+		}
+
+		w.Tolerance = tolerance
+		return nil
+	}
+}
+
+func WithWSCooldown(cooldown time.Duration) WSQueryOpts {
+	return func(w *WSQuery) error {
+		if cooldown < 0 {
+			return errors.New("cooldown should be positive")
+		}
+
+		w.Cooldown = cooldown
+		return nil
+	}
+}
+
+func WithWSMode(mode string) WSQueryOpts {
+	return func(w *WSQuery) error {
+		w.Mode = mode
+		return nil
+	}
+}
+
+// WithWSFundingRate asks for a funding alert on rate, a fraction that may be negative
+func WithWSFundingRate(rate float64) WSQueryOpts {
+	return func(w *WSQuery) error {
+		if rate <= -1 || rate >= 1 {
+			return errors.New("funding rate should be between -100% and 100%")
+		}
+
+		w.Kind = dbModels.KindFunding
+		w.Price = rate
+		return nil
+	}
+}
+
+// WithWSChange asks for a change alert on a move of percent (a fraction) within window in direction
+func WithWSChange(percent float64, window time.Duration, direction string) WSQueryOpts {
+	return func(w *WSQuery) error {
+		if percent <= 0 {
+			return errors.New("change should be positive")
+		}
+		if window <= 0 {
+			return errors.New("window should be positive")
+		}
+
+		w.Kind = dbModels.KindChange
+		w.Price = percent
+		w.Window = window
+		w.Condition = direction
+		return nil
+	}
+}
@@ -0,0 +1,187 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const DefaultBaseURL = "https://api.telegram.org"
+
+// Client calls the Bot API on behalf of a single bot
+type Client struct {
+	http    *http.Client
+	baseURL string
+	token   string
+}
+
+func NewClient(token string, opts ...ClientOpts) (*Client, error) {
+	if token == "" {
+		return nil, errors.New("token shouldn't be empty")
+	}
+	client := Client{http: http.DefaultClient, baseURL: DefaultBaseURL, token: token}
+
+	for _, opt := range opts {
+		err := opt(&client)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &client, nil
+}
+
+type ClientOpts func(*Client) error
+
+func WithClientHTTP(httpClient *http.Client) ClientOpts {
+	return func(c *Client) error {
+		if httpClient == nil {
+			return errors.New("httpClient shouldn't be empty")
+		}
+
+		c.http = httpClient
+		return nil
+	}
+}
+
+func WithClientBaseURL(baseURL string) ClientOpts {
+	return func(c *Client) error {
+		if baseURL == "" {
+			return errors.New("baseURL shouldn't be empty")
+		}
+
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+		return nil
+	}
+}
+
+// Response is the envelope every Bot API method answers with
+type Response struct {
+	Ok          bool                `json:"ok"`
+	Result      json.RawMessage     `json:"result,omitempty"`
+	Description string              `json:"description,omitempty"`
+	ErrorCode   int                 `json:"error_code,omitempty"`
+	Parameters  *ResponseParameters `json:"parameters,omitempty"`
+}
+
+type ResponseParameters struct {
+	MigrateToChatId int64 `json:"migrate_to_chat_id,omitempty"`
+	RetryAfter      int   `json:"retry_after,omitempty"`
+}
+
+// APIError is returned when the Bot API answers with ok=false
+type APIError struct {
+	Method      string
+	Code        int
+	Description string
+	Parameters  ResponseParameters
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %d %s", e.Method, e.Code, e.Description)
+}
+
+// IsForbidden reports whether the bot can't write to the chat anymore:
+// the user blocked the bot or was deactivated, or the bot was kicked from the group
+func IsForbidden(err error) bool {
+	apiErr := &APIError{}
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden
+}
+
+// call posts payload to method and decodes the result into result unless it's nil
+func (c *Client) call(ctx context.Context, method string, payload interface{}, result interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("%s: %s", method, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/bot%s/%s", c.baseURL, c.token, method), bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s: %s", method, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %s", method, err)
+	}
+	defer resp.Body.Close()
+
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%s: %s", method, err)
+	}
+
+	response := Response{}
+	err = json.Unmarshal(data, &response)
+	if err != nil {
+		return fmt.Errorf("%s: %s %s", method, resp.Status, err)
+	}
+	if !response.Ok {
+		apiErr := &APIError{Method: method, Code: response.ErrorCode, Description: response.Description}
+		if apiErr.Code == 0 {
+			apiErr.Code = resp.StatusCode
+		}
+		if response.Parameters != nil {
+			apiErr.Parameters = *response.Parameters
+		}
+		return apiErr
+	}
+
+	if result == nil {
+		return nil
+	}
+	err = json.Unmarshal(response.Result, result)
+	if err != nil {
+		return fmt.Errorf("%s: %s", method, err)
+	}
+	return nil
+}
+
+func (c *Client) SendMessage(ctx context.Context, sendObj *SendMsgObj) (*Message, error) {
+	msg := &Message{}
+	err := c.call(ctx, "sendMessage", sendObj, msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (c *Client) EditMessageReplyMarkup(ctx context.Context, editMarkup *EditMarkupObj) error {
+	return c.call(ctx, "editMessageReplyMarkup", editMarkup, nil)
+}
+
+func (c *Client) DeleteMessage(ctx context.Context, chatId int64, msgId int) error {
+	return c.call(ctx, "deleteMessage", NewDeleteMsgObj(chatId, msgId), nil)
+}
+
+func (c *Client) AnswerCallbackQuery(ctx context.Context, answer *CallbackAnswer) error {
+	return c.call(ctx, "answerCallbackQuery", answer, nil)
+}
+
+// SetWebhook registers url as the destination of updates,
+// Telegram sends secret back in the X-Telegram-Bot-Api-Secret-Token header
+func (c *Client) SetWebhook(ctx context.Context, url string, secret string) error {
+	return c.call(ctx, "setWebhook", map[string]string{"url": url, "secret_token": secret}, nil)
+}
+
+// DeleteWebhook switches the bot back to getUpdates, Telegram refuses to poll while a webhook is set
+func (c *Client) DeleteWebhook(ctx context.Context) error {
+	return c.call(ctx, "deleteWebhook", map[string]bool{}, nil)
+}
+
+// GetUpdates long polls for updates starting at offset,
+// timeout is how long Telegram holds the request open when there's nothing new
+func (c *Client) GetUpdates(ctx context.Context, offset int, timeout time.Duration) ([]Update, error) {
+	updates := make([]Update, 0)
+	err := c.call(ctx, "getUpdates", map[string]int{"offset": offset, "timeout": int(timeout.Seconds())}, &updates)
+	if err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
@@ -0,0 +1,137 @@
+// Package telegramtest provides a fake Bot API for tests
+package telegramtest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	telegram "github.com/HomelessHunter/CTC/wrapper/models/telegram"
+)
+
+const Token = "test-token"
+
+type Call struct {
+	Method string
+	Body   []byte
+}
+
+// Server records every call and answers like the Bot API does,
+// sendMessage echoes the message back with a fresh message_id
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	calls     []Call
+	failures  map[string][]telegram.Response
+	updates   []telegram.Update
+	nextMsgId int
+}
+
+func NewServer() *Server {
+	s := &Server{failures: make(map[string][]telegram.Response)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// TGClient returns a client talking to this server
+func (s *Server) TGClient() *telegram.Client {
+	client, _ := telegram.NewClient(Token, telegram.WithClientBaseURL(s.URL), telegram.WithClientHTTP(s.Client()))
+	return client
+}
+
+// Fail makes the next call to method answer with the given error
+func (s *Server) Fail(method string, code int, description string, retryAfter int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	response := telegram.Response{ErrorCode: code, Description: description}
+	if retryAfter > 0 {
+		response.Parameters = &telegram.ResponseParameters{RetryAfter: retryAfter}
+	}
+	s.failures[method] = append(s.failures[method], response)
+}
+
+// PushUpdates queues updates for getUpdates
+func (s *Server) PushUpdates(updates ...telegram.Update) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updates = append(s.updates, updates...)
+}
+
+// Calls returns the calls made to method, all calls if method is empty
+func (s *Server) Calls(method string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	calls := make([]Call, 0)
+	for _, v := range s.calls {
+		if method == "" || v.Method == method {
+			calls = append(calls, v)
+		}
+	}
+	return calls
+}
+
+// Sent decodes every sendMessage call
+func (s *Server) Sent() []telegram.SendMsgObj {
+	calls := s.Calls("sendMessage")
+	sent := make([]telegram.SendMsgObj, len(calls))
+	for i, v := range calls {
+		json.Unmarshal(v.Body, &sent[i])
+	}
+	return sent
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/bot"+Token+"/")
+	if method == r.URL.Path {
+		reply(w, http.StatusUnauthorized, telegram.Response{ErrorCode: http.StatusUnauthorized, Description: "Unauthorized"})
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, Call{Method: method, Body: body})
+	if failures := s.failures[method]; len(failures) > 0 {
+		s.failures[method] = failures[1:]
+		reply(w, failures[0].ErrorCode, failures[0])
+		return
+	}
+
+	var result interface{} = true
+	switch method {
+	case "sendMessage":
+		sendObj := telegram.SendMsgObj{}
+		json.Unmarshal(body, &sendObj)
+		s.nextMsgId++
+		result = telegram.Message{
+			Id:          s.nextMsgId,
+			Chat:        telegram.Chat{Id: sendObj.ChatId},
+			Text:        sendObj.Text,
+			ReplyMarkup: sendObj.ReplyMarkup,
+		}
+	case "getUpdates":
+		params := struct {
+			Offset int `json:"offset"`
+		}{}
+		json.Unmarshal(body, &params)
+		updates := make([]telegram.Update, 0)
+		for _, v := range s.updates {
+			if v.Id >= params.Offset {
+				updates = append(updates, v)
+			}
+		}
+		result = updates
+	}
+	data, _ := json.Marshal(result)
+	reply(w, http.StatusOK, telegram.Response{Ok: true, Result: data})
+}
+
+func reply(w http.ResponseWriter, code int, response telegram.Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response)
+}
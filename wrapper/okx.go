@@ -0,0 +1,215 @@
+package wrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	cryptoMarkets "github.com/HomelessHunter/CTC/wrapper/models/cryptoMarkets"
+	"github.com/gorilla/websocket"
+)
+
+// OKX spot pairs and linear perpetual swaps are separate markets, swaps are pinned as "okx-swap:btcusdt"
+const (
+	OKX     string = "okx"
+	OKXSwap string = "okx-swap"
+)
+
+type okx struct {
+	name string
+	// instType is OKX's instrument type, SPOT or SWAP
+	instType string
+}
+
+func (o okx) Name() string {
+	return o.name
+}
+
+func (okx) Decal() string {
+	return "&#11035;"
+}
+
+func (o okx) Derivative() bool {
+	return o.instType == "SWAP"
+}
+
+// OKX closes connections that stay quiet for 30 seconds
+func (okx) PingInterval() time.Duration {
+	return 20 * time.Second
+}
+
+func (okx) Ping() []byte {
+	return []byte("ping")
+}
+
+// OKX takes subscribe requests up to 64 KB and ConnectOkx sends every pair in one,
+// a thousand tickers channels stay well under it
+func (okx) MaxStreams() int {
+	return 1000
+}
+
+func (o okx) Connect(dialer *websocket.Dialer, pairs []string) (*websocket.Conn, error) {
+	return ConnectOkx(dialer, pairs, o.swap())
+}
+
+func (o okx) Subscribe(conn *websocket.Conn, pair string) error {
+	return SubscribeOkx(conn, pair, o.swap())
+}
+
+func (o okx) Unsubscribe(conn *websocket.Conn, pair string) error {
+	return UnsubOkx(conn, pair, o.swap())
+}
+
+func (o okx) Decode(data []byte) (*cryptoMarkets.Tick, []byte, error) {
+	if string(data) == "pong" {
+		return nil, nil, nil
+	}
+	ticker := &cryptoMarkets.TickerOkx{}
+	err := json.Unmarshal(data, ticker)
+	if err != nil {
+		return nil, nil, fmt.Errorf("okx decode: %s", err)
+	}
+	if ticker.Event == "error" {
+		return nil, nil, fmt.Errorf("okx: %s %s", ticker.Code, ticker.Msg)
+	}
+	// subscribe acks come without data
+	if ticker.Arg.Channel != "tickers" || len(ticker.Data) == 0 {
+		return nil, nil, nil
+	}
+	lastPrice, err := ticker.Data[0].GetLastPrice()
+	if err != nil {
+		return nil, nil, fmt.Errorf("okx decode: %s", err)
+	}
+	return cryptoMarkets.NewTick(o.name, decodeSymbolOkx(ticker.Data[0].InstID).String(), lastPrice), nil, nil
+}
+
+func (o okx) LatestPrice(client *http.Client, pair string) (float64, error) {
+	latestPrice, err := LatestPriceOkx(pair, o.swap(), client)
+	if err != nil {
+		return 0, err
+	}
+	if latestPrice.Code != "0" {
+		return 0, fmt.Errorf("okx: %s", latestPrice.Msg)
+	}
+	if len(latestPrice.Data) == 0 {
+		return 0, fmt.Errorf("okx: no data on this pair: %s", pair)
+	}
+	return latestPrice.Data[0].GetLastPrice()
+}
+
+func (o okx) PairExist(client *http.Client, pair string) bool {
+	_, err := o.LatestPrice(client, pair)
+	return err == nil
+}
+
+func (o okx) Symbols(client *http.Client) ([]cryptoMarkets.SymbolInfo, error) {
+	list, err := InstrumentsOkx(o.instType, client)
+	if err != nil {
+		return nil, err
+	}
+	symbols := make([]cryptoMarkets.SymbolInfo, 0, len(list.Data))
+	for _, v := range list.Data {
+		symbol := decodeSymbolOkx(v.InstID)
+		// "BTC-USD-SWAP" is an inverse swap settled in BTC, only linear ones are priced like the pair
+		if o.swap() && symbol.Quote == "usd" {
+			continue
+		}
+		tickSize, _ := strconv.ParseFloat(v.TickSz, 64)
+		symbols = append(symbols, cryptoMarkets.SymbolInfo{
+			Market:   o.name,
+			Symbol:   symbol.String(),
+			Base:     symbol.Base,
+			Quote:    symbol.Quote,
+			TickSize: tickSize,
+			Status:   v.State,
+			Trading:  v.State == "live",
+		})
+	}
+	return symbols, nil
+}
+
+func (o okx) swap() bool {
+	return o.instType == "SWAP"
+}
+
+func ConnectOkx(dialer *websocket.Dialer, pairs []string, swap bool) (*websocket.Conn, error) {
+	conn, resp, err := dialer.Dial("wss://ws.okx.com:8443/ws/v5/public", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Okx_Dialer_ERR: %s", err)
+		return nil, dialErr(resp, err)
+	}
+	err = conn.WriteJSON(subscriptionOkx("subscribe", pairs, swap))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Okx_WriteJSON: %s", err)
+		return nil, err
+	}
+	return conn, nil
+}
+
+func SubscribeOkx(conn *websocket.Conn, pair string, swap bool) error {
+	return conn.WriteJSON(subscriptionOkx("subscribe", []string{pair}, swap))
+}
+
+func UnsubOkx(conn *websocket.Conn, pair string, swap bool) error {
+	return conn.WriteJSON(subscriptionOkx("unsubscribe", []string{pair}, swap))
+}
+
+func subscriptionOkx(op string, pairs []string, swap bool) map[string]interface{} {
+	args := make([]map[string]string, len(pairs))
+	for i, v := range pairs {
+		args[i] = map[string]string{"channel": "tickers", "instId": parsePairOkx(v, swap)}
+	}
+	return map[string]interface{}{"op": op, "args": args}
+}
+
+func parsePairOkx(pair string, swap bool) string {
+	return encodeSymbolOkx(DefaultCatalog.ParseSymbol(pair), swap)
+}
+
+// encodeSymbolOkx spells symbol as an OKX instrument id, "BTC-USDT" or "BTC-USDT-SWAP"
+func encodeSymbolOkx(symbol cryptoMarkets.Symbol, swap bool) string {
+	instID := strings.ToUpper(symbol.Base + "-" + symbol.Quote)
+	if swap {
+		instID += "-SWAP"
+	}
+	return instID
+}
+
+func decodeSymbolOkx(instID string) cryptoMarkets.Symbol {
+	return cryptoMarkets.ParseSymbol(strings.TrimSuffix(instID, "-SWAP"))
+}
+
+func LatestPriceOkx(pair string, swap bool, client *http.Client) (*cryptoMarkets.LatestTickerOkx, error) {
+	data, err := getData(client, fmt.Sprintf("https://www.okx.com/api/v5/market/ticker?instId=%s", parsePairOkx(pair, swap)))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "LatestPriceOkx: %s", err)
+		return nil, err
+	}
+	latestPrice := &cryptoMarkets.LatestTickerOkx{}
+	err = json.Unmarshal(data, latestPrice)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "LatestPriceOkx: %s", err)
+		return nil, err
+	}
+	return latestPrice, nil
+}
+
+func InstrumentsOkx(instType string, client *http.Client) (*cryptoMarkets.InstrumentsOkx, error) {
+	data, err := getData(client, fmt.Sprintf("https://www.okx.com/api/v5/public/instruments?instType=%s", instType))
+	if err != nil {
+		return nil, fmt.Errorf("InstrumentsOkx: %s", err)
+	}
+	list := &cryptoMarkets.InstrumentsOkx{}
+	err = json.Unmarshal(data, list)
+	if err != nil {
+		return nil, fmt.Errorf("InstrumentsOkx: %s", err)
+	}
+	if list.Code != "0" {
+		return nil, fmt.Errorf("InstrumentsOkx: %s", list.Msg)
+	}
+	return list, nil
+}
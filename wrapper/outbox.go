@@ -0,0 +1,399 @@
+package wrapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	telegram "github.com/HomelessHunter/CTC/wrapper/models/telegram"
+)
+
+var (
+	ErrOutboxClosed = errors.New("outbox is closed")
+	ErrOutboxFull   = errors.New("outbox is full")
+)
+
+// Telegram allows about 30 messages per second overall and one per second in a chat
+const (
+	defaultGlobalRate = 30
+	defaultChatRate   = 1
+)
+
+const (
+	// minRetryAfter is used when a 429 comes without retry_after, so the message isn't resent right away
+	minRetryAfter = time.Second
+	// maxRateLimited caps how many 429s a message may get, they don't count as attempts
+	maxRateLimited = 10
+)
+
+// bucket is a token bucket refilled at rate tokens per second up to burst
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate float64, burst float64) bucket {
+	return bucket{rate: rate, burst: burst, tokens: burst}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// wait returns how long until a token is available
+func (b *bucket) wait(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *bucket) take(now time.Time) {
+	b.refill(now)
+	b.tokens--
+}
+
+type outboxItem struct {
+	msg         *telegram.SendMsgObj
+	attempts    int
+	rateLimited int
+	notBefore   time.Time
+}
+
+type chatQueue struct {
+	items  []*outboxItem
+	bucket bucket
+	// busy is set while a message of the chat is being sent, it keeps messages in order
+	busy bool
+}
+
+// Outbox delivers messages in the background within Telegram's rate limits.
+// Messages of one chat keep their order, a 429 postpones the chat for retry_after (at least
+// minRetryAfter, maxRateLimited times at most) and other transient failures are retried with exponential backoff
+type Outbox struct {
+	tg         *telegram.Client
+	workers    int
+	chatRate   float64
+	maxPending int
+	maxRetries int
+	backoff    time.Duration
+	onFailure  func(msg *telegram.SendMsgObj, err error)
+
+	mu      sync.Mutex
+	global  bucket
+	chats   map[int64]*chatQueue
+	pending int
+	closed  bool
+
+	wake chan struct{}
+	jobs chan job
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+type job struct {
+	chatId int64
+	item   *outboxItem
+}
+
+func NewOutbox(tg *telegram.Client, opts ...OutboxOpts) (*Outbox, error) {
+	outbox := Outbox{
+		tg:         tg,
+		workers:    4,
+		chatRate:   defaultChatRate,
+		maxPending: 10000,
+		maxRetries: 5,
+		backoff:    time.Second,
+		global:     newBucket(defaultGlobalRate, defaultGlobalRate),
+		chats:      make(map[int64]*chatQueue),
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		err := opt(&outbox)
+		if err != nil {
+			return nil, err
+		}
+	}
+	outbox.jobs = make(chan job)
+
+	outbox.wg.Add(1 + outbox.workers)
+	go outbox.dispatch()
+	for i := 0; i < outbox.workers; i++ {
+		go outbox.work()
+	}
+	return &outbox, nil
+}
+
+type OutboxOpts func(*Outbox) error
+
+// WithOutboxRates sets messages per second overall and per chat
+func WithOutboxRates(global float64, chat float64) OutboxOpts {
+	return func(o *Outbox) error {
+		if global <= 0 || chat <= 0 {
+			return errors.New("rates should be positive")
+		}
+		o.global = newBucket(global, global)
+		o.chatRate = chat
+		return nil
+	}
+}
+
+// WithOutboxRetries sets how many times a transient failure is retried and the first backoff
+func WithOutboxRetries(maxRetries int, backoff time.Duration) OutboxOpts {
+	return func(o *Outbox) error {
+		if maxRetries < 0 || backoff <= 0 {
+			return errors.New("retries shouldn't be negative and backoff should be positive")
+		}
+		o.maxRetries = maxRetries
+		o.backoff = backoff
+		return nil
+	}
+}
+
+func WithOutboxMaxPending(maxPending int) OutboxOpts {
+	return func(o *Outbox) error {
+		if maxPending <= 0 {
+			return errors.New("maxPending should be positive")
+		}
+		o.maxPending = maxPending
+		return nil
+	}
+}
+
+// WithOutboxOnFailure is called for messages that won't be retried
+func WithOutboxOnFailure(onFailure func(msg *telegram.SendMsgObj, err error)) OutboxOpts {
+	return func(o *Outbox) error {
+		if onFailure == nil {
+			return errors.New("onFailure shouldn't be empty")
+		}
+		o.onFailure = onFailure
+		return nil
+	}
+}
+
+// Enqueue never blocks, it fails when the outbox is closed or full
+func (o *Outbox) Enqueue(msg *telegram.SendMsgObj) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return ErrOutboxClosed
+	}
+	if o.pending >= o.maxPending {
+		return ErrOutboxFull
+	}
+	chat, ok := o.chats[msg.ChatId]
+	if !ok {
+		chat = &chatQueue{bucket: newBucket(o.chatRate, 1)}
+		o.chats[msg.ChatId] = chat
+	}
+	chat.items = append(chat.items, &outboxItem{msg: msg})
+	o.pending++
+	o.signal()
+	return nil
+}
+
+// Pending counts messages that are queued or being sent
+func (o *Outbox) Pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.pending
+}
+
+// Drop discards the queued messages of a chat and returns how many were dropped,
+// a message already being sent isn't affected
+func (o *Outbox) Drop(chatId int64) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	chat, ok := o.chats[chatId]
+	if !ok {
+		return 0
+	}
+	dropped := len(chat.items)
+	chat.items = nil
+	o.pending -= dropped
+	return dropped
+}
+
+// Close stops accepting messages and keeps delivering the queued ones until ctx is done
+func (o *Outbox) Close(ctx context.Context) {
+	o.mu.Lock()
+	o.closed = true
+	o.mu.Unlock()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for o.Pending() > 0 {
+		select {
+		case <-ctx.Done():
+			fmt.Fprintf(os.Stderr, "outbox: %d messages weren't sent\n", o.Pending())
+			close(o.done)
+			o.wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+	close(o.done)
+	o.wg.Wait()
+}
+
+func (o *Outbox) signal() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *Outbox) dispatch() {
+	defer o.wg.Done()
+	for {
+		o.mu.Lock()
+		next, wait := o.next(time.Now())
+		o.mu.Unlock()
+
+		if next != nil {
+			select {
+			case o.jobs <- *next:
+				continue
+			case <-o.done:
+				return
+			}
+		}
+
+		var timer <-chan time.Time
+		if wait > 0 {
+			timer = time.After(wait)
+		}
+		select {
+		case <-o.wake:
+		case <-timer:
+		case <-o.done:
+			return
+		}
+	}
+}
+
+// next picks a message allowed to go out now or tells how long to wait for one,
+// a zero wait with no job means there's nothing to send. Must be called with mu held
+func (o *Outbox) next(now time.Time) (*job, time.Duration) {
+	var wait time.Duration
+	setWait := func(d time.Duration) {
+		if wait == 0 || d < wait {
+			wait = d
+		}
+	}
+	for chatId, chat := range o.chats {
+		if chat.busy {
+			continue
+		}
+		if len(chat.items) == 0 {
+			// an idle chat is forgotten once its bucket is full again
+			if chat.bucket.wait(now) == 0 && chat.bucket.tokens >= chat.bucket.burst {
+				delete(o.chats, chatId)
+			} else {
+				setWait(chat.bucket.wait(now) + time.Millisecond)
+			}
+			continue
+		}
+		item := chat.items[0]
+		if d := item.notBefore.Sub(now); d > 0 {
+			setWait(d)
+			continue
+		}
+		if d := chat.bucket.wait(now); d > 0 {
+			setWait(d)
+			continue
+		}
+		if d := o.global.wait(now); d > 0 {
+			setWait(d)
+			return nil, wait
+		}
+		chat.bucket.take(now)
+		o.global.take(now)
+		chat.items = chat.items[1:]
+		chat.busy = true
+		return &job{chatId: chatId, item: item}, 0
+	}
+	return nil, wait
+}
+
+func (o *Outbox) work() {
+	defer o.wg.Done()
+	for {
+		select {
+		case j := <-o.jobs:
+			_, err := o.tg.SendMessage(context.Background(), j.item.msg)
+			o.finish(j, err)
+		case <-o.done:
+			return
+		}
+	}
+}
+
+// finish books the result of a send and requeues the message if it's worth another try
+func (o *Outbox) finish(j job, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	defer o.signal()
+	chat := o.chats[j.chatId]
+	chat.busy = false
+
+	retry, delay := o.retryDelay(j.item, err)
+	if retry {
+		j.item.notBefore = time.Now().Add(delay)
+		chat.items = append([]*outboxItem{j.item}, chat.items...)
+		return
+	}
+
+	o.pending--
+	if err != nil {
+		if o.onFailure != nil {
+			go o.onFailure(j.item.msg, err)
+		} else {
+			fmt.Fprintf(os.Stderr, "outbox: %s\n", err)
+		}
+	}
+}
+
+func (o *Outbox) retryDelay(item *outboxItem, err error) (bool, time.Duration) {
+	if err == nil {
+		return false, 0
+	}
+	apiErr := &telegram.APIError{}
+	if errors.As(err, &apiErr) {
+		if apiErr.Code == http.StatusTooManyRequests {
+			// Telegram tells exactly how long to back off, it doesn't count as an attempt
+			item.rateLimited++
+			if item.rateLimited > maxRateLimited {
+				return false, 0
+			}
+			delay := time.Duration(apiErr.Parameters.RetryAfter) * time.Second
+			if delay < minRetryAfter {
+				delay = minRetryAfter
+			}
+			return true, delay
+		}
+		if apiErr.Code < http.StatusInternalServerError {
+			// bad request, blocked by the user and alike won't get better
+			return false, 0
+		}
+	}
+	item.attempts++
+	if item.attempts > o.maxRetries {
+		return false, 0
+	}
+	return true, o.backoff << (item.attempts - 1)
+}
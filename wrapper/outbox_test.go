@@ -0,0 +1,176 @@
+package wrapper
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	telegram "github.com/HomelessHunter/CTC/wrapper/models/telegram"
+	"github.com/HomelessHunter/CTC/wrapper/models/telegram/telegramtest"
+)
+
+func newTestMsg(chatId int64, text string) *telegram.SendMsgObj {
+	msg, _ := telegram.NewSendMsgObj(telegram.WithSendChatId(chatId), telegram.WithSendText(text))
+	return msg
+}
+
+func waitSent(t *testing.T, srv *telegramtest.Server, n int, timeout time.Duration) []telegramtest.Call {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if calls := srv.Calls("sendMessage"); len(calls) >= n {
+			return calls
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected %d messages, got %d", n, len(srv.Calls("sendMessage")))
+	return nil
+}
+
+func TestBucket(t *testing.T) {
+	now := time.Now()
+	b := newBucket(2, 2)
+	b.take(now)
+	b.take(now)
+	if d := b.wait(now); d != 500*time.Millisecond {
+		t.Errorf("expected 500ms, got %s", d)
+	}
+	if d := b.wait(now.Add(500 * time.Millisecond)); d != 0 {
+		t.Errorf("token should be back, got %s", d)
+	}
+}
+
+func TestOutboxChatRate(t *testing.T) {
+	srv := telegramtest.NewServer()
+	defer srv.Close()
+	outbox, _ := NewOutbox(srv.TGClient(), WithOutboxRates(100, 10))
+	defer outbox.Close(context.TODO())
+
+	start := time.Now()
+	for _, text := range []string{"1", "2", "3"} {
+		if err := outbox.Enqueue(newTestMsg(1, text)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// another chat isn't held back by the first one
+	outbox.Enqueue(newTestMsg(2, "other"))
+	waitSent(t, srv, 4, 2*time.Second)
+
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("3 messages to one chat at 10/s took only %s", elapsed)
+	}
+	sent := srv.Sent()
+	order := ""
+	for _, v := range sent {
+		if v.ChatId == 1 {
+			order += v.Text
+		}
+	}
+	if order != "123" {
+		t.Errorf("messages of a chat should keep their order, got %s", order)
+	}
+}
+
+func TestOutboxRetryAfter(t *testing.T) {
+	srv := telegramtest.NewServer()
+	defer srv.Close()
+	outbox, _ := NewOutbox(srv.TGClient())
+	defer outbox.Close(context.TODO())
+
+	srv.Fail("sendMessage", 429, "Too Many Requests: retry after 1", 1)
+	start := time.Now()
+	outbox.Enqueue(newTestMsg(1, "hi"))
+	waitSent(t, srv, 2, 3*time.Second)
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retry_after wasn't honored, resent after %s", elapsed)
+	}
+}
+
+func TestOutboxRateLimited(t *testing.T) {
+	outbox := &Outbox{maxRetries: 5, backoff: time.Second}
+	item := &outboxItem{}
+	tooMany := &telegram.APIError{Method: "sendMessage", Code: 429, Description: "Too Many Requests"}
+
+	// a missing retry_after doesn't mean right away
+	retry, delay := outbox.retryDelay(item, tooMany)
+	if !retry || delay != minRetryAfter {
+		t.Errorf("expected a retry after %s, got %t %s", minRetryAfter, retry, delay)
+	}
+	for i := 1; i < maxRateLimited; i++ {
+		outbox.retryDelay(item, tooMany)
+	}
+	if retry, _ = outbox.retryDelay(item, tooMany); retry {
+		t.Errorf("a message shouldn't be retried after %d 429s", maxRateLimited)
+	}
+	if item.attempts != 0 {
+		t.Errorf("429s shouldn't count as attempts, got %d", item.attempts)
+	}
+}
+
+func TestOutboxFailures(t *testing.T) {
+	srv := telegramtest.NewServer()
+	defer srv.Close()
+	failed := make(chan error, 2)
+	outbox, _ := NewOutbox(srv.TGClient(), WithOutboxRetries(1, 10*time.Millisecond),
+		WithOutboxOnFailure(func(msg *telegram.SendMsgObj, err error) { failed <- err }))
+	defer outbox.Close(context.TODO())
+
+	// a transient error is retried until retries run out
+	srv.Fail("sendMessage", 502, "Bad Gateway", 0)
+	srv.Fail("sendMessage", 502, "Bad Gateway", 0)
+	outbox.Enqueue(newTestMsg(1, "transient"))
+	select {
+	case err := <-failed:
+		apiErr := &telegram.APIError{}
+		if !errors.As(err, &apiErr) || apiErr.Code != 502 {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("failure wasn't reported")
+	}
+	if n := len(srv.Calls("sendMessage")); n != 2 {
+		t.Errorf("expected one retry, got %d calls", n)
+	}
+
+	// a blocked bot won't be unblocked by retrying
+	srv.Fail("sendMessage", 403, "Forbidden: bot was blocked by the user", 0)
+	outbox.Enqueue(newTestMsg(2, "blocked"))
+	select {
+	case <-failed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("failure wasn't reported")
+	}
+	if n := len(srv.Calls("sendMessage")); n != 3 {
+		t.Errorf("403 shouldn't be retried, got %d calls", n)
+	}
+}
+
+func TestOutboxNeverBlocks(t *testing.T) {
+	srv := telegramtest.NewServer()
+	defer srv.Close()
+	outbox, _ := NewOutbox(srv.TGClient(), WithOutboxMaxPending(2), WithOutboxRates(1, 1))
+
+	done := make(chan error)
+	go func() {
+		var err error
+		for i := 0; i < 5 && err == nil; i++ {
+			err = outbox.Enqueue(newTestMsg(1, "hi"))
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != ErrOutboxFull {
+			t.Errorf("expected ErrOutboxFull, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Enqueue blocked")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	outbox.Close(ctx)
+	if err := outbox.Enqueue(newTestMsg(1, "late")); err != ErrOutboxClosed {
+		t.Errorf("expected ErrOutboxClosed, got %v", err)
+	}
+}
@@ -1,14 +1,15 @@
 package wrapper
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"io"
+	"html"
+	"math"
 	"net/http"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -20,30 +21,6 @@ import (
 
 var ErrEmptyPairs = errors.New("pairs shouldn't be empty")
 
-func SetWebhook(client *http.Client) {
-	apiKey := os.Getenv("TG")
-	postBody, err := json.Marshal(map[string]string{
-		"url": fmt.Sprintf("https://main.myserversuck.keenetic.link/%s", apiKey),
-	})
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-	}
-
-	body := bytes.NewBuffer(postBody)
-	resp, err := client.Post(fmt.Sprintf("https://api.telegram.org/bot%s/setWebhook", apiKey), "application/json", body)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-	}
-
-	data, err := io.ReadAll(resp.Body)
-	defer resp.Body.Close()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-	}
-
-	fmt.Fprintln(os.Stdout, string(data))
-}
-
 func CommandRouter(command string, regs map[string]*regexp.Regexp) string {
 	switch {
 	case regs["start"].MatchString(command):
@@ -55,52 +32,107 @@ func CommandRouter(command string, regs map[string]*regexp.Regexp) string {
 	case regs["alert"].MatchString(command):
 		return "alert"
 
+	case regs["funding"].MatchString(command):
+		return "funding"
+
+	case regs["change"].MatchString(command):
+		return "change"
+
+	case regs["defaults"].MatchString(command):
+		return "defaults"
+
+	case regs["history"].MatchString(command):
+		return "history"
+
 	case regs["price"].MatchString(command):
 		return "price"
 
 	case regs["disconnect"].MatchString(command):
 		return "disconnect"
+
+	case regs["list"].MatchString(command):
+		return "list"
+
+	case regs["edit"].MatchString(command):
+		return "edit"
+
+	case regs["alertStart"].MatchString(command):
+		return "conversation"
+
+	case regs["cancel"].MatchString(command):
+		return "cancel"
 	}
 	return ""
 }
 
-func StartRouter(update *telegram.Update, client *http.Client) error {
+func StartRouter(update *telegram.Update, tg *telegram.Client) error {
 	msg, err := telegram.NewMsg(telegram.WithMsgText("Hello! I'm your personal crypto companion\nThat's what i can do for you:\n"), telegram.WithMsgChat(update.FromChat()))
 	if err != nil {
 		return fmt.Errorf("StartRouter: %v", err)
 	}
 
-	_, err = sendMsg(client, *msg, false)
+	_, err = sendMsg(tg, *msg, false)
 	if err != nil {
 		return fmt.Errorf("StartRouter: %v", err)
 	}
-	err = HelpRouter(update, client)
+	err = HelpRouter(update, tg)
 	if err != nil {
 		return fmt.Errorf("StartRouter: %v", err)
 	}
 	return nil
 }
 
-func HelpRouter(update *telegram.Update, client *http.Client) error {
-	text := "&#128142; <b>CryptoTrader Companion</b> &#128142;\n\n&#128073; <b>ALERT</b>\nType <b><u>/alert &#60;pair/symbols&#62 &#60target price&#62</u></b> to set alert (e.g. <u>/alert btcusdt 53400</u>)\n\n&#128073; <b>PRICE</b>\nType <b><u>/price &#60pair/symbols&#62</u></b> (e.g. <u>/price ehtbusd</u>)"
+// SendUsage answers a command that didn't parse with the right syntax
+func SendUsage(tg *telegram.Client, update *telegram.Update, command string) error {
+	text := "&#9940; Unknown command, type /help to see what I can do"
+	if strings.HasPrefix(strings.ToLower(command), "/alert") {
+		text = "&#9940; Type <b><u>/alert &#60;pair&#62; [&#62;|&#60;|cross] &#60;price&#62;</u></b> or just <b><u>/alert</u></b> and I'll ask step by step"
+	}
+	if strings.HasPrefix(strings.ToLower(command), "/change") {
+		text = "&#9940; Type <b><u>/change &#60;pair&#62; [up|down] &#60;percent&#62;% &#60;window&#62;</u></b> (e.g. <u>/change solusdt 5% 1h</u>)"
+	}
+	if strings.HasPrefix(strings.ToLower(command), "/funding") {
+		text = "&#9940; Type <b><u>/funding &#60;pair&#62; &#62;|&#60;|cross &#60;rate&#62;%</u></b> (e.g. <u>/funding btcusdt &#62; 0.05%</u>)"
+	}
+	msg, err := telegram.NewMsg(telegram.WithMsgText(text), telegram.WithMsgChat(update.FromChat()))
+	if err != nil {
+		return fmt.Errorf("SendUsage: %s", err)
+	}
+	_, err = sendMsg(tg, *msg, false)
+	if err != nil {
+		return fmt.Errorf("SendUsage: %s", err)
+	}
+	return nil
+}
+
+func HelpRouter(update *telegram.Update, tg *telegram.Client) error {
+	text := "&#128142; <b>CryptoTrader Companion</b> &#128142;\n\n&#128073; <b>ALERT</b>\nType <b><u>/alert &#60;pair/symbols&#62 &#60target price&#62</u></b> to set alert (e.g. <u>/alert btcusdt 53400</u>) or just <b><u>/alert</u></b> to be asked step by step\nAdd <b>&#62;</b>, <b>&#60;</b> or <b>cross</b> before the price to fire when the price moves above, below or through the target (e.g. <u>/alert btcusdt &#62; 70000</u>)\nPut a market before the pair to pin it (e.g. <u>/alert binance:btcusdt 70000</u>), <b>binance-futures</b>, <b>okx-swap</b> and <b>bybit-perp</b> watch perpetual swaps\nAlerts fire once and move to <b><u>/history</u></b>, add <b>recurring</b> to keep them firing (e.g. <u>/alert btcusdt 53400 recurring</u>)\n\n&#128073; <b>CHANGE</b>\nType <b><u>/change &#60;pair&#62; [up|down] &#60;percent&#62;% &#60;window&#62;</u></b> to know when a pair moves that much within 1m to 24h (e.g. <u>/change solusdt 5% 1h</u>), either way unless you add <b>up</b> or <b>down</b>\n\n&#128073; <b>FUNDING</b>\nType <b><u>/funding &#60;pair&#62; &#62;|&#60;|cross &#60;rate&#62;%</u></b> to watch a Binance perpetual's funding rate (e.g. <u>/funding btcusdt &#62; 0.05%</u>)\n\n&#128073; <b>PRICE</b>\nType <b><u>/price &#60pair/symbols&#62</u></b> (e.g. <u>/price ethusdt</u>)\n\n&#128073; <b>TOLERANCE &amp; COOLDOWN</b>\nAppend <b>tol=</b> and <b>cooldown=</b> to an alert (e.g. <u>/alert ethusdt 3000 tol=0.2% cooldown=1h</u>) or type <b><u>/defaults tol=0.5% cooldown=1d</u></b> to change your defaults\n\n&#128073; <b>LIST</b>\nType <b><u>/list</u></b> to see your alerts with current prices\n\n&#128073; <b>EDIT</b>\nType <b><u>/edit &#60pair&#62 &#60new price&#62</u></b> to move an alert (e.g. <u>/edit btcusdt 71000</u>), leave the price out to pick a move or tap <b>Edit</b> under <b><u>/list</u></b>"
 	msg, err := telegram.NewMsg(telegram.WithMsgText(text), telegram.WithMsgChat(update.FromChat()))
 	if err != nil {
 		return fmt.Errorf("HelpRouter: %s", err)
 	}
-	_, err = sendMsg(client, *msg, false)
+	_, err = sendMsg(tg, *msg, false)
 	return err
 }
 
-func AlertRouter(command string, regs map[string]*regexp.Regexp, update *telegram.Update, client *http.Client) (*other.WSQuery, error) {
-	c := regs["splitter"].Split(command, 3)
-	price, err := strconv.ParseFloat(c[2], 64)
+func AlertRouter(command string, regs map[string]*regexp.Regexp, update *telegram.Update, tg *telegram.Client, client *http.Client) (*other.WSQuery, error) {
+	fields, opts, err := parseAlertOptions(strings.Fields(command))
+	if err != nil {
+		sendErrMsg(tg, *update.FromChat(), err)
+		return nil, fmt.Errorf("AlertRouter: %s", err)
+	}
+	pair, condition, price, err := parseAlertCommand(strings.Join(fields, " "))
 	if err != nil {
 		return nil, fmt.Errorf("AlertRouter: %s", err)
 	}
 
-	market, err := getMarket(c[1], client)
+	market, pair, err := getMarket(pair, client)
 	if err != nil {
-		sendNoPairErr(client, *update.FromChat(), c[1])
+		sendPairErr(tg, *update.FromChat(), pair, err, func(symbol string) string {
+			fields := strings.Fields(command)
+			fields[1] = symbol
+			return strings.Join(fields, " ")
+		})
 		return nil, fmt.Errorf("AlertRouter: %s", err)
 	}
 
@@ -108,8 +140,12 @@ func AlertRouter(command string, regs map[string]*regexp.Regexp, update *telegra
 		other.WithWSUserId(update.FromUser().ID()),
 		other.WithWSChatId(update.FromChat().ID()),
 		other.WithWSMarket(market),
-		other.WithWSPair(c[1]),
+		other.WithWSPair(pair),
 		other.WithWSPrice(price),
+		other.WithWSCondition(condition),
+		other.WithWSTolerance(opts.tolerance),
+		other.WithWSCooldown(opts.cooldown),
+		other.WithWSMode(opts.mode),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("AlertRouter: %s", err)
@@ -117,7 +153,543 @@ func AlertRouter(command string, regs map[string]*regexp.Regexp, update *telegra
 	return wsQuery, nil
 }
 
-func DisconnectRouter(update *telegram.Update, pairs []db.Alert, client *http.Client) error {
+// FundingRouter parses "/funding <pair> >|<|cross <rate>%". Funding rates are streamed by
+// Binance futures only, so the pair is resolved there whatever market is pinned
+func FundingRouter(command string, update *telegram.Update, tg *telegram.Client, client *http.Client) (*other.WSQuery, error) {
+	fields, opts, err := parseAlertOptions(strings.Fields(command))
+	if err == nil && opts.tolerance > 0 {
+		err = errors.New("tol= doesn't apply to funding alerts")
+	}
+	if err != nil {
+		sendErrMsg(tg, *update.FromChat(), err)
+		return nil, fmt.Errorf("FundingRouter: %s", err)
+	}
+	pair, condition, rate, err := parseFundingCommand(strings.Join(fields, " "))
+	if err != nil {
+		sendErrMsg(tg, *update.FromChat(), err)
+		return nil, fmt.Errorf("FundingRouter: %s", err)
+	}
+
+	pinned, pair := splitPin(pair)
+	if pinned != "" && pinned != BinanceFutures {
+		err = fmt.Errorf("funding alerts are only available on %s", BinanceFutures)
+		sendErrMsg(tg, *update.FromChat(), err)
+		return nil, fmt.Errorf("FundingRouter: %s", err)
+	}
+	market, pair, err := getMarket(BinanceFutures+":"+pair, client)
+	if err != nil {
+		sendPairErr(tg, *update.FromChat(), pair, err, func(symbol string) string {
+			fields := strings.Fields(command)
+			fields[1] = symbol
+			return strings.Join(fields, " ")
+		})
+		return nil, fmt.Errorf("FundingRouter: %s", err)
+	}
+
+	wsQuery, err := other.NewWsQuery(
+		other.WithWSUserId(update.FromUser().ID()),
+		other.WithWSChatId(update.FromChat().ID()),
+		other.WithWSMarket(market),
+		other.WithWSPair(pair),
+		other.WithWSFundingRate(rate),
+		other.WithWSCondition(condition),
+		other.WithWSCooldown(opts.cooldown),
+		other.WithWSMode(opts.mode),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("FundingRouter: %s", err)
+	}
+	return wsQuery, nil
+}
+
+// parseFundingCommand reads the rate of "/funding <pair> <condition> <rate>%" as a fraction,
+// a funding alert needs a direction so the "near" default is refused
+func parseFundingCommand(command string) (pair string, condition string, rate float64, err error) {
+	pair, condition, percent, err := parseAlertCommand(strings.TrimSuffix(command, "%"))
+	if err != nil {
+		return "", "", 0, fmt.Errorf("parseFundingCommand: %s", err)
+	}
+	if condition == db.ConditionNear {
+		return "", "", 0, errors.New("parseFundingCommand: use >, < or cross before the rate")
+	}
+	return pair, condition, percent / 100, nil
+}
+
+// ChangeRouter parses "/change <pair> [up|down|either] <percent>% <window>"
+func ChangeRouter(command string, update *telegram.Update, tg *telegram.Client, client *http.Client) (*other.WSQuery, error) {
+	fields, opts, err := parseAlertOptions(strings.Fields(command))
+	if err == nil && opts.tolerance > 0 {
+		err = errors.New("tol= doesn't apply to change alerts")
+	}
+	if err != nil {
+		sendErrMsg(tg, *update.FromChat(), err)
+		return nil, fmt.Errorf("ChangeRouter: %s", err)
+	}
+	pair, direction, percent, window, err := parseChangeCommand(strings.Join(fields, " "))
+	if err != nil {
+		sendErrMsg(tg, *update.FromChat(), err)
+		return nil, fmt.Errorf("ChangeRouter: %s", err)
+	}
+
+	market, pair, err := getMarket(pair, client)
+	if err != nil {
+		sendPairErr(tg, *update.FromChat(), pair, err, func(symbol string) string {
+			fields := strings.Fields(command)
+			fields[1] = symbol
+			return strings.Join(fields, " ")
+		})
+		return nil, fmt.Errorf("ChangeRouter: %s", err)
+	}
+
+	wsQuery, err := other.NewWsQuery(
+		other.WithWSUserId(update.FromUser().ID()),
+		other.WithWSChatId(update.FromChat().ID()),
+		other.WithWSMarket(market),
+		other.WithWSPair(pair),
+		other.WithWSChange(percent, window, direction),
+		other.WithWSCooldown(opts.cooldown),
+		other.WithWSMode(opts.mode),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ChangeRouter: %s", err)
+	}
+	return wsQuery, nil
+}
+
+// parseChangeCommand splits "/change <pair> [up|down|either] <percent>% <window>", the move is
+// returned as a fraction
+func parseChangeCommand(command string) (pair string, direction string, percent float64, window time.Duration, err error) {
+	fields := strings.Fields(command)
+	if len(fields) != 4 && len(fields) != 5 {
+		return "", "", 0, 0, fmt.Errorf("parseChangeCommand: usage /change <pair> [up|down] <percent>%% <window>")
+	}
+	pair = fields[1]
+	operator := ""
+	if len(fields) == 5 {
+		operator = fields[2]
+	}
+	direction, err = db.ParseDirection(operator)
+	if err != nil {
+		return "", "", 0, 0, fmt.Errorf("parseChangeCommand: %s", err)
+	}
+	value, err := strconv.ParseFloat(strings.TrimSuffix(fields[len(fields)-2], "%"), 64)
+	if err != nil || value <= 0 {
+		return "", "", 0, 0, fmt.Errorf("parseChangeCommand: %s isn't a valid change", fields[len(fields)-2])
+	}
+	window, err = db.ParseWindow(fields[len(fields)-1])
+	if err != nil {
+		return "", "", 0, 0, fmt.Errorf("parseChangeCommand: %s", err)
+	}
+	return pair, direction, value / 100, window, nil
+}
+
+// alertOptions holds the optional part of /alert and /defaults,
+// zero values mean the option wasn't set
+type alertOptions struct {
+	tolerance float64
+	cooldown  time.Duration
+	mode      string
+}
+
+// parseAlertOptions pulls "tol=", "cooldown=" and the once/recurring mode out of the command fields
+func parseAlertOptions(fields []string) (rest []string, opts alertOptions, err error) {
+	rest = make([]string, 0, len(fields))
+	for _, v := range fields {
+		switch strings.ToLower(v) {
+		case db.ModeOnce, db.ModeRecurring:
+			opts.mode = strings.ToLower(v)
+			continue
+		}
+		key, value, ok := strings.Cut(v, "=")
+		if !ok {
+			rest = append(rest, v)
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "tol":
+			opts.tolerance, err = db.ParseTolerance(value)
+		case "cooldown":
+			opts.cooldown, err = db.ParseCooldown(value)
+		default:
+			err = fmt.Errorf("unknown option %s", key)
+		}
+		if err != nil {
+			return nil, alertOptions{}, fmt.Errorf("parseAlertOptions: %s", err)
+		}
+	}
+	return rest, opts, nil
+}
+
+// parseAlertCommand splits "/alert <pair> [>|<|above|below|cross] <price>"
+func parseAlertCommand(command string) (pair string, condition string, price float64, err error) {
+	fields := strings.Fields(command)
+	if len(fields) < 3 {
+		return "", "", 0, fmt.Errorf("parseAlertCommand: not enough arguments in %q", command)
+	}
+	pair = fields[1]
+	args := strings.Join(fields[2:], "")
+
+	operator := ""
+	switch {
+	case strings.HasPrefix(args, ">"), strings.HasPrefix(args, "<"):
+		operator, args = args[:1], args[1:]
+	case len(fields) > 3:
+		operator, args = fields[2], strings.Join(fields[3:], "")
+	}
+	condition, err = db.ParseCondition(operator)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("parseAlertCommand: %s", err)
+	}
+	price, err = strconv.ParseFloat(args, 64)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("parseAlertCommand: %s", err)
+	}
+	return pair, condition, price, nil
+}
+
+func HistoryRouter(update *telegram.Update, history []db.Alert, tg *telegram.Client) error {
+	text := "You have no triggered alerts"
+	if len(history) > 0 {
+		// newest first, the last ten are enough
+		sort.Slice(history, func(i, j int) bool {
+			return history[i].TriggeredAt.After(history[j].TriggeredAt)
+		})
+		if len(history) > 10 {
+			history = history[:10]
+		}
+		var sb strings.Builder
+		sb.WriteString("&#128221; <b>Triggered alerts</b>\n")
+		for _, v := range history {
+			sb.WriteString(fmt.Sprintf("\n<b>%s</b> %s (%s) - %s", DisplayPair(v.Pair), html.EscapeString(v.Describe()), v.Market, v.TriggeredAt.Format("2006-01-02 15:04 MST")))
+		}
+		text = sb.String()
+	}
+	msg, err := telegram.NewMsg(telegram.WithMsgText(text), telegram.WithMsgChat(update.FromChat()))
+	if err != nil {
+		return fmt.Errorf("HistoryRouter: %s", err)
+	}
+	_, err = sendMsg(tg, *msg, false)
+	if err != nil {
+		return fmt.Errorf("HistoryRouter: %s", err)
+	}
+	return nil
+}
+
+// ListRouter shows every alert with the current price and how far it is from the target.
+// Alerts carry the last tick they saw, the rest are priced through the exchange REST API
+func ListRouter(update *telegram.Update, alerts []db.Alert, tg *telegram.Client, client *http.Client) error {
+	text := "You have no alerts"
+	if len(alerts) > 0 {
+		sort.Slice(alerts, func(i, j int) bool {
+			if alerts[i].Market != alerts[j].Market {
+				return alerts[i].Market < alerts[j].Market
+			}
+			return alerts[i].Pair < alerts[j].Pair
+		})
+		prices := make(map[string]float64)
+		var sb strings.Builder
+		sb.WriteString("&#128203; <b>Your alerts</b>\n")
+		for _, v := range alerts {
+			// the rate and the move come with the stream only, they're unknown until the first tick
+			current := "n/a"
+			switch v.GetKind() {
+			case db.KindFunding:
+				if v.Seen {
+					current = "funding " + formatRate(v.LastPrice)
+				}
+			case db.KindChange:
+				if v.Seen {
+					current = fmt.Sprintf("moved %+.2f%%", v.LastPrice*100)
+				}
+			default:
+				key := v.Market + ":" + v.Pair
+				price, ok := prices[key]
+				if !ok {
+					price = v.LastPrice
+					if price == 0 {
+						price = latestPrice(v.Market, v.Pair, client)
+					}
+					prices[key] = price
+				}
+				if price > 0 {
+					distance := (v.TargetPrice - price) / price * 100
+					current = fmt.Sprintf("%s (%+.2f%%)", formatPrice(price), distance)
+				}
+			}
+			fired := "never"
+			if !v.LastSignal.IsZero() {
+				fired = v.LastSignal.Format("2006-01-02 15:04 MST")
+			}
+			sb.WriteString(fmt.Sprintf("\n<b>%s</b> %s (%s)\nPrice: %s\nLast fired: %s\n",
+				DisplayPair(v.Pair), html.EscapeString(v.Describe()), v.Market, current, fired))
+		}
+		text = sb.String()
+	}
+	opts := []telegram.MsgOptions{telegram.WithMsgText(text), telegram.WithMsgChat(update.FromChat())}
+	if len(alerts) > 0 {
+		ik, err := composeEditKeyboard(alerts)
+		if err != nil {
+			return fmt.Errorf("ListRouter: %s", err)
+		}
+		if ik != nil {
+			opts = append(opts, telegram.WithMsgReplyMarkup(ik))
+		}
+	}
+	msg, err := telegram.NewMsg(opts...)
+	if err != nil {
+		return fmt.Errorf("ListRouter: %s", err)
+	}
+	_, err = sendMsg(tg, *msg, false)
+	if err != nil {
+		return fmt.Errorf("ListRouter: %s", err)
+	}
+	return nil
+}
+
+// EditRouter parses "/edit <pair or alert id> [price]" and picks the alert to move.
+// An empty id with no error means the user was asked to choose between several alerts,
+// a zero price means no price was typed and the user should be offered some, like EditCallback
+func EditRouter(command string, update *telegram.Update, alerts []db.Alert, tg *telegram.Client) (string, float64, error) {
+	fields := strings.Fields(command)
+	if len(fields) != 2 && len(fields) != 3 {
+		err := errors.New("usage: /edit <pair> [new price]")
+		sendErrMsg(tg, *update.FromChat(), err)
+		return "", 0, fmt.Errorf("EditRouter: %s", err)
+	}
+	var price float64
+	if len(fields) == 3 {
+		var err error
+		price, err = strconv.ParseFloat(fields[2], 64)
+		if err != nil || price <= 0 {
+			sendErrMsg(tg, *update.FromChat(), fmt.Errorf("%s isn't a valid price", fields[2]))
+			return "", 0, fmt.Errorf("EditRouter: bad price %s", fields[2])
+		}
+	}
+
+	target := strings.ToLower(fields[1])
+	matches := make([]db.Alert, 0, 1)
+	for _, v := range alerts {
+		if v.GetKind() == db.KindPrice && v.Hex == target {
+			matches = append(matches, v)
+		}
+	}
+	if len(matches) == 0 {
+		// the pair is typed the way /alert takes it, "BTC/USDT" or "binance:btcusdt" included
+		market, pair := splitPin(target)
+		pair = DefaultCatalog.ParseSymbol(pair).String()
+		for _, v := range alerts {
+			if v.GetKind() == db.KindPrice && v.Pair == pair && (market == "" || v.Market == market) {
+				matches = append(matches, v)
+			}
+		}
+	}
+	switch len(matches) {
+	case 0:
+		msg, err := telegram.NewMsg(telegram.WithMsgChat(update.FromChat()), telegram.WithMsgText(fmt.Sprintf("You have no <b>%s</b> alerts", html.EscapeString(strings.ToUpper(target)))))
+		if err != nil {
+			return "", 0, fmt.Errorf("EditRouter: %s", err)
+		}
+		sendMsg(tg, *msg, false)
+		return "", 0, fmt.Errorf("EditRouter: no alert %s", target)
+	case 1:
+		return matches[0].Hex, price, nil
+	}
+
+	question := fmt.Sprintf("Which alert should move to <b>%s</b>?", formatTarget(price))
+	if price == 0 {
+		question = "Which alert should move?"
+	}
+	buttons := make([]telegram.InlineKeyboardButton, 0, len(matches))
+	for _, v := range matches {
+		data := "edit " + v.Hex
+		if price > 0 {
+			data += " " + formatTarget(price)
+		}
+		ikb, err := telegram.NewInlineKeyboardButton(
+			telegram.WithIKBText(fmt.Sprintf("%s %s (%s)", DisplayPair(v.Pair), v.Describe(), v.Market)),
+			telegram.WithIKBCallbackData(data),
+		)
+		if err != nil {
+			return "", 0, fmt.Errorf("EditRouter: %s", err)
+		}
+		buttons = append(buttons, *ikb)
+	}
+	ik, err := telegram.NewInlineKeyboardMarkup(gridKeyboard(buttons, 1))
+	if err != nil {
+		return "", 0, fmt.Errorf("EditRouter: %s", err)
+	}
+	msg, err := telegram.NewMsg(
+		telegram.WithMsgChat(update.FromChat()),
+		telegram.WithMsgText(question),
+		telegram.WithMsgReplyMarkup(ik),
+	)
+	if err != nil {
+		return "", 0, fmt.Errorf("EditRouter: %s", err)
+	}
+	_, err = sendMsg(tg, *msg, false)
+	if err != nil {
+		return "", 0, fmt.Errorf("EditRouter: %s", err)
+	}
+	return "", 0, nil
+}
+
+// EditCallback reads "edit <alert id> [price]", a zero price means the user picked an alert and needs a new target
+func EditCallback(update *telegram.Update) (string, float64, error) {
+	fields := strings.Fields(update.GetCallbackData())
+	if len(fields) < 2 {
+		return "", 0, errors.New("EditCallback: callback should contain an alert id")
+	}
+	if len(fields) == 2 {
+		return fields[1], 0, nil
+	}
+	price, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("EditCallback: %s", err)
+	}
+	return fields[1], price, nil
+}
+
+// editSteps are the moves offered for an alert's target in percent
+var editSteps = []float64{-5, -1, 1, 5}
+
+// SendEditOptions offers a few moves of the alert's target and tells how to type any other price
+func SendEditOptions(tg *telegram.Client, chatID int64, alert db.Alert) error {
+	chat, err := telegram.NewChat(telegram.WithChatId(chatID))
+	if err != nil {
+		return fmt.Errorf("SendEditOptions: %s", err)
+	}
+	buttons := make([]telegram.InlineKeyboardButton, 0, len(editSteps))
+	for _, v := range editSteps {
+		price := formatTarget(alert.TargetPrice * (1 + v/100))
+		ikb, err := telegram.NewInlineKeyboardButton(
+			telegram.WithIKBText(fmt.Sprintf("%+g%% (%s)", v, price)),
+			telegram.WithIKBCallbackData(fmt.Sprintf("edit %s %s", alert.Hex, price)),
+		)
+		if err != nil {
+			return fmt.Errorf("SendEditOptions: %s", err)
+		}
+		buttons = append(buttons, *ikb)
+	}
+	ik, err := telegram.NewInlineKeyboardMarkup(gridKeyboard(buttons, 2))
+	if err != nil {
+		return fmt.Errorf("SendEditOptions: %s", err)
+	}
+	text := fmt.Sprintf("New target for <b>%s</b> %s\nPick one or type <code>/edit %s &#60;price&#62;</code>",
+		DisplayPair(alert.Pair), html.EscapeString(alert.Describe()), alert.Hex)
+	msg, err := telegram.NewMsg(telegram.WithMsgChat(chat), telegram.WithMsgText(text), telegram.WithMsgReplyMarkup(ik))
+	if err != nil {
+		return fmt.Errorf("SendEditOptions: %s", err)
+	}
+	_, err = sendMsg(tg, *msg, false)
+	if err != nil {
+		return fmt.Errorf("SendEditOptions: %s", err)
+	}
+	return nil
+}
+
+func SendAlertEdited(tg *telegram.Client, chatID int64, alert db.Alert) error {
+	chat, err := telegram.NewChat(telegram.WithChatId(chatID))
+	if err != nil {
+		return fmt.Errorf("SendAlertEdited: %s", err)
+	}
+	text := fmt.Sprintf("<b>%s</b> alert moved to <b>%s</b>", DisplayPair(alert.Pair), html.EscapeString(alert.Describe()))
+	msg, err := telegram.NewMsg(telegram.WithMsgChat(chat), telegram.WithMsgText(text))
+	if err != nil {
+		return fmt.Errorf("SendAlertEdited: %s", err)
+	}
+	_, err = sendMsg(tg, *msg, false)
+	if err != nil {
+		return fmt.Errorf("SendAlertEdited: %s", err)
+	}
+	return nil
+}
+
+// DeleteCallbackMsg removes the message whose keyboard was used
+func DeleteCallbackMsg(tg *telegram.Client, callback *telegram.CallbackQuery) error {
+	if !deleteMsg(tg, callback.Msg.FromChatID(), callback.Msg.Id) {
+		return errors.New("DeleteCallbackMsg: could not delete a message")
+	}
+	return nil
+}
+
+// composeEditKeyboard offers to move price alerts, it returns nil when there are none
+func composeEditKeyboard(alerts []db.Alert) (*telegram.InlineKeyboardMarkup, error) {
+	buttons := make([]telegram.InlineKeyboardButton, 0, len(alerts))
+	for _, v := range alerts {
+		if v.GetKind() != db.KindPrice {
+			continue
+		}
+		ikb, err := telegram.NewInlineKeyboardButton(
+			telegram.WithIKBText(fmt.Sprintf("Edit %s %s", DisplayPair(v.Pair), v.Describe())),
+			telegram.WithIKBCallbackData(fmt.Sprintf("edit %s", v.Hex)),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("composeEditKeyboard: %s", err)
+		}
+		buttons = append(buttons, *ikb)
+	}
+	if len(buttons) == 0 {
+		return nil, nil
+	}
+	ik, err := telegram.NewInlineKeyboardMarkup(gridKeyboard(buttons, 2))
+	if err != nil {
+		return nil, fmt.Errorf("composeEditKeyboard: %s", err)
+	}
+	return ik, nil
+}
+
+// gridKeyboard lays buttons out in rows of perRow
+func gridKeyboard(buttons []telegram.InlineKeyboardButton, perRow int) [][]telegram.InlineKeyboardButton {
+	rows := make([][]telegram.InlineKeyboardButton, 0, len(buttons)/perRow+1)
+	for len(buttons) > 0 {
+		size := perRow
+		if len(buttons) < size {
+			size = len(buttons)
+		}
+		rows = append(rows, buttons[:size])
+		buttons = buttons[size:]
+	}
+	return rows
+}
+
+// formatTarget rounds a price to 6 significant digits without an exponent, so it survives the /edit regexp
+func formatTarget(price float64) string {
+	if price <= 0 {
+		return "0"
+	}
+	scale := math.Pow(10, 5-math.Floor(math.Log10(price)))
+	return strconv.FormatFloat(math.Round(price*scale)/scale, 'f', -1, 64)
+}
+
+// latestPrice asks the market for the price of pair, 0 means it's unknown
+func latestPrice(market string, pair string, client *http.Client) float64 {
+	exchange, err := GetExchange(market)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "latestPrice: %s\n", err)
+		return 0
+	}
+	price, err := exchange.LatestPrice(client, pair)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "latestPrice: %s\n", err)
+		return 0
+	}
+	return price
+}
+
+// formatRate shows a funding rate fraction as a percentage, e.g. "0.0100%"
+func formatRate(rate float64) string {
+	return fmt.Sprintf("%.4f%%", rate*100)
+}
+
+// formatPrice keeps cents for regular prices and every digit for the ones below 1
+func formatPrice(price float64) string {
+	if price < 1 {
+		return fmt.Sprintf("%f", price)
+	}
+	return fmt.Sprintf("%.2f", price)
+}
+
+func DisconnectRouter(update *telegram.Update, pairs []db.Alert, tg *telegram.Client) error {
 	ik, err := composeKeyboardMarkup(pairs)
 	if err != nil {
 		if err == ErrEmptyPairs {
@@ -129,7 +701,7 @@ func DisconnectRouter(update *telegram.Update, pairs []db.Alert, client *http.Cl
 			if err != nil {
 				return fmt.Errorf("DisconnectRouter: %s", err)
 			}
-			err = sendNDiscardMsg(client, *msg, false, 2)
+			err = sendNDiscardMsg(tg, *msg, false, 2)
 			if err != nil {
 				return fmt.Errorf("DisconnectRouter: %s", err)
 			}
@@ -137,103 +709,163 @@ func DisconnectRouter(update *telegram.Update, pairs []db.Alert, client *http.Cl
 		}
 		return fmt.Errorf("DisconnectRouter: %s", err)
 	}
-	err = sendDisconnectMsg(client, update.FromChat(), ik)
+	err = sendDisconnectMsg(tg, update.FromChat(), ik)
 	if err != nil {
 		return fmt.Errorf("DisconnectRouter: %s", err)
 	}
 	return nil
 }
 
-func PriceRouter(client *http.Client, update *telegram.Update, command string, regs map[string]*regexp.Regexp) error {
-	symbol := regs["splitter"].Split(command, 2)[1]
-	price, market, err := getLatestPrice(symbol, client)
+// DefaultsRouter parses "/defaults tol=<percent> cooldown=<duration>",
+// zero values mean the option wasn't set
+func DefaultsRouter(command string, update *telegram.Update, tg *telegram.Client) (float64, time.Duration, error) {
+	_, opts, err := parseAlertOptions(strings.Fields(command))
+	if err != nil {
+		sendErrMsg(tg, *update.FromChat(), err)
+		return 0, 0, fmt.Errorf("DefaultsRouter: %s", err)
+	}
+	return opts.tolerance, opts.cooldown, nil
+}
+
+func SendDefaults(tg *telegram.Client, chatID int64, user *db.MongoUser) error {
+	chat, err := telegram.NewChat(telegram.WithChatId(chatID))
+	if err != nil {
+		return fmt.Errorf("SendDefaults: %v", err)
+	}
+	text := fmt.Sprintf("&#9881; <b>Defaults</b>\nTolerance: <b>%g%%</b>\nCooldown: <b>%s</b>", user.GetTolerance()*100, user.GetCooldown())
+	msg, err := telegram.NewMsg(telegram.WithMsgText(text), telegram.WithMsgChat(chat))
+	if err != nil {
+		return fmt.Errorf("SendDefaults: %v", err)
+	}
+	_, err = sendMsg(tg, *msg, false)
+	if err != nil {
+		return fmt.Errorf("SendDefaults: %v", err)
+	}
+	return nil
+}
+
+func PriceRouter(tg *telegram.Client, client *http.Client, update *telegram.Update, command string, regs map[string]*regexp.Regexp) error {
+	input := regs["splitter"].Split(command, 2)[1]
+	price, market, symbol, err := getLatestPrice(input, client)
 	if err != nil {
-		sendNoPairErr(client, *update.FromChat(), strings.ToUpper(symbol))
+		sendPairErr(tg, *update.FromChat(), symbol, err, func(symbol string) string {
+			return "/price " + symbol
+		})
 		return fmt.Errorf("PriceRouter: %s\n", err)
 	}
-	decal := "&#128310;"
-	if market == Huobi {
-		decal = "&#128309;"
+	exchange, err := GetExchange(market)
+	if err != nil {
+		return fmt.Errorf("PriceRouter: %s\n", err)
 	}
-	msgText := fmt.Sprintf("%s <b>%s</b> - <b>%.2f</b>\n", decal, strings.ToUpper(symbol), price)
+	decal := exchange.Decal()
+	msgText := fmt.Sprintf("%s <b>%s</b> - <b>%.2f</b>\n", decal, DisplayPair(symbol), price)
 	if price < 1 {
-		msgText = fmt.Sprintf("%s <b>%s</b> - <b>%f</b>\n", decal, strings.ToUpper(symbol), price)
+		msgText = fmt.Sprintf("%s <b>%s</b> - <b>%f</b>\n", decal, DisplayPair(symbol), price)
 	}
 	msg, err := telegram.NewMsg(telegram.WithMsgText(msgText), telegram.WithMsgChat(update.FromChat()))
 	if err != nil {
 		return fmt.Errorf("PriceRouter: %s\n", err)
 	}
-	_, err = sendMsg(client, *msg, false)
+	_, err = sendMsg(tg, *msg, false)
 	if err != nil {
 		return fmt.Errorf("PriceRouter: %s\n", err)
 	}
 	return nil
 }
 
-func CallbackHandler(client *http.Client, callbackData string, regs map[string]*regexp.Regexp) string {
+func CallbackHandler(tg *telegram.Client, callbackData string, regs map[string]*regexp.Regexp) string {
 	switch {
 	case regs["disconnect"].MatchString(callbackData):
 		return "disconnect"
+	case regs["edit"].MatchString(callbackData):
+		return "edit"
+	case regs["conv"].MatchString(callbackData):
+		return "conversation"
+	case regs["retry"].MatchString(callbackData):
+		return "retry"
 	default:
 		return ""
 	}
 }
 
-func DisconnectCallback(update *telegram.Update, regs map[string]*regexp.Regexp, client *http.Client) (*telegram.CallbackQuery, error) {
+func DisconnectCallback(update *telegram.Update, regs map[string]*regexp.Regexp, tg *telegram.Client) (*telegram.CallbackQuery, error) {
 	if update.GetCallbackData() == "" {
 		return nil, errors.New("callback shouldn't be empty")
 	}
 
-	callbackData := regs["splitter"].Split(update.GetCallbackData(), 3)
-
-	if len(callbackData) == 2 {
-		update.CallbackQuery.SetData(callbackData[1])
-		return &update.CallbackQuery, nil
+	callbackData := regs["splitter"].Split(update.GetCallbackData(), 2)
+	if len(callbackData) < 2 {
+		return nil, errors.New("callback should contain an alert id")
 	}
-	update.CallbackQuery.SetData(fmt.Sprintf("%s %s", callbackData[1], callbackData[2]))
+	update.CallbackQuery.SetData(callbackData[1])
 	return &update.CallbackQuery, nil
 }
 
-func SplitCallbackData(data string) (pair string, market string) {
-	callback := strings.Split(data, " ")
-	pair = callback[0]
-	if len(callback) > 1 {
-		market = callback[1]
+// RetryCallback turns a "Did you mean" button back into the command it stands for,
+// the update can be handled as if the user typed the command
+func RetryCallback(update *telegram.Update) (*telegram.Update, error) {
+	callback := update.CallbackQuery
+	command := callback.Data
+	if !strings.HasPrefix(command, "/") {
+		return nil, fmt.Errorf("RetryCallback: %q isn't a command", command)
 	}
-	return
-}
-
-func SendCallbackAnswer(client *http.Client, callbackAnswer *telegram.CallbackAnswer) error {
-	data, err := json.Marshal(callbackAnswer)
+	name, _, _ := strings.Cut(command, " ")
+	entity, err := telegram.NewMsgEntity(telegram.WithMEType("bot_command"))
 	if err != nil {
-		return fmt.Errorf("SendCallbackAnswer: %s", err)
+		return nil, fmt.Errorf("RetryCallback: %s", err)
+	}
+	entity.Length = len(name)
+	retry, err := telegram.NewUpdate()
+	if err != nil {
+		return nil, fmt.Errorf("RetryCallback: %s", err)
 	}
+	retry.Id = update.Id
+	retry.Msg = telegram.Message{
+		From:     callback.From,
+		Chat:     callback.Msg.Chat,
+		Text:     command,
+		Entities: []telegram.MessageEntity{*entity},
+	}
+	return retry, nil
+}
 
-	body := bytes.NewReader(data)
-	_, err = client.Post(fmt.Sprintf("https://api.telegram.org/bot%s/answerCallbackQuery", os.Getenv("TG")), "application/json", body)
+func SendCallbackAnswer(tg *telegram.Client, callbackAnswer *telegram.CallbackAnswer) error {
+	err := tg.AnswerCallbackQuery(context.Background(), callbackAnswer)
 	if err != nil {
 		return fmt.Errorf("SendCallbackAnswer: %s", err)
 	}
 	return nil
 }
 
-func SendAlert(client *http.Client, chatID int64, symbol string, price float64) error {
+// SendAlert queues the notification so a burst of fired alerts never holds up the price feed
+func SendAlert(outbox *Outbox, chatID int64, alert db.Alert, price float64) error {
 	chat, err := telegram.NewChat(telegram.WithChatId(chatID))
 	if err != nil {
 		return fmt.Errorf("SendAlert: %s", err)
 	}
-	msg, err := telegram.NewMsg(telegram.WithMsgText(fmt.Sprintf("&#128680; <b>%s</b> - <b>%.2f</b>", strings.ToUpper(symbol), price)), telegram.WithMsgChat(chat))
+	text := fmt.Sprintf("&#128680; <b>%s</b> - <b>%.2f</b> (%s)", DisplayPair(alert.Pair), price, html.EscapeString(alert.Describe()))
+	switch alert.GetKind() {
+	case db.KindFunding:
+		text = fmt.Sprintf("&#128680; <b>%s</b> - funding <b>%s</b> (%s)", DisplayPair(alert.Pair), formatRate(price), html.EscapeString(alert.Describe()))
+	case db.KindChange:
+		text = fmt.Sprintf("&#128680; <b>%s</b> - moved <b>%+.2f%%</b> (%s)", DisplayPair(alert.Pair), price*100, html.EscapeString(alert.Describe()))
+	}
+	msg, err := telegram.NewMsg(telegram.WithMsgText(text), telegram.WithMsgChat(chat))
 	if err != nil {
 		return fmt.Errorf("SendAlert: %s", err)
 	}
-	_, err = sendMsg(client, *msg, true)
+	sendObj, err := newSendMsgObj(*msg, true)
+	if err != nil {
+		return fmt.Errorf("SendAlert: %s", err)
+	}
+	err = outbox.Enqueue(sendObj)
 	if err != nil {
 		return fmt.Errorf("SendAlert: %v", err)
 	}
 	return nil
 }
 
-func SendAlertConfirmed(client *http.Client, chatID int64) error {
+func SendAlertConfirmed(tg *telegram.Client, chatID int64) error {
 	chat, err := telegram.NewChat(telegram.WithChatId(chatID))
 	if err != nil {
 		return fmt.Errorf("SendAlertConfirmed: %v", err)
@@ -242,23 +874,78 @@ func SendAlertConfirmed(client *http.Client, chatID int64) error {
 	if err != nil {
 		return fmt.Errorf("SendAlertConfirmed: %v", err)
 	}
-	_, err = sendMsg(client, *msg, false)
+	_, err = sendMsg(tg, *msg, false)
 	if err != nil {
 		return fmt.Errorf("SendAlertConfirmed: %v", err)
 	}
 	return nil
 }
 
-func sendNoPairErr(client *http.Client, chat telegram.Chat, pair string) error {
-	msg, err := telegram.NewMsg(telegram.WithMsgChat(&chat), telegram.WithMsgText(fmt.Sprintf("Can't find <b>%s</b> &#129301;", pair)))
+// maxSuggestions caps the "Did you mean" buttons
+const maxSuggestions = 4
+
+// sendPairErr tells the user why pair can't be used, close pairs are suggested when it isn't listed
+func sendPairErr(tg *telegram.Client, chat telegram.Chat, pair string, err error, retry func(symbol string) string) error {
+	if errors.Is(err, ErrHalted) || errors.Is(err, ErrNoMarket) {
+		return sendErrMsg(tg, chat, err)
+	}
+	return sendNoPairErr(tg, chat, pair, retry)
+}
+
+// sendNoPairErr tells the user the pair isn't listed and suggests close ones from DefaultCatalog,
+// retry builds the callback data of a suggestion's button, nil means no buttons
+func sendNoPairErr(tg *telegram.Client, chat telegram.Chat, pair string, retry func(symbol string) string) error {
+	text := fmt.Sprintf("Can't find <b>%s</b> &#129301;", html.EscapeString(strings.ToUpper(pair)))
+	opts := []telegram.MsgOptions{telegram.WithMsgChat(&chat)}
+
+	suggestions := DefaultCatalog.Suggest(pair, maxSuggestions)
+	if len(suggestions) > 0 {
+		names := make([]string, len(suggestions))
+		buttons := make([]telegram.InlineKeyboardButton, 0, len(suggestions))
+		for i, v := range suggestions {
+			names[i] = v.Pair().Display()
+			if retry == nil {
+				continue
+			}
+			// Telegram refuses callback data over 64 bytes
+			data := retry(v.Symbol)
+			if len(data) > 64 {
+				continue
+			}
+			ikb, err := telegram.NewInlineKeyboardButton(telegram.WithIKBText(names[i]), telegram.WithIKBCallbackData(data))
+			if err != nil {
+				return fmt.Errorf("sendNoPairErr: %v", err)
+			}
+			buttons = append(buttons, *ikb)
+		}
+		text += fmt.Sprintf("\nDid you mean <b>%s</b>?", strings.Join(names, "</b> / <b>"))
+		if len(buttons) > 0 {
+			ik, err := telegram.NewInlineKeyboardMarkup(gridKeyboard(buttons, 2))
+			if err != nil {
+				return fmt.Errorf("sendNoPairErr: %v", err)
+			}
+			opts = append(opts, telegram.WithMsgReplyMarkup(ik))
+		}
+	}
+
+	msg, err := telegram.NewMsg(append(opts, telegram.WithMsgText(text))...)
 	if err != nil {
 		return fmt.Errorf("sendNoPairErr: %v", err)
 	}
-	_, err = sendMsg(client, *msg, false)
+	_, err = sendMsg(tg, *msg, false)
 	return err
 }
 
-func SendAlertExist(client *http.Client, chatID int64, pair string) error {
+func sendErrMsg(tg *telegram.Client, chat telegram.Chat, err error) error {
+	msg, err := telegram.NewMsg(telegram.WithMsgChat(&chat), telegram.WithMsgText(fmt.Sprintf("&#9940; %s", html.EscapeString(err.Error()))))
+	if err != nil {
+		return fmt.Errorf("sendErrMsg: %v", err)
+	}
+	_, err = sendMsg(tg, *msg, false)
+	return err
+}
+
+func SendAlertExist(tg *telegram.Client, chatID int64, pair string) error {
 	chat, err := telegram.NewChat(telegram.WithChatId(chatID))
 	if err != nil {
 		return fmt.Errorf("SendAlertExist: %v", err)
@@ -267,15 +954,15 @@ func SendAlertExist(client *http.Client, chatID int64, pair string) error {
 	if err != nil {
 		return fmt.Errorf("SendAlertExist: %v", err)
 	}
-	_, err = sendMsg(client, *msg, false)
+	_, err = sendMsg(tg, *msg, false)
 	if err != nil {
 		return fmt.Errorf("SendAlertExist: %v", err)
 	}
 	return nil
 }
 
-func sendMsg(client *http.Client, msg telegram.Message, notify bool) (*telegram.Message, error) {
-	sendObj, err := telegram.NewSendMsgObj(
+func newSendMsgObj(msg telegram.Message, notify bool) (*telegram.SendMsgObj, error) {
+	return telegram.NewSendMsgObj(
 		telegram.WithSendChatId(msg.FromChatID()),
 		telegram.WithSendText(msg.Text),
 		telegram.WithSendParseMode("HTML"),
@@ -283,48 +970,33 @@ func sendMsg(client *http.Client, msg telegram.Message, notify bool) (*telegram.
 		telegram.WithSendAllowReply(true),
 		telegram.WithSendReplyMarkup(msg.ReplyMarkup),
 	)
+}
 
+func sendMsg(tg *telegram.Client, msg telegram.Message, notify bool) (*telegram.Message, error) {
+	sendObj, err := newSendMsgObj(msg, notify)
 	if err != nil {
 		return nil, fmt.Errorf("sendMsg: %s", err)
 	}
 
-	data, err := json.Marshal(sendObj)
-	if err != nil {
-		return nil, fmt.Errorf("sendMsg: %s", err)
-	}
-	body := bytes.NewReader(data)
-	resp, err := client.Post(fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", os.Getenv("TG")), "application/json", body)
-	if err != nil {
-		return nil, fmt.Errorf("sendMsg: %s", err)
-	}
-
-	data, err = io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("sendMsg: %s", err)
-	}
-	defer resp.Body.Close()
-
-	responseMsg := telegram.NewResponseMessage()
-
-	err = json.Unmarshal(data, responseMsg)
+	responseMsg, err := tg.SendMessage(context.Background(), sendObj)
 	if err != nil {
 		return nil, fmt.Errorf("sendMsg: %s", err)
 	}
 
-	return &responseMsg.Result, nil
+	return responseMsg, nil
 }
 
-func sendNDiscardMsg(client *http.Client, msg telegram.Message, notify bool, cacheTimer int) error {
-	respMsg, err := sendMsg(client, msg, notify)
+func sendNDiscardMsg(tg *telegram.Client, msg telegram.Message, notify bool, cacheTimer int) error {
+	respMsg, err := sendMsg(tg, msg, notify)
 	if err != nil {
 		return fmt.Errorf("sendNDiscardMsg: %s", err)
 	}
 	<-time.After(time.Duration(cacheTimer) * time.Second)
-	deleteMsg(client, respMsg.FromChatID(), respMsg.Id)
+	deleteMsg(tg, respMsg.FromChatID(), respMsg.Id)
 	return nil
 }
 
-func sendDisconnectMsg(client *http.Client, chat *telegram.Chat, ik *telegram.InlineKeyboardMarkup) error {
+func sendDisconnectMsg(tg *telegram.Client, chat *telegram.Chat, ik *telegram.InlineKeyboardMarkup) error {
 
 	msg, err := telegram.NewMsg(
 		telegram.WithMsgChat(chat),
@@ -336,7 +1008,7 @@ func sendDisconnectMsg(client *http.Client, chat *telegram.Chat, ik *telegram.In
 		return fmt.Errorf("sendDisconnectMsg: %s", err)
 	}
 
-	_, err = sendMsg(client, *msg, false)
+	_, err = sendMsg(tg, *msg, false)
 	if err != nil {
 		return fmt.Errorf("sendDisconnectMsg: %s", err)
 	}
@@ -344,11 +1016,11 @@ func sendDisconnectMsg(client *http.Client, chat *telegram.Chat, ik *telegram.In
 	return nil
 }
 
-func EditMarkup(client *http.Client, callback *telegram.CallbackQuery, pairs []db.Alert) error {
+func EditMarkup(tg *telegram.Client, callback *telegram.CallbackQuery, pairs []db.Alert) error {
 	if len(pairs) == 0 {
 		// delete msg
 		fmt.Println(len(pairs))
-		if !deleteMsg(client, callback.Msg.FromChatID(), callback.Msg.Id) {
+		if !deleteMsg(tg, callback.Msg.FromChatID(), callback.Msg.Id) {
 			return errors.New("could not delete a message")
 		}
 		return nil
@@ -367,19 +1039,15 @@ func EditMarkup(client *http.Client, callback *telegram.CallbackQuery, pairs []d
 		return fmt.Errorf("EditMarkup: %s", err)
 	}
 
-	editMSGReplyMarkup(client, editMarkup)
-	fmt.Println("EDIT_MARKUP")
-	return nil
-}
-
-func editMSGReplyMarkup(client *http.Client, editMarkup *telegram.EditMarkupObj) error {
-	data, err := json.Marshal(editMarkup)
+	err = editMSGReplyMarkup(tg, editMarkup)
 	if err != nil {
-		return fmt.Errorf("editMSGReplyMarkup: %s", err)
+		return fmt.Errorf("EditMarkup: %s", err)
 	}
+	return nil
+}
 
-	body := bytes.NewReader(data)
-	_, err = client.Post(fmt.Sprintf("https://api.telegram.org/bot%s/editMessageReplyMarkup", os.Getenv("TG")), "application/json", body)
+func editMSGReplyMarkup(tg *telegram.Client, editMarkup *telegram.EditMarkupObj) error {
+	err := tg.EditMessageReplyMarkup(context.Background(), editMarkup)
 	if err != nil {
 		return fmt.Errorf("editMSGReplyMarkup: %s", err)
 	}
@@ -402,8 +1070,8 @@ func composeKeyboardMarkup(pairs []db.Alert) (*telegram.InlineKeyboardMarkup, er
 
 		for i, v := range pairs {
 			ikb, err := telegram.NewInlineKeyboardButton(
-				telegram.WithIKBText(v.Pair),
-				telegram.WithIKBCallbackData(fmt.Sprintf("disconnect %s %s", v.Pair, v.Market)),
+				telegram.WithIKBText(fmt.Sprintf("%s %s", DisplayPair(v.Pair), v.Describe())),
+				telegram.WithIKBCallbackData(fmt.Sprintf("disconnect %s", v.Hex)),
 			)
 			if err != nil {
 				return nil, fmt.Errorf("composeKeyboardMarkup: %s", err)
@@ -443,32 +1111,11 @@ func composeKeyboardMarkup(pairs []db.Alert) (*telegram.InlineKeyboardMarkup, er
 
 }
 
-func deleteMsg(client *http.Client, chatID int64, msgID int) bool {
-	data, err := json.Marshal(telegram.NewDeleteMsgObj(chatID, msgID))
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return false
-	}
-	resp, err := client.Post(fmt.Sprintf("https://api.telegram.org/bot%s/deleteMessage", os.Getenv("TG")), "application/json", bytes.NewReader(data))
+func deleteMsg(tg *telegram.Client, chatID int64, msgID int) bool {
+	err := tg.DeleteMessage(context.Background(), chatID, msgID)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return false
 	}
-	defer resp.Body.Close()
-
-	ok := &telegram.DeleteResult{}
-
-	data, err = io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return false
-	}
-
-	err = json.Unmarshal(data, ok)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return false
-	}
-
-	return ok.Ok
+	return true
 }
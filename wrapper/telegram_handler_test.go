@@ -0,0 +1,340 @@
+package wrapper
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	db "github.com/HomelessHunter/CTC/db/models"
+	telegram "github.com/HomelessHunter/CTC/wrapper/models/telegram"
+	"github.com/HomelessHunter/CTC/wrapper/models/telegram/telegramtest"
+)
+
+func TestParseAlertCommand(t *testing.T) {
+	cases := []struct {
+		command   string
+		pair      string
+		condition string
+		price     float64
+	}{
+		{"/alert btcusdt 53400", "btcusdt", db.ConditionNear, 53400},
+		{"/alert btcusdt > 70000", "btcusdt", db.ConditionAbove, 70000},
+		{"/alert btcusdt <60000.5", "btcusdt", db.ConditionBelow, 60000.5},
+		{"/alert ethusdt cross 3000", "ethusdt", db.ConditionCross, 3000},
+	}
+	for _, v := range cases {
+		pair, condition, price, err := parseAlertCommand(v.command)
+		if err != nil {
+			t.Errorf("%s: %s", v.command, err)
+			continue
+		}
+		if pair != v.pair || condition != v.condition || price != v.price {
+			t.Errorf("%s: got %s %s %f", v.command, pair, condition, price)
+		}
+	}
+	if _, _, _, err := parseAlertCommand("/alert btcusdt around 3000"); err == nil {
+		t.Error("unknown condition should fail")
+	}
+}
+
+func TestParseFundingCommand(t *testing.T) {
+	cases := []struct {
+		command   string
+		condition string
+		rate      float64
+	}{
+		{"/funding btcusdt > 0.05%", db.ConditionAbove, 0.0005},
+		{"/funding btcusdt <-0.01%", db.ConditionBelow, -0.0001},
+		{"/funding btcusdt cross 0.01", db.ConditionCross, 0.0001},
+	}
+	for _, v := range cases {
+		pair, condition, rate, err := parseFundingCommand(v.command)
+		if err != nil {
+			t.Errorf("%s: %s", v.command, err)
+			continue
+		}
+		if pair != "btcusdt" || condition != v.condition || math.Abs(rate-v.rate) > 1e-12 {
+			t.Errorf("%s: got %s %s %g", v.command, pair, condition, rate)
+		}
+	}
+	if _, _, _, err := parseFundingCommand("/funding btcusdt 0.05%"); err == nil {
+		t.Error("a funding alert without a direction should fail")
+	}
+}
+
+func TestParseChangeCommand(t *testing.T) {
+	cases := []struct {
+		command   string
+		direction string
+		percent   float64
+		window    time.Duration
+	}{
+		{"/change solusdt 5% 1h", db.DirectionEither, 0.05, time.Hour},
+		{"/change solusdt up 2.5% 15m", db.DirectionUp, 0.025, 15 * time.Minute},
+		{"/change solusdt DOWN 10 1d", db.DirectionDown, 0.1, 24 * time.Hour},
+	}
+	for _, v := range cases {
+		pair, direction, percent, window, err := parseChangeCommand(v.command)
+		if err != nil {
+			t.Errorf("%s: %s", v.command, err)
+			continue
+		}
+		if pair != "solusdt" || direction != v.direction || math.Abs(percent-v.percent) > 1e-12 || window != v.window {
+			t.Errorf("%s: got %s %s %g %s", v.command, pair, direction, percent, window)
+		}
+	}
+	for _, command := range []string{"/change solusdt sideways 5% 1h", "/change solusdt 5% 2d", "/change solusdt 0% 1h"} {
+		if _, _, _, _, err := parseChangeCommand(command); err == nil {
+			t.Errorf("%s should fail", command)
+		}
+	}
+}
+
+func TestParseAlertOptions(t *testing.T) {
+	fields, opts, err := parseAlertOptions(strings.Fields("/alert ethusdt 3000 tol=0.2% recurring cooldown=1h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(fields, " ") != "/alert ethusdt 3000" || opts.tolerance != 0.002 || opts.cooldown != time.Hour || opts.mode != db.ModeRecurring {
+		t.Errorf("got %v %+v", fields, opts)
+	}
+	if _, _, err = parseAlertOptions([]string{"/alert", "ethusdt", "3000", "size=2"}); err == nil {
+		t.Error("unknown option should fail")
+	}
+}
+
+func newTestUpdate(text string) *telegram.Update {
+	return &telegram.Update{Id: 1, Msg: telegram.Message{
+		Id:   10,
+		From: telegram.User{Id: 7},
+		Chat: telegram.Chat{Id: 7},
+		Text: text,
+	}}
+}
+
+func TestAlertRouterBadOption(t *testing.T) {
+	srv := telegramtest.NewServer()
+	defer srv.Close()
+
+	_, err := AlertRouter("/alert btcusdt 70000 tol=abc", nil, newTestUpdate(""), srv.TGClient(), srv.Client())
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	sent := srv.Sent()
+	if len(sent) != 1 || sent[0].ChatId != 7 || !strings.HasPrefix(sent[0].Text, "&#9940;") {
+		t.Errorf("user wasn't told about the error: %+v", sent)
+	}
+}
+
+func TestHistoryRouter(t *testing.T) {
+	srv := telegramtest.NewServer()
+	defer srv.Close()
+
+	now := time.Now()
+	history := []db.Alert{
+		{Market: "binance", Pair: "ethusdt", TargetPrice: 3000, TriggeredAt: now.Add(-time.Hour)},
+		{Market: "binance", Pair: "btcusdt", TargetPrice: 70000, TriggeredAt: now},
+	}
+	err := HistoryRouter(newTestUpdate("/history"), history, srv.TGClient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := srv.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("expected one message, got %d", len(sent))
+	}
+	if strings.Index(sent[0].Text, "BTCUSDT") > strings.Index(sent[0].Text, "ETHUSDT") {
+		t.Errorf("newest alert should come first: %s", sent[0].Text)
+	}
+}
+
+func TestDisconnectRouter(t *testing.T) {
+	srv := telegramtest.NewServer()
+	defer srv.Close()
+
+	alert, _ := db.NewAlert(db.WithPair("btcusdt"), db.WithMarket("binance"), db.WithTargetPrice(70000))
+	err := DisconnectRouter(newTestUpdate("/disconnect"), []db.Alert{*alert}, srv.TGClient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := srv.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("expected one message, got %d", len(sent))
+	}
+	buttons := sent[0].ReplyMarkup.InlineKeyboard
+	if len(buttons) != 1 || len(buttons[0]) != 2 {
+		t.Fatalf("unexpected keyboard %+v", buttons)
+	}
+	if buttons[0][0].CallbackData != "disconnect "+alert.Hex || buttons[0][1].CallbackData != "disconnect all" {
+		t.Errorf("unexpected callbacks %+v", buttons)
+	}
+}
+
+func TestEditMarkupDeletesEmptyKeyboard(t *testing.T) {
+	srv := telegramtest.NewServer()
+	defer srv.Close()
+
+	callback := &telegram.CallbackQuery{Id: "1", Msg: telegram.Message{Id: 10, Chat: telegram.Chat{Id: 7}}}
+	err := EditMarkup(srv.TGClient(), callback, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.Calls("deleteMessage")) != 1 {
+		t.Errorf("message wasn't deleted: %+v", srv.Calls(""))
+	}
+
+	srv.Fail("deleteMessage", 400, "Bad Request: message can't be deleted", 0)
+	if err = EditMarkup(srv.TGClient(), callback, nil); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestListRouter(t *testing.T) {
+	srv := telegramtest.NewServer()
+	defer srv.Close()
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	alerts := []db.Alert{
+		{Market: "binance", Pair: "ethusdt", TargetPrice: 3000, Condition: db.ConditionBelow, LastPrice: 3200},
+		{Market: "binance", Pair: "btcusdt", TargetPrice: 70000, Condition: db.ConditionAbove, LastPrice: 56000, LastSignal: now},
+	}
+	err := ListRouter(newTestUpdate("/list"), alerts, srv.TGClient(), srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := srv.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("expected one message, got %d", len(sent))
+	}
+	text := sent[0].Text
+	for _, want := range []string{"56000.00 (+25.00%)", "3200.00 (-6.25%)", "2024-03-01 12:00 UTC", "never"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("%q is missing in %s", want, text)
+		}
+	}
+	if strings.Index(text, "BTCUSDT") > strings.Index(text, "ETHUSDT") {
+		t.Errorf("alerts should be sorted by pair: %s", text)
+	}
+
+	err = ListRouter(newTestUpdate("/list"), nil, srv.TGClient(), srv.Client())
+	if err != nil || !strings.Contains(srv.Sent()[1].Text, "no alerts") {
+		t.Errorf("empty list should say so, got %v", err)
+	}
+}
+
+func TestEditRouter(t *testing.T) {
+	srv := telegramtest.NewServer()
+	defer srv.Close()
+
+	alerts := []db.Alert{
+		{Market: "binance", Pair: "btcusdt", TargetPrice: 70000, Hex: "a1"},
+		{Market: "huobi", Pair: "btcusdt", TargetPrice: 68000, Hex: "b2"},
+		{Market: "binance", Pair: "ethusdt", TargetPrice: 3000, Hex: "c3"},
+	}
+	hex, price, err := EditRouter("/edit ethusdt 3100.5", newTestUpdate(""), alerts, srv.TGClient())
+	if err != nil || hex != "c3" || price != 3100.5 {
+		t.Errorf("unexpected edit %s %g (%v)", hex, price, err)
+	}
+	hex, _, err = EditRouter("/edit b2 69000", newTestUpdate(""), alerts, srv.TGClient())
+	if err != nil || hex != "b2" {
+		t.Errorf("alert id should pick the alert, got %s (%v)", hex, err)
+	}
+	hex, _, err = EditRouter("/edit ETH/USDT 3100", newTestUpdate(""), alerts, srv.TGClient())
+	if err != nil || hex != "c3" {
+		t.Errorf("pairs should be read like /alert reads them, got %s (%v)", hex, err)
+	}
+	hex, _, err = EditRouter("/edit huobi:btc-usdt 69000", newTestUpdate(""), alerts, srv.TGClient())
+	if err != nil || hex != "b2" {
+		t.Errorf("a pinned market should pick its alert, got %s (%v)", hex, err)
+	}
+
+	hex, _, err = EditRouter("/edit btcusdt 69000", newTestUpdate(""), alerts, srv.TGClient())
+	if err != nil || hex != "" {
+		t.Fatalf("several alerts should ask which one, got %s (%v)", hex, err)
+	}
+	sent := srv.Sent()
+	if len(sent) != 1 || len(sent[0].ReplyMarkup.InlineKeyboard) != 2 {
+		t.Fatalf("expected a choice between two alerts, got %v", sent)
+	}
+	if data := sent[0].ReplyMarkup.InlineKeyboard[1][0].CallbackData; data != "edit b2 69000" {
+		t.Errorf("unexpected callback %s", data)
+	}
+
+	if _, _, err = EditRouter("/edit solusdt 100", newTestUpdate(""), alerts, srv.TGClient()); err == nil {
+		t.Error("unknown pair should fail")
+	}
+
+	// without a price the alert is picked and moves are offered next
+	hex, price, err = EditRouter("/edit c3", newTestUpdate(""), alerts, srv.TGClient())
+	if err != nil || hex != "c3" || price != 0 {
+		t.Errorf("unexpected edit %s %g (%v)", hex, price, err)
+	}
+	hex, _, err = EditRouter("/edit btcusdt", newTestUpdate(""), alerts, srv.TGClient())
+	if err != nil || hex != "" {
+		t.Fatalf("several alerts should ask which one, got %s (%v)", hex, err)
+	}
+	sent = srv.Sent()
+	if data := sent[len(sent)-1].ReplyMarkup.InlineKeyboard[0][0].CallbackData; data != "edit a1" {
+		t.Errorf("a choice without a price should ask for one, got %s", data)
+	}
+}
+
+func TestEditCallback(t *testing.T) {
+	update := newTestUpdate("")
+	update.CallbackQuery.Data = "edit a1 71000"
+	hex, price, err := EditCallback(update)
+	if err != nil || hex != "a1" || price != 71000 {
+		t.Errorf("unexpected edit %s %g (%v)", hex, price, err)
+	}
+	update.CallbackQuery.Data = "edit a1"
+	hex, price, err = EditCallback(update)
+	if err != nil || hex != "a1" || price != 0 {
+		t.Errorf("unexpected edit %s %g (%v)", hex, price, err)
+	}
+}
+
+func TestFormatTarget(t *testing.T) {
+	cases := map[float64]string{
+		70000 * 1.01:   "70700",
+		1234567:        "1234570",
+		0.012345678:    "0.0123457",
+		3000 * 0.95:    "2850",
+		0.000001234567: "0.00000123457",
+	}
+	for price, want := range cases {
+		if got := formatTarget(price); got != want {
+			t.Errorf("formatTarget(%g) = %s, want %s", price, got, want)
+		}
+	}
+}
+
+func TestConversationCallback(t *testing.T) {
+	update := newTestUpdate("")
+	update.CallbackQuery.Data = "conv pair btcusdt"
+	action, value, err := ConversationCallback(update)
+	if err != nil || action != ConvPair || value != "btcusdt" {
+		t.Errorf("unexpected callback %s %s (%v)", action, value, err)
+	}
+	update.CallbackQuery.Data = "conv cancel"
+	if action, _, _ = ConversationCallback(update); action != ConvCancel {
+		t.Errorf("unexpected action %s", action)
+	}
+	update.CallbackQuery.Data = "edit a1"
+	if _, _, err = ConversationCallback(update); err == nil {
+		t.Error("other callbacks should fail")
+	}
+}
+
+func TestParseTargetPrice(t *testing.T) {
+	for input, want := range map[string]float64{"70000": 70000, " 70 000 ": 70000, "70,000.5": 70000.5, "0.0012": 0.0012} {
+		if got, err := ParseTargetPrice(input); err != nil || got != want {
+			t.Errorf("ParseTargetPrice(%q) = %g (%v)", input, got, err)
+		}
+	}
+	for _, input := range []string{"", "abc", "-5", "0"} {
+		if _, err := ParseTargetPrice(input); err == nil {
+			t.Errorf("%q should fail", input)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package wrapper
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// windowResolution is the minimum gap between two kept samples, it bounds a day of
+	// history to about 17k samples per pair however often the market ticks
+	windowResolution = 5 * time.Second
+	// windowSpan is how much history is kept, the longest window change alerts look at
+	windowSpan = 24 * time.Hour
+	// windowPrune is how often pairs nobody records anymore are dropped
+	windowPrune = time.Minute
+)
+
+type sample struct {
+	at    time.Time
+	price float64
+}
+
+// PriceWindow keeps a rolling in-memory price history per market pair for change alerts.
+// Pairs stop costing memory once they're no longer recorded, prune drops them
+type PriceWindow struct {
+	mu      sync.Mutex
+	samples map[string][]sample
+	pruned  time.Time
+}
+
+func NewPriceWindow() *PriceWindow {
+	return &PriceWindow{samples: make(map[string][]sample)}
+}
+
+// Record adds the price of market pair seen at now. Samples closer than windowResolution
+// to the previous one are skipped and the ones older than windowSpan are dropped
+func (w *PriceWindow) Record(market, pair string, price float64, now time.Time) {
+	key := market + ":" + pair
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	samples := w.samples[key]
+	if len(samples) > 0 && now.Sub(samples[len(samples)-1].at) < windowResolution {
+		return
+	}
+	samples = append(samples, sample{at: now, price: price})
+	w.samples[key] = trimSamples(samples, now)
+
+	if now.Sub(w.pruned) >= windowPrune {
+		w.prune(now)
+	}
+}
+
+// Change is how much price moved since the oldest sample within window, as a fraction.
+// A history shorter than window is compared as is, it reports false without any history
+func (w *PriceWindow) Change(market, pair string, price float64, window time.Duration, now time.Time) (float64, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	samples := w.samples[market+":"+pair]
+	since := now.Add(-window)
+	// samples are in time order
+	i := sort.Search(len(samples), func(i int) bool {
+		return !samples[i].at.Before(since)
+	})
+	if i == len(samples) || samples[i].price == 0 {
+		return 0, false
+	}
+	return (price - samples[i].price) / samples[i].price, true
+}
+
+// prune drops pairs whose newest sample is out of the span, it must be called with mu held
+func (w *PriceWindow) prune(now time.Time) {
+	w.pruned = now
+	for key, samples := range w.samples {
+		if now.Sub(samples[len(samples)-1].at) > windowSpan {
+			delete(w.samples, key)
+		}
+	}
+}
+
+// trimSamples drops the samples older than windowSpan, keeping the backing array
+// from growing forever
+func trimSamples(samples []sample, now time.Time) []sample {
+	i := 0
+	for i < len(samples) && now.Sub(samples[i].at) > windowSpan {
+		i++
+	}
+	if i == 0 {
+		return samples
+	}
+	return append(samples[:0], samples[i:]...)
+}
@@ -0,0 +1,55 @@
+package wrapper
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPriceWindowChange(t *testing.T) {
+	w := NewPriceWindow()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, ok := w.Change(Binance, "solusdt", 100, time.Hour, start); ok {
+		t.Error("a pair without history shouldn't report a change")
+	}
+
+	w.Record(Binance, "solusdt", 100, start)
+	w.Record(Binance, "solusdt", 101, start.Add(time.Second))
+	w.Record(Binance, "solusdt", 110, start.Add(30*time.Minute))
+	w.Record(Binance, "solusdt", 120, start.Add(90*time.Minute))
+
+	cases := []struct {
+		window time.Duration
+		want   float64
+	}{
+		// the sample a second after the first one is inside the resolution and skipped
+		{24 * time.Hour, 0.05},
+		{time.Hour, 105.0/110 - 1},
+		{time.Minute, 105.0/120 - 1},
+	}
+	now := start.Add(90 * time.Minute)
+	for _, v := range cases {
+		change, ok := w.Change(Binance, "solusdt", 105, v.window, now)
+		if !ok || math.Abs(change-v.want) > 1e-9 {
+			t.Errorf("%s: expected %f, got %f %t", v.window, v.want, change, ok)
+		}
+	}
+	if _, ok := w.Change(Huobi, "solusdt", 105, time.Hour, now); ok {
+		t.Error("histories are kept per market")
+	}
+}
+
+func TestPriceWindowDropsOldSamples(t *testing.T) {
+	w := NewPriceWindow()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	w.Record(Binance, "solusdt", 100, start)
+	w.Record(Binance, "ethusdt", 3000, start)
+	w.Record(Binance, "solusdt", 200, start.Add(25*time.Hour))
+
+	if len(w.samples["binance:solusdt"]) != 1 {
+		t.Errorf("samples older than a day should be trimmed, got %v", w.samples["binance:solusdt"])
+	}
+	if _, ok := w.samples["binance:ethusdt"]; ok {
+		t.Error("pairs that stopped recording should be pruned")
+	}
+}